@@ -0,0 +1,9 @@
+package catalog
+
+import "errors"
+
+var (
+	ErrEntryNotFound = errors.New("catalog entry not found")
+	ErrEntryExists   = errors.New("catalog entry already exists")
+	ErrInvalidEntry  = errors.New("invalid catalog entry")
+)
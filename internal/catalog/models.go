@@ -0,0 +1,118 @@
+package catalog
+
+import "strings"
+
+// Unit is what material prices are quoted per, filament is weighed and resin
+// is measured by volume
+type Unit string
+
+const (
+	UnitGram       Unit = "g"
+	UnitMilliliter Unit = "ml"
+)
+
+func (u Unit) IsValid() bool {
+	return u == UnitGram || u == UnitMilliliter
+}
+
+type Kind string
+
+const (
+	KindTechnology Kind = "tech"
+	KindMaterial   Kind = "material"
+	KindColor      Kind = "color"
+)
+
+// Technology codes are stored uppercased and are what orders keep as their print type
+type Technology struct {
+	Code   string
+	Name   string
+	Unit   Unit
+	Active bool
+}
+
+type Material struct {
+	ID         int
+	Technology string
+	Name       string
+	// Price is per unit of the technology
+	Price  float32
+	Active bool
+}
+
+type Color struct {
+	ID     int
+	Name   string
+	Active bool
+}
+
+type Catalog struct {
+	Technologies []Technology
+	Materials    []Material
+	Colors       []Color
+}
+
+func (c *Catalog) Technology(code string) (*Technology, bool) {
+	for i := range c.Technologies {
+		if strings.EqualFold(c.Technologies[i].Code, code) {
+			return &c.Technologies[i], true
+		}
+	}
+	return nil, false
+}
+
+// MaterialsFor returns the materials of the technology, all of them when the
+// technology has none
+func (c *Catalog) MaterialsFor(technology string) []Material {
+	var materials []Material
+	for _, m := range c.Materials {
+		if strings.EqualFold(m.Technology, technology) {
+			materials = append(materials, m)
+		}
+	}
+	if len(materials) == 0 {
+		return c.Materials
+	}
+	return materials
+}
+
+// MaterialName returns the catalog spelling of a typed material name, unknown
+// names are kept as typed
+func (c *Catalog) MaterialName(name string) string {
+	for _, m := range c.Materials {
+		if strings.EqualFold(m.Name, name) {
+			return m.Name
+		}
+	}
+	return name
+}
+
+func (c *Catalog) ColorName(name string) string {
+	for _, color := range c.Colors {
+		if strings.EqualFold(color.Name, name) {
+			return color.Name
+		}
+	}
+	return name
+}
+
+type DBTechnology struct {
+	Code   string `db:"code"`
+	Name   string `db:"name"`
+	Unit   Unit   `db:"unit"`
+	Active bool   `db:"active"`
+}
+
+type DBMaterial struct {
+	ID         int     `db:"id"`
+	Technology string  `db:"technology"`
+	Name       string  `db:"name"`
+	Price      float32 `db:"price"`
+	Active     bool    `db:"active"`
+}
+
+type DBColor struct {
+	ID     int    `db:"id"`
+	Name   string `db:"name"`
+	Active bool   `db:"active"`
+}
@@ -0,0 +1,138 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// maxCodeLen keeps technology codes short enough for callback data
+const maxCodeLen = 16
+
+type Service interface {
+	GetCatalog(ctx context.Context, includeInactive bool) (*Catalog, error)
+	AddTechnology(ctx context.Context, code, name string, unit Unit) error
+	AddMaterial(ctx context.Context, technology, name string, price float32) error
+	AddColor(ctx context.Context, name string) error
+	SetTechnologyActive(ctx context.Context, code string, active bool) error
+	SetMaterialActive(ctx context.Context, technology, name string, active bool) error
+	SetColorActive(ctx context.Context, name string, active bool) error
+}
+
+type DefaultService struct {
+	repo Repo
+}
+
+func NewDefaultService(repo Repo) Service {
+	return &DefaultService{
+		repo: repo,
+	}
+}
+
+// GetCatalog returns the active entries only unless includeInactive is set,
+// keyboards offer active entries while admins see everything
+func (d *DefaultService) GetCatalog(ctx context.Context, includeInactive bool) (*Catalog, error) {
+	onlyActive := !includeInactive
+	dbTechnologies, err := d.repo.GetTechnologies(ctx, onlyActive)
+	if err != nil {
+		slog.Error("Error retrieving technologies", "error", err)
+		return nil, err
+	}
+	dbMaterials, err := d.repo.GetMaterials(ctx, onlyActive)
+	if err != nil {
+		slog.Error("Error retrieving materials", "error", err)
+		return nil, err
+	}
+	dbColors, err := d.repo.GetColors(ctx, onlyActive)
+	if err != nil {
+		slog.Error("Error retrieving colors", "error", err)
+		return nil, err
+	}
+
+	catalog := &Catalog{
+		Technologies: make([]Technology, len(dbTechnologies)),
+		Materials:    make([]Material, len(dbMaterials)),
+		Colors:       make([]Color, len(dbColors)),
+	}
+	for i, t := range dbTechnologies {
+		catalog.Technologies[i] = Technology{Code: t.Code, Name: t.Name, Unit: t.Unit, Active: t.Active}
+	}
+	for i, m := range dbMaterials {
+		catalog.Materials[i] = Material{ID: m.ID, Technology: m.Technology, Name: m.Name, Price: m.Price, Active: m.Active}
+	}
+	for i, c := range dbColors {
+		catalog.Colors[i] = Color{ID: c.ID, Name: c.Name, Active: c.Active}
+	}
+	return catalog, nil
+}
+
+// AddTechnology adds the technology or updates the one with the same code,
+// the code doubles as the name when none is given
+func (d *DefaultService) AddTechnology(ctx context.Context, code, name string, unit Unit) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || len(code) > maxCodeLen || strings.ContainsAny(code, " :") || !unit.IsValid() {
+		return ErrInvalidEntry
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = code
+	}
+
+	technology := DBTechnology{Code: code, Name: name, Unit: unit}
+	if err := d.repo.UpsertTechnology(ctx, technology); err != nil {
+		slog.Error("Failed to add technology", "error", err, "code", code)
+		return err
+	}
+	return nil
+}
+
+// AddMaterial adds the material or updates the price of a known one
+func (d *DefaultService) AddMaterial(ctx context.Context, technology, name string, price float32) error {
+	name = strings.TrimSpace(name)
+	if name == "" || price < 0 {
+		return ErrInvalidEntry
+	}
+
+	material := DBMaterial{Technology: strings.ToUpper(technology), Name: name, Price: price}
+	if err := d.repo.UpsertMaterial(ctx, material); err != nil {
+		if !errors.Is(err, ErrEntryNotFound) {
+			slog.Error("Failed to add material", "error", err, "technology", technology, "name", name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *DefaultService) AddColor(ctx context.Context, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrInvalidEntry
+	}
+
+	if err := d.repo.UpsertColor(ctx, DBColor{Name: name}); err != nil {
+		slog.Error("Failed to add color", "error", err, "name", name)
+		return err
+	}
+	return nil
+}
+
+// Disabled entries stay on the orders that use them, they are only no longer offered
+func (d *DefaultService) SetTechnologyActive(ctx context.Context, code string, active bool) error {
+	return logSetActive(d.repo.SetTechnologyActive(ctx, strings.ToUpper(code), active), "technology", code)
+}
+
+func (d *DefaultService) SetMaterialActive(ctx context.Context, technology, name string, active bool) error {
+	return logSetActive(d.repo.SetMaterialActive(ctx, strings.ToUpper(technology), strings.TrimSpace(name), active), "material", name)
+}
+
+func (d *DefaultService) SetColorActive(ctx context.Context, name string, active bool) error {
+	return logSetActive(d.repo.SetColorActive(ctx, strings.TrimSpace(name), active), "color", name)
+}
+
+func logSetActive(err error, kind, key string) error {
+	if err != nil && !errors.Is(err, ErrEntryNotFound) {
+		slog.Error("Failed to change catalog entry", "error", err, "kind", kind, "key", key)
+	}
+	return err
+}
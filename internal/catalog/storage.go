@@ -0,0 +1,283 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"print3d-order-bot/pkg"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const pgForeignKeyViolation = "23503"
+
+type Repo interface {
+	GetTechnologies(ctx context.Context, onlyActive bool) ([]DBTechnology, error)
+	GetMaterials(ctx context.Context, onlyActive bool) ([]DBMaterial, error)
+	GetColors(ctx context.Context, onlyActive bool) ([]DBColor, error)
+	UpsertTechnology(ctx context.Context, technology DBTechnology) error
+	UpsertMaterial(ctx context.Context, material DBMaterial) error
+	UpsertColor(ctx context.Context, color DBColor) error
+	SetTechnologyActive(ctx context.Context, code string, active bool) error
+	SetMaterialActive(ctx context.Context, technology, name string, active bool) error
+	SetColorActive(ctx context.Context, name string, active bool) error
+}
+
+type DefaultRepo struct {
+	pool    *pgxpool.Pool
+	builder squirrel.StatementBuilderType
+}
+
+func NewDefaultRepo(pool *pgxpool.Pool) Repo {
+	return &DefaultRepo{
+		pool:    pool,
+		builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (d *DefaultRepo) GetTechnologies(ctx context.Context, onlyActive bool) ([]DBTechnology, error) {
+	stmt := d.builder.Select("code", "name", "unit", "active").
+		From("technologies").
+		OrderBy("code")
+	if onlyActive {
+		stmt = stmt.Where(squirrel.Eq{"active": true})
+	}
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetTechnologies",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select technologies",
+			Info:  fmt.Sprintf("GetTechnologies; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var technologies []DBTechnology
+	for rows.Next() {
+		var t DBTechnology
+		if err := rows.Scan(&t.Code, &t.Name, &t.Unit, &t.Active); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetTechnologies; query: %s", query),
+				Err:   err,
+			}
+		}
+		technologies = append(technologies, t)
+	}
+	return technologies, nil
+}
+
+func (d *DefaultRepo) GetMaterials(ctx context.Context, onlyActive bool) ([]DBMaterial, error) {
+	stmt := d.builder.Select("id", "technology", "name", "price", "active").
+		From("materials").
+		OrderBy("technology", "id")
+	if onlyActive {
+		stmt = stmt.Where(squirrel.Eq{"active": true})
+	}
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetMaterials",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select materials",
+			Info:  fmt.Sprintf("GetMaterials; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var materials []DBMaterial
+	for rows.Next() {
+		var m DBMaterial
+		if err := rows.Scan(&m.ID, &m.Technology, &m.Name, &m.Price, &m.Active); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetMaterials; query: %s", query),
+				Err:   err,
+			}
+		}
+		materials = append(materials, m)
+	}
+	return materials, nil
+}
+
+func (d *DefaultRepo) GetColors(ctx context.Context, onlyActive bool) ([]DBColor, error) {
+	stmt := d.builder.Select("id", "name", "active").
+		From("colors").
+		OrderBy("id")
+	if onlyActive {
+		stmt = stmt.Where(squirrel.Eq{"active": true})
+	}
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetColors",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select colors",
+			Info:  fmt.Sprintf("GetColors; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var colors []DBColor
+	for rows.Next() {
+		var c DBColor
+		if err := rows.Scan(&c.ID, &c.Name, &c.Active); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetColors; query: %s", query),
+				Err:   err,
+			}
+		}
+		colors = append(colors, c)
+	}
+	return colors, nil
+}
+
+// UpsertTechnology also re-enables a disabled technology
+func (d *DefaultRepo) UpsertTechnology(ctx context.Context, technology DBTechnology) error {
+	query, args, err := d.builder.Insert("technologies").
+		Columns("code", "name", "unit").
+		Values(technology.Code, technology.Name, technology.Unit).
+		Suffix("on conflict (code) do update set name = excluded.name, unit = excluded.unit, active = true").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpsertTechnology",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to upsert technology",
+			Info:  fmt.Sprintf("UpsertTechnology; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+// UpsertMaterial updates the price of a known material, names are matched
+// case-insensitively within the technology
+func (d *DefaultRepo) UpsertMaterial(ctx context.Context, material DBMaterial) error {
+	query, args, err := d.builder.Insert("materials").
+		Columns("technology", "name", "price").
+		Values(material.Technology, material.Name, material.Price).
+		Suffix("on conflict (technology, lower(name)) do update set price = excluded.price, active = true").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpsertMaterial",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return ErrEntryNotFound
+		}
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to upsert material",
+			Info:  fmt.Sprintf("UpsertMaterial; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) UpsertColor(ctx context.Context, color DBColor) error {
+	query, args, err := d.builder.Insert("colors").
+		Columns("name").
+		Values(color.Name).
+		Suffix("on conflict (lower(name)) do update set active = true").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpsertColor",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to upsert color",
+			Info:  fmt.Sprintf("UpsertColor; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) SetTechnologyActive(ctx context.Context, code string, active bool) error {
+	return d.setActive(ctx, "technologies", squirrel.Eq{"code": code}, active, "SetTechnologyActive")
+}
+
+func (d *DefaultRepo) SetMaterialActive(ctx context.Context, technology, name string, active bool) error {
+	where := squirrel.And{
+		squirrel.Eq{"technology": technology},
+		squirrel.Expr("lower(name) = lower(?)", name),
+	}
+	return d.setActive(ctx, "materials", where, active, "SetMaterialActive")
+}
+
+func (d *DefaultRepo) SetColorActive(ctx context.Context, name string, active bool) error {
+	return d.setActive(ctx, "colors", squirrel.Expr("lower(name) = lower(?)", name), active, "SetColorActive")
+}
+
+func (d *DefaultRepo) setActive(ctx context.Context, table string, where squirrel.Sqlizer, active bool, info string) error {
+	query, args, err := d.builder.Update(table).
+		Set("active", active).
+		Where(where).
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  info,
+			Err:   err,
+		}
+	}
+
+	tag, err := d.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to update catalog entry",
+			Info:  fmt.Sprintf("%s; query: %s", info, query),
+			Err:   err,
+		}
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
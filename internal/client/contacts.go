@@ -0,0 +1,51 @@
+package client
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeContact brings phones, emails and Telegram usernames to a single
+// spelling, so the same contact typed differently still matches the client
+func NormalizeContact(contact string) string {
+	contact = strings.TrimSpace(contact)
+	switch {
+	case contact == "":
+		return ""
+	case strings.HasPrefix(contact, "@"), strings.Contains(contact, "@"):
+		return strings.ToLower(contact)
+	}
+
+	var digits strings.Builder
+	for _, r := range contact {
+		if unicode.IsDigit(r) {
+			digits.WriteRune(r)
+		}
+	}
+	phone := digits.String()
+	if phone == "" {
+		return strings.ToLower(contact)
+	}
+	// Russian numbers are written both as 8XXX and +7XXX
+	if len(phone) == 11 && phone[0] == '8' {
+		phone = "7" + phone[1:]
+	}
+	return "+" + phone
+}
+
+func normalizeContacts(contacts []string) []string {
+	seen := make(map[string]struct{}, len(contacts))
+	result := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		normalized := NormalizeContact(contact)
+		if normalized == "" {
+			continue
+		}
+		if _, ok := seen[normalized]; ok {
+			continue
+		}
+		seen[normalized] = struct{}{}
+		result = append(result, normalized)
+	}
+	return result
+}
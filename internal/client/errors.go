@@ -0,0 +1,5 @@
+package client
+
+import "errors"
+
+var ErrClientNotFound = errors.New("client not found")
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"print3d-order-bot/internal/order"
+	"time"
+)
+
+type Client struct {
+	ID        int
+	Name      string
+	Contacts  []string
+	CreatedAt time.Time
+}
+
+type ClientOrder struct {
+	ID        int
+	Status    order.Status
+	Cost      float32
+	CreatedAt time.Time
+}
+
+// Card is the client with every order placed by them, cancelled orders don't
+// count towards the lifetime spend
+type Card struct {
+	Client
+	Orders     []ClientOrder
+	TotalSpent float32
+}
+
+type DBClient struct {
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	Contacts  []string  `db:"contacts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type DBClientOrder struct {
+	ID        int          `db:"id"`
+	Status    order.Status `db:"status"`
+	Cost      float32      `db:"cost"`
+	CreatedAt time.Time    `db:"created_at"`
+}
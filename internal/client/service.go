@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"print3d-order-bot/internal/order"
+	"strings"
+	"unicode"
+)
+
+const searchLimit = 8
+
+type Service interface {
+	GetClient(ctx context.Context, id int) (*Client, error)
+	MatchClient(ctx context.Context, contacts []string) (*Client, error)
+	SearchClients(ctx context.Context, query string) ([]Client, error)
+	NewClient(ctx context.Context, name string, contacts []string) (*Client, error)
+	AddContacts(ctx context.Context, id int, contacts []string) error
+	GetClientCard(ctx context.Context, id int) (*Card, error)
+}
+
+type DefaultService struct {
+	repo Repo
+}
+
+func NewDefaultService(repo Repo) Service {
+	return &DefaultService{
+		repo: repo,
+	}
+}
+
+func (d *DefaultService) GetClient(ctx context.Context, id int) (*Client, error) {
+	dbClient, err := d.repo.GetClient(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrClientNotFound) {
+			slog.Error("Error retrieving client", "error", err, "clientID", id)
+		}
+		return nil, err
+	}
+	return toClient(*dbClient), nil
+}
+
+// MatchClient finds the client owning any of the contacts, ErrClientNotFound
+// means the contacts are new
+func (d *DefaultService) MatchClient(ctx context.Context, contacts []string) (*Client, error) {
+	normalized := normalizeContacts(contacts)
+	if len(normalized) == 0 {
+		return nil, ErrClientNotFound
+	}
+
+	dbClients, err := d.repo.FindClientsByContacts(ctx, normalized)
+	if err != nil {
+		slog.Error("Error matching client", "error", err)
+		return nil, err
+	}
+	if len(dbClients) == 0 {
+		return nil, ErrClientNotFound
+	}
+	return toClient(dbClients[0]), nil
+}
+
+func (d *DefaultService) SearchClients(ctx context.Context, query string) ([]Client, error) {
+	query = strings.TrimSpace(query)
+	// Phones are stored as "+" and digits only, so spaces and brackets typed by the user must go
+	if query != "" && !strings.ContainsFunc(query, unicode.IsLetter) {
+		query = strings.TrimPrefix(NormalizeContact(query), "+")
+	}
+
+	dbClients, err := d.repo.SearchClients(ctx, query, searchLimit)
+	if err != nil {
+		slog.Error("Error searching clients", "error", err, "query", query)
+		return nil, err
+	}
+
+	clients := make([]Client, len(dbClients))
+	for i, dbClient := range dbClients {
+		clients[i] = *toClient(dbClient)
+	}
+	return clients, nil
+}
+
+func (d *DefaultService) NewClient(ctx context.Context, name string, contacts []string) (*Client, error) {
+	dbClient := DBClient{
+		Name:     strings.TrimSpace(name),
+		Contacts: normalizeContacts(contacts),
+	}
+
+	id, err := d.repo.NewClient(ctx, dbClient)
+	if err != nil {
+		slog.Error("Failed to create client", "error", err)
+		return nil, err
+	}
+
+	dbClient.ID = id
+	return toClient(dbClient), nil
+}
+
+func (d *DefaultService) AddContacts(ctx context.Context, id int, contacts []string) error {
+	if err := d.repo.AddContacts(ctx, id, normalizeContacts(contacts)); err != nil {
+		slog.Error("Failed to add client contacts", "error", err, "clientID", id)
+		return err
+	}
+	return nil
+}
+
+func (d *DefaultService) GetClientCard(ctx context.Context, id int) (*Card, error) {
+	client, err := d.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dbOrders, err := d.repo.GetClientOrders(ctx, id)
+	if err != nil {
+		slog.Error("Error retrieving client orders", "error", err, "clientID", id)
+		return nil, err
+	}
+
+	card := &Card{
+		Client: *client,
+		Orders: make([]ClientOrder, len(dbOrders)),
+	}
+	for i, dbOrder := range dbOrders {
+		card.Orders[i] = ClientOrder{
+			ID:        dbOrder.ID,
+			Status:    dbOrder.Status,
+			Cost:      dbOrder.Cost,
+			CreatedAt: dbOrder.CreatedAt,
+		}
+		if dbOrder.Status != order.StatusCancelled {
+			card.TotalSpent += dbOrder.Cost
+		}
+	}
+	return card, nil
+}
+
+func toClient(dbClient DBClient) *Client {
+	return &Client{
+		ID:        dbClient.ID,
+		Name:      dbClient.Name,
+		Contacts:  dbClient.Contacts,
+		CreatedAt: dbClient.CreatedAt,
+	}
+}
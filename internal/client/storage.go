@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"print3d-order-bot/pkg"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repo interface {
+	GetClient(ctx context.Context, id int) (*DBClient, error)
+	FindClientsByContacts(ctx context.Context, contacts []string) ([]DBClient, error)
+	SearchClients(ctx context.Context, query string, limit uint64) ([]DBClient, error)
+	NewClient(ctx context.Context, client DBClient) (int, error)
+	AddContacts(ctx context.Context, id int, contacts []string) error
+	GetClientOrders(ctx context.Context, id int) ([]DBClientOrder, error)
+}
+
+type DefaultRepo struct {
+	pool    *pgxpool.Pool
+	builder squirrel.StatementBuilderType
+}
+
+func NewDefaultRepo(pool *pgxpool.Pool) Repo {
+	return &DefaultRepo{
+		pool:    pool,
+		builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (d *DefaultRepo) GetClient(ctx context.Context, id int) (*DBClient, error) {
+	query, args, err := d.builder.Select("id", "name", "contacts", "created_at").
+		From("clients").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetClient",
+			Err:   err,
+		}
+	}
+
+	var client DBClient
+	if err := d.pool.QueryRow(ctx, query, args...).Scan(&client.ID, &client.Name, &client.Contacts, &client.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrClientNotFound
+		}
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select client",
+			Info:  fmt.Sprintf("GetClient; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	return &client, nil
+}
+
+func (d *DefaultRepo) FindClientsByContacts(ctx context.Context, contacts []string) ([]DBClient, error) {
+	stmt := d.builder.Select("id", "name", "contacts", "created_at").
+		From("clients").
+		Where(squirrel.Expr("contacts && ?", contacts)).
+		OrderBy("created_at")
+	return d.selectClients(ctx, stmt, "FindClientsByContacts")
+}
+
+// SearchClients matches the query against names and contacts, an empty query
+// returns the clients who ordered most recently
+func (d *DefaultRepo) SearchClients(ctx context.Context, query string, limit uint64) ([]DBClient, error) {
+	stmt := d.builder.Select("id", "name", "contacts", "created_at").
+		From("clients").
+		OrderBy("(select max(created_at) from orders where orders.client_id = clients.id) desc nulls last", "created_at desc").
+		Limit(limit)
+	if query != "" {
+		pattern := "%" + query + "%"
+		stmt = stmt.Where(squirrel.Or{
+			squirrel.ILike{"name": pattern},
+			squirrel.Expr("exists (select 1 from unnest(contacts) contact where contact ilike ?)", pattern),
+		})
+	}
+	return d.selectClients(ctx, stmt, "SearchClients")
+}
+
+func (d *DefaultRepo) selectClients(ctx context.Context, stmt squirrel.SelectBuilder, info string) ([]DBClient, error) {
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  info,
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select clients",
+			Info:  fmt.Sprintf("%s; query: %s", info, query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var clients []DBClient
+	for rows.Next() {
+		var client DBClient
+		if err := rows.Scan(&client.ID, &client.Name, &client.Contacts, &client.CreatedAt); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("%s; query: %s", info, query),
+				Err:   err,
+			}
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+func (d *DefaultRepo) NewClient(ctx context.Context, client DBClient) (int, error) {
+	query, args, err := d.builder.Insert("clients").
+		Columns("name", "contacts").
+		Values(client.Name, client.Contacts).
+		Suffix("returning id").
+		ToSql()
+	if err != nil {
+		return 0, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "NewClient",
+			Err:   err,
+		}
+	}
+
+	var id int
+	if err := d.pool.QueryRow(ctx, query, args...).Scan(&id); err != nil {
+		return 0, &pkg.ErrDBProcedure{
+			Cause: "failed to insert client",
+			Info:  fmt.Sprintf("NewClient; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	return id, nil
+}
+
+func (d *DefaultRepo) AddContacts(ctx context.Context, id int, contacts []string) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+	query, args, err := d.builder.Update("clients").
+		Set("contacts", squirrel.Expr("array(select distinct unnest(contacts || ?::text[]))", contacts)).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "AddContacts",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to update client contacts",
+			Info:  fmt.Sprintf("AddContacts; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) GetClientOrders(ctx context.Context, id int) ([]DBClientOrder, error) {
+	query, args, err := d.builder.Select("id", "status", "cost", "created_at").
+		From("orders").
+		Where(squirrel.Eq{"client_id": id}).
+		OrderBy("created_at desc").
+		ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetClientOrders",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select client orders",
+			Info:  fmt.Sprintf("GetClientOrders; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var orders []DBClientOrder
+	for rows.Next() {
+		var order DBClientOrder
+		if err := rows.Scan(&order.ID, &order.Status, &order.Cost, &order.CreatedAt); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetClientOrders; query: %s", query),
+				Err:   err,
+			}
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
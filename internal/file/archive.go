@@ -0,0 +1,173 @@
+package file
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ArchiveDirName = ".archive"
+	ManifestName   = "manifest.json"
+)
+
+// ArchiveFolder packs the folder together with the manifest into a tar.zst
+// bundle in the archive directory and returns the bundle path
+func (d *DefaultService) ArchiveFolder(folderPath string, manifest []byte) (string, error) {
+	ctx := context.Background()
+	archivePath := path.Join(ArchiveDirName, folderPath+".tar.zst")
+
+	if err := d.storage.MkdirAll(ctx, ArchiveDirName); err != nil {
+		return "", &ErrPrepareFilepath{Err: err}
+	}
+	dst, err := d.storage.Create(ctx, archivePath)
+	if errors.Is(err, ErrFileExists) {
+		if err := d.storage.Remove(ctx, archivePath); err != nil {
+			return "", &ErrPrepareFilepath{Err: err}
+		}
+		dst, err = d.storage.Create(ctx, archivePath)
+	}
+	if err != nil {
+		return "", &ErrPrepareFilepath{Err: err}
+	}
+
+	if err := d.writeArchive(ctx, dst, folderPath, manifest); err != nil {
+		dst.Abort()
+		return "", &ErrArchiveFailed{Err: err}
+	}
+	if err := dst.Commit(); err != nil {
+		return "", &ErrArchiveFailed{Err: err}
+	}
+
+	return archivePath, nil
+}
+
+func (d *DefaultService) writeArchive(ctx context.Context, dst io.Writer, folderPath string, manifest []byte) error {
+	zw, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	entries, err := d.storage.List(ctx, folderPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		if err := d.addToArchive(ctx, tw, folderPath, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (d *DefaultService) addToArchive(ctx context.Context, tw *tar.Writer, folderPath string, entry Entry) error {
+	src, err := d.storage.Open(ctx, path.Join(folderPath, entry.Name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Join("files", entry.Name),
+		Mode:    0644,
+		Size:    int64(entry.Size),
+		ModTime: entry.ModTime,
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+// ExtractArchive unpacks the order files from the bundle back into the folder,
+// files that already exist in the folder are left untouched
+func (d *DefaultService) ExtractArchive(archivePath, folderPath string) error {
+	ctx := context.Background()
+	src, err := d.storage.Open(ctx, archivePath)
+	if err != nil {
+		return &ErrOpenFile{Err: err}
+	}
+	defer src.Close()
+
+	zr, err := zstd.NewReader(src)
+	if err != nil {
+		return &ErrArchiveFailed{Err: err}
+	}
+	defer zr.Close()
+
+	if err := d.storage.MkdirAll(ctx, folderPath); err != nil {
+		return &ErrPrepareFilepath{Err: err}
+	}
+
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return &ErrArchiveFailed{Err: err}
+		}
+
+		name, ok := archivedFileName(header)
+		if !ok {
+			continue
+		}
+
+		dst, err := d.storage.Create(ctx, path.Join(folderPath, name))
+		if errors.Is(err, ErrFileExists) {
+			continue
+		}
+		if err != nil {
+			return &ErrPrepareFilepath{Err: err}
+		}
+		if _, err := io.Copy(dst, tr); err != nil {
+			dst.Abort()
+			return &ErrArchiveFailed{Err: err}
+		}
+		if err := dst.Commit(); err != nil {
+			return &ErrArchiveFailed{Err: err}
+		}
+	}
+}
+
+func (d *DefaultService) DeleteArchive(archivePath string) error {
+	return d.storage.Remove(context.Background(), archivePath)
+}
+
+func archivedFileName(header *tar.Header) (string, bool) {
+	if header.Typeflag != tar.TypeReg {
+		return "", false
+	}
+	dir, name := path.Split(path.Clean(header.Name))
+	if dir != "files/" || name == "" {
+		return "", false
+	}
+	return name, true
+}
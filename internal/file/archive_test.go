@@ -0,0 +1,145 @@
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// folderFiles reads every file under dir by its slash separated relative path
+func folderFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	service := NewDefaultService(NewLocalStorage(root, false))
+	const folder = "2024-06-10_12-00-00_Ivanov_bracket"
+	want := map[string]string{"bracket.stl": "solid bracket", "notes.txt": "two copies in black"}
+	if err := os.MkdirAll(filepath.Join(root, folder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		if err := os.WriteFile(filepath.Join(root, folder, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath, err := service.ArchiveFolder(folder, []byte(`{"id": 1}`))
+	if err != nil {
+		t.Fatalf("ArchiveFolder() error = %v", err)
+	}
+	if err := service.DeleteFolder(folder); err != nil {
+		t.Fatalf("DeleteFolder() error = %v", err)
+	}
+
+	if err := service.ExtractArchive(archivePath, folder); err != nil {
+		t.Fatalf("ExtractArchive() error = %v", err)
+	}
+	got := folderFiles(t, filepath.Join(root, folder))
+	if len(got) != len(want) {
+		t.Errorf("extracted files = %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("extracted %s = %q, want %q", name, got[name], content)
+		}
+	}
+
+	// Files already in the folder win over the archived ones
+	if err := os.WriteFile(filepath.Join(root, folder, "notes.txt"), []byte("one copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ExtractArchive(archivePath, folder); err != nil {
+		t.Fatalf("ExtractArchive() into a filled folder error = %v", err)
+	}
+	if got := folderFiles(t, filepath.Join(root, folder)); got["notes.txt"] != "one copy" {
+		t.Errorf("notes.txt = %q after extracting again, want it untouched", got["notes.txt"])
+	}
+}
+
+func TestExtractArchiveSkipsForeignEntries(t *testing.T) {
+	root := t.TempDir()
+	service := NewDefaultService(NewLocalStorage(root, false))
+
+	if err := os.MkdirAll(filepath.Join(root, ArchiveDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(root, ArchiveDirName, "crafted.tar.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, header := range []tar.Header{
+		{Name: ManifestName, Typeflag: tar.TypeReg},
+		{Name: "files/part.stl", Typeflag: tar.TypeReg},
+		{Name: "../escaped.stl", Typeflag: tar.TypeReg},
+		{Name: "files/../../escaped.stl", Typeflag: tar.TypeReg},
+		{Name: "/files/absolute.stl", Typeflag: tar.TypeReg},
+		{Name: "files/nested/part.stl", Typeflag: tar.TypeReg},
+		{Name: "files/link.stl", Typeflag: tar.TypeSymlink, Linkname: "../../escaped.stl"},
+	} {
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = []byte(header.Name)
+		}
+		header.Mode, header.Size = 0644, int64(len(content))
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.ExtractArchive(ArchiveDirName+"/crafted.tar.zst", "orders/crafted"); err != nil {
+		t.Fatalf("ExtractArchive() error = %v", err)
+	}
+
+	var got []string
+	for name := range folderFiles(t, root) {
+		got = append(got, name)
+	}
+	slices.Sort(got)
+	want := []string{ArchiveDirName + "/crafted.tar.zst", "orders/crafted/part.stl"}
+	if !slices.Equal(got, want) {
+		t.Errorf("files after ExtractArchive() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const (
+	blobsDirName = ".blobs"
+	blobsTmpDir  = "tmp"
+
+	// blobMode makes blobs and so every link to them read-only. A file edited
+	// in place would change in all the orders sharing it, tools saving through
+	// a new file replace the link of one folder and leave the blob alone
+	blobMode fs.FileMode = 0444
+)
+
+func (l *LocalStorage) blobsDir() string {
+	return filepath.Join(l.root, blobsDirName)
+}
+
+func (l *LocalStorage) blobPath(digest string) string {
+	return filepath.Join(l.blobsDir(), digest[:2], digest)
+}
+
+func (l *LocalStorage) createDeduplicated(path string) (FileWriter, error) {
+	tmpDir := filepath.Join(l.blobsDir(), blobsTmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "download-*")
+	if err != nil {
+		return nil, err
+	}
+
+	digester := sha256.New()
+	return &dedupWriter{
+		storage:  l,
+		tmp:      tmp,
+		digester: digester,
+		w:        io.MultiWriter(tmp, digester),
+		path:     path,
+	}, nil
+}
+
+// dedupWriter buffers the content in a temporary file and on commit hardlinks
+// the read-only blob with the same content into the order folder
+type dedupWriter struct {
+	storage  *LocalStorage
+	tmp      *os.File
+	digester hash.Hash
+	w        io.Writer
+	path     string
+}
+
+func (w *dedupWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *dedupWriter) Commit() error {
+	defer os.Remove(w.tmp.Name())
+	if err := w.tmp.Close(); err != nil {
+		return err
+	}
+
+	w.storage.blobMu.Lock()
+	defer w.storage.blobMu.Unlock()
+
+	blob := w.storage.blobPath(hex.EncodeToString(w.digester.Sum(nil)))
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(w.tmp.Name(), blob); err != nil {
+			return err
+		}
+	}
+	// Blobs stored before they were made read-only are fixed up on reuse
+	if err := os.Chmod(blob, blobMode); err != nil {
+		return err
+	}
+
+	return os.Link(blob, w.path)
+}
+
+func (w *dedupWriter) Abort() error {
+	w.tmp.Close()
+	return os.Remove(w.tmp.Name())
+}
+
+// collectBlobs frees blobs that are no longer linked into any order folder and
+// makes the remaining ones read-only
+func (l *LocalStorage) collectBlobs() error {
+	l.blobMu.Lock()
+	defer l.blobMu.Unlock()
+
+	return l.walkBlobs(func(path string, info fs.FileInfo, links uint64) error {
+		if links > 1 {
+			if info.Mode().Perm() != blobMode {
+				return os.Chmod(path, blobMode)
+			}
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		slog.Info("Removed unreferenced blob", "path", path, "size", info.Size())
+		return nil
+	})
+}
+
+func (l *LocalStorage) DedupReport() (*DedupReport, error) {
+	if !l.dedup {
+		return nil, ErrDedupDisabled
+	}
+
+	report := &DedupReport{}
+	err := l.walkBlobs(func(path string, info fs.FileInfo, links uint64) error {
+		refs := links - 1
+		report.Blobs++
+		report.References += int(refs)
+		report.StoredBytes += uint64(info.Size())
+		if refs > 1 {
+			report.SavedBytes += uint64(info.Size()) * (refs - 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (l *LocalStorage) walkBlobs(fn func(path string, info fs.FileInfo, links uint64) error) error {
+	tmpDir := filepath.Join(l.blobsDir(), blobsTmpDir)
+	err := filepath.WalkDir(l.blobsDir(), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == tmpDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		links, ok := linkCount(info)
+		if !ok {
+			return ErrLinkCountUnset
+		}
+		return fn(path, info, links)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+//go:build unix
+
+package file
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+func writeFile(t *testing.T, storage Storage, path, content string) {
+	t.Helper()
+	w, err := storage.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create(%q) error = %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write(%q) error = %v", path, err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit(%q) error = %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, storage Storage, path string) string {
+	t.Helper()
+	r, err := storage.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open(%q) error = %v", path, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read(%q) error = %v", path, err)
+	}
+	return string(data)
+}
+
+func TestDedupSharedBlob(t *testing.T) {
+	ctx := context.Background()
+	storage := NewLocalStorage(t.TempDir(), true)
+	const content = "solid cube\nendsolid cube\n"
+
+	writeFile(t, storage, "first/cube.stl", content)
+	writeFile(t, storage, "second/cube.stl", content)
+	writeFile(t, storage, "second/notes.txt", "fragile")
+
+	report, err := storage.DedupReport()
+	if err != nil {
+		t.Fatalf("DedupReport() error = %v", err)
+	}
+	want := DedupReport{Blobs: 2, References: 3, StoredBytes: uint64(len(content) + len("fragile")), SavedBytes: uint64(len(content))}
+	if *report != want {
+		t.Errorf("DedupReport() = %+v, want %+v", *report, want)
+	}
+
+	info, err := os.Stat(storage.path("first/cube.stl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != blobMode {
+		t.Errorf("linked file mode = %v, want %v", info.Mode().Perm(), blobMode)
+	}
+
+	if err := storage.RemoveAll(ctx, "first"); err != nil {
+		t.Fatalf("RemoveAll(first) error = %v", err)
+	}
+	if got := readFile(t, storage, "second/cube.stl"); got != content {
+		t.Errorf("second/cube.stl = %q after the first folder is gone, want %q", got, content)
+	}
+	if report, err = storage.DedupReport(); err != nil {
+		t.Fatalf("DedupReport() error = %v", err)
+	}
+	if report.Blobs != 2 || report.References != 2 {
+		t.Errorf("DedupReport() = %+v, want both blobs referenced once", *report)
+	}
+
+	if err := storage.RemoveAll(ctx, "second"); err != nil {
+		t.Fatalf("RemoveAll(second) error = %v", err)
+	}
+	if report, err = storage.DedupReport(); err != nil {
+		t.Fatalf("DedupReport() error = %v", err)
+	}
+	if report.Blobs != 0 {
+		t.Errorf("DedupReport() = %+v, want every blob freed", *report)
+	}
+}
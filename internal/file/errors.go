@@ -6,7 +6,10 @@ import (
 )
 
 var (
-	ErrFileExists = errors.New("file already exists")
+	ErrFileExists        = errors.New("file already exists")
+	ErrDedupDisabled     = errors.New("deduplication is disabled")
+	ErrLinkCountUnset    = errors.New("file system does not report link counts")
+	ErrInvalidTrashEntry = errors.New("invalid trash entry")
 )
 
 type ErrDownloadFailed struct {
@@ -40,3 +43,11 @@ type ErrOpenFile struct {
 func (e *ErrOpenFile) Error() string {
 	return fmt.Errorf("failed to open file: %w", e.Err).Error()
 }
+
+type ErrArchiveFailed struct {
+	Err error
+}
+
+func (e *ErrArchiveFailed) Error() string {
+	return fmt.Errorf("failed to process archive: %w", e.Err).Error()
+}
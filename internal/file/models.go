@@ -2,6 +2,9 @@ package file
 
 import (
 	"io"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
+	"time"
 )
 
 type RequestFile struct {
@@ -13,6 +16,11 @@ type RequestFile struct {
 type ResponseFile struct {
 	Name     string
 	TGFileID string
+	Checksum uint64
+	// Analysis is nil for files that aren't models or couldn't be parsed
+	Analysis *model.Analysis
+	// GCode is nil for files that aren't sliced or couldn't be parsed
+	GCode *gcode.Estimate
 }
 
 type DownloadResult struct {
@@ -28,3 +36,16 @@ type ReadResult struct {
 	Size uint64
 	Err  error
 }
+
+type DedupReport struct {
+	Blobs       int
+	References  int
+	StoredBytes uint64
+	SavedBytes  uint64
+}
+
+type TrashEntry struct {
+	Name      string
+	Folder    string
+	TrashedAt time.Time
+}
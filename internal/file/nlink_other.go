@@ -0,0 +1,9 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
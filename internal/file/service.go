@@ -1,34 +1,83 @@
 package file
 
 import (
+	"bytes"
 	"context"
-	"os"
-	"path/filepath"
-	"print3d-order-bot/pkg/config"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
 	"sync"
+	"time"
 
+	"github.com/cespare/xxhash"
 	"go.uber.org/atomic"
 )
 
 type Service interface {
 	SetDownloaders(botApiDownloader, mtprotoDownloader Downloader)
-	CreateFolder(folderPath string) error
+	ReserveFolder(folderPath string) (string, error)
+	IsReserved(folderPath string) bool
 	DownloadAndSave(ctx context.Context, folderPath string, files []RequestFile) chan DownloadResult
+	AnalyzeFiles(ctx context.Context, files []RequestFile) map[string]*model.Analysis
+	EstimateFiles(ctx context.Context, files []RequestFile) map[string]*gcode.Estimate
+	InspectFile(folderPath, name string) (*model.Analysis, *gcode.Estimate)
 	ReadFiles(folderPath string) (chan ReadResult, error)
+	GetChecksums(folderPath string) (map[string]uint64, error)
+	ListFolders() ([]string, error)
 	DeleteFolder(folderPath string) error
+	CopyFolder(srcPath, dstPath string) (map[string]uint64, error)
+	MoveToTrash(folderPath string) (string, error)
+	ListTrash() ([]TrashEntry, error)
+	RestoreFromTrash(name string) (string, error)
+	DeleteFromTrash(name string) error
+	ArchiveFolder(folderPath string, manifest []byte) (string, error)
+	ExtractArchive(archivePath, folderPath string) error
+	DeleteArchive(archivePath string) error
+	GetDedupReport() (*DedupReport, error)
 }
 
 type DefaultService struct {
 	botApiDownloader  Downloader
 	mtprotoDownloader Downloader
-	cfg               *config.FileServiceCfg
+	storage           Storage
 	wg                sync.WaitGroup
+	// checksums holds a map[string]cachedChecksum by file name per folder, the
+	// map of a folder is replaced as a whole so files gone from it are dropped
+	checksums sync.Map
+
+	reserveMu sync.Mutex
+	// reserved holds the folders handed out by ReserveFolder lately by the time
+	// they were handed out, empty folders aren't visible in every storage
+	reserved map[string]time.Time
+
+	keptMu sync.Mutex
+	// kept holds files downloaded for analysis by Telegram file ID, so saving
+	// them once the order is created doesn't download them again
+	kept     map[string]keptFile
+	keptSize int64
+}
+
+type cachedChecksum struct {
+	entry    Entry
+	checksum uint64
+}
+
+type keptFile struct {
+	data   []byte
+	keptAt time.Time
 }
 
-func NewDefaultService(cfg *config.FileServiceCfg) Service {
+func NewDefaultService(storage Storage) Service {
 	return &DefaultService{
-		cfg: cfg,
-		wg:  sync.WaitGroup{},
+		storage:  storage,
+		wg:       sync.WaitGroup{},
+		reserved: make(map[string]time.Time),
+		kept:     make(map[string]keptFile),
 	}
 }
 
@@ -37,9 +86,52 @@ func (d *DefaultService) SetDownloaders(botApiDownloader, mtprotoDownloader Down
 	d.mtprotoDownloader = mtprotoDownloader
 }
 
-func (d *DefaultService) CreateFolder(folderPath string) error {
-	path := filepath.Join(d.cfg.DirPath, folderPath)
-	return os.MkdirAll(path, os.ModePerm)
+// reservationTTL is how long a reserved folder is remembered, by then it either
+// holds files or was given up
+const reservationTTL = time.Hour
+
+// ReserveFolder creates a folder no one else uses, numbering the path when it
+// is taken. Folder paths are only unique to the second, two orders created at
+// once by the same client would otherwise share one
+func (d *DefaultService) ReserveFolder(folderPath string) (string, error) {
+	d.reserveMu.Lock()
+	defer d.reserveMu.Unlock()
+
+	ctx := context.Background()
+	for name, reservedAt := range d.reserved {
+		if time.Since(reservedAt) > reservationTTL {
+			delete(d.reserved, name)
+		}
+	}
+
+	candidate := folderPath
+	for n := 2; ; n++ {
+		if _, ok := d.reserved[candidate]; !ok {
+			entries, err := d.storage.List(ctx, candidate)
+			if errors.Is(err, fs.ErrNotExist) || (err == nil && len(entries) == 0) {
+				break
+			}
+			if err != nil {
+				return "", &ErrReadDir{Err: err}
+			}
+		}
+		candidate = fmt.Sprintf("%s (%d)", folderPath, n)
+	}
+
+	if err := d.storage.MkdirAll(ctx, candidate); err != nil {
+		return "", &ErrPrepareFilepath{Err: err}
+	}
+	d.reserved[candidate] = time.Now()
+	return candidate, nil
+}
+
+// IsReserved tells whether the folder was handed out by ReserveFolder lately,
+// its order may not be saved yet
+func (d *DefaultService) IsReserved(folderPath string) bool {
+	d.reserveMu.Lock()
+	defer d.reserveMu.Unlock()
+	reservedAt, ok := d.reserved[folderPath]
+	return ok && time.Since(reservedAt) <= reservationTTL
 }
 
 func (d *DefaultService) DownloadAndSave(ctx context.Context, folderPath string, files []RequestFile) chan DownloadResult {
@@ -70,10 +162,10 @@ func (d *DefaultService) DownloadAndSave(ctx context.Context, folderPath string,
 }
 
 func (d *DefaultService) processFile(ctx context.Context, folderPath string, file RequestFile, total int, counter *atomic.Int32, result chan DownloadResult) {
-	filePath := filepath.Join(d.cfg.DirPath, folderPath, file.Name)
 	currentIndex := int(counter.Inc())
 
-	dst, err := prepareFilepath(filePath)
+	filePath := path.Join(folderPath, file.Name)
+	checksum, size, err := d.saveFile(ctx, filePath, file)
 	if err != nil {
 		result <- DownloadResult{
 			Result: &ResponseFile{
@@ -81,43 +173,18 @@ func (d *DefaultService) processFile(ctx context.Context, folderPath string, fil
 			},
 			Index: currentIndex,
 			Total: total,
-			Err:   &ErrPrepareFilepath{Err: err},
+			Err:   err,
 		}
 		return
 	}
 
-	var downloadErr error
-	if file.Size <= 19*1024*1024 {
-		downloadErr = d.botApiDownloader.DownloadFile(ctx, file.TGFileID, dst)
-	} else {
-		downloadErr = d.mtprotoDownloader.DownloadFile(ctx, file.TGFileID, dst)
-	}
-
-	if downloadErr != nil {
-		if err := os.Remove(filePath); err != nil {
-			result <- DownloadResult{
-				Result: &ResponseFile{
-					Name: file.Name,
-				},
-				Index: currentIndex,
-				Total: total,
-				Err:   &ErrDownloadFailed{Err: err},
-			}
-		}
-		result <- DownloadResult{
-			Result: &ResponseFile{
-				Name: file.Name,
-			},
-			Index: currentIndex,
-			Total: total,
-			Err:   &ErrDownloadFailed{Err: err},
-		}
-	}
-
 	result <- DownloadResult{
 		Result: &ResponseFile{
 			Name:     file.Name,
 			TGFileID: file.TGFileID,
+			Checksum: checksum,
+			Analysis: d.analyzeFile(ctx, filePath, size),
+			GCode:    d.estimateFile(ctx, filePath),
 		},
 		Index: currentIndex,
 		Total: total,
@@ -125,9 +192,226 @@ func (d *DefaultService) processFile(ctx context.Context, folderPath string, fil
 	}
 }
 
+// saveFile returns the checksum and the size of the saved file
+func (d *DefaultService) saveFile(ctx context.Context, filePath string, file RequestFile) (uint64, int64, error) {
+	if err := d.storage.MkdirAll(ctx, path.Dir(filePath)); err != nil {
+		return 0, 0, &ErrPrepareFilepath{Err: err}
+	}
+
+	dst, err := d.storage.Create(ctx, filePath)
+	if err == ErrFileExists {
+		return 0, 0, err
+	}
+	if err != nil {
+		return 0, 0, &ErrPrepareFilepath{Err: err}
+	}
+
+	hasher := xxhash.New()
+	var size byteCounter
+	w := io.MultiWriter(dst, hasher, &size)
+	if data, ok := d.takeKept(file.TGFileID); ok {
+		_, err = w.Write(data)
+	} else {
+		err = d.download(ctx, file, w)
+	}
+	if err != nil {
+		if err := dst.Abort(); err != nil {
+			slog.Error("Failed to remove partially downloaded file", "error", err, "path", filePath)
+		}
+		return 0, 0, &ErrDownloadFailed{Err: err}
+	}
+
+	if err := dst.Commit(); err != nil {
+		return 0, 0, &ErrDownloadFailed{Err: err}
+	}
+
+	return hasher.Sum64(), int64(size), nil
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+const (
+	// maxAnalysisSize caps files downloaded into memory by AnalyzeFiles and
+	// EstimateFiles
+	maxAnalysisSize = 64 << 20
+	// maxKeptSize caps the downloads kept for saving, keptTTL outlasts the
+	// order draft they were analyzed for
+	maxKeptSize = 256 << 20
+	keptTTL     = time.Hour
+)
+
+// AnalyzeFiles downloads the models among the files without saving them, so
+// they can be quoted before the order exists. The downloads are kept for
+// DownloadAndSave. Files that aren't models, are too large or can't be parsed
+// are left out of the result
+func (d *DefaultService) AnalyzeFiles(ctx context.Context, files []RequestFile) map[string]*model.Analysis {
+	result := make(map[string]*model.Analysis)
+	for _, file := range files {
+		if !model.Supported(file.Name) {
+			continue
+		}
+		data, ok := d.downloadForAnalysis(ctx, file)
+		if !ok {
+			continue
+		}
+		analysis, err := model.Analyze(file.Name, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			slog.Warn("Failed to analyze model", "error", err, "name", file.Name)
+			continue
+		}
+		result[file.Name] = analysis
+	}
+	return result
+}
+
+// EstimateFiles is AnalyzeFiles for sliced files, it reads their print time
+// and filament usage
+func (d *DefaultService) EstimateFiles(ctx context.Context, files []RequestFile) map[string]*gcode.Estimate {
+	result := make(map[string]*gcode.Estimate)
+	for _, file := range files {
+		if !gcode.Supported(file.Name) {
+			continue
+		}
+		data, ok := d.downloadForAnalysis(ctx, file)
+		if !ok {
+			continue
+		}
+		estimate, err := gcode.Parse(file.Name, bytes.NewReader(data))
+		if err != nil {
+			slog.Warn("Failed to parse g-code", "error", err, "name", file.Name)
+			continue
+		}
+		result[file.Name] = estimate
+	}
+	return result
+}
+
+func (d *DefaultService) downloadForAnalysis(ctx context.Context, file RequestFile) ([]byte, bool) {
+	if file.Size > maxAnalysisSize {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := d.download(ctx, file, &buf); err != nil {
+		slog.Warn("Failed to download file for analysis", "error", err, "name", file.Name)
+		return nil, false
+	}
+	d.keep(file.TGFileID, buf.Bytes())
+	return buf.Bytes(), true
+}
+
+// keep holds on to a downloaded file until it is saved, files that don't fit
+// are downloaded again when saved
+func (d *DefaultService) keep(tgFileID string, data []byte) {
+	d.keptMu.Lock()
+	defer d.keptMu.Unlock()
+
+	for id, kept := range d.kept {
+		if time.Since(kept.keptAt) > keptTTL {
+			d.keptSize -= int64(len(kept.data))
+			delete(d.kept, id)
+		}
+	}
+	if old, ok := d.kept[tgFileID]; ok {
+		d.keptSize -= int64(len(old.data))
+		delete(d.kept, tgFileID)
+	}
+	if d.keptSize+int64(len(data)) > maxKeptSize {
+		return
+	}
+	d.kept[tgFileID] = keptFile{data: data, keptAt: time.Now()}
+	d.keptSize += int64(len(data))
+}
+
+func (d *DefaultService) takeKept(tgFileID string) ([]byte, bool) {
+	d.keptMu.Lock()
+	defer d.keptMu.Unlock()
+
+	kept, ok := d.kept[tgFileID]
+	if !ok {
+		return nil, false
+	}
+	delete(d.kept, tgFileID)
+	d.keptSize -= int64(len(kept.data))
+	return kept.data, true
+}
+
+// analyzeFile reads a saved model back for its geometry. It is best effort, a
+// model that can't be parsed is still a perfectly good order file
+func (d *DefaultService) analyzeFile(ctx context.Context, filePath string, size int64) *model.Analysis {
+	if !model.Supported(filePath) {
+		return nil
+	}
+
+	f, err := d.storage.Open(ctx, filePath)
+	if err != nil {
+		slog.Warn("Failed to open model for analysis", "error", err, "path", filePath)
+		return nil
+	}
+	defer f.Close()
+
+	analysis, err := model.Analyze(filePath, f, size)
+	if err != nil {
+		slog.Warn("Failed to analyze model", "error", err, "path", filePath)
+		return nil
+	}
+	return analysis
+}
+
+// estimateFile reads a saved sliced file back for the slicer estimates, as
+// best effort as analyzeFile
+func (d *DefaultService) estimateFile(ctx context.Context, filePath string) *gcode.Estimate {
+	if !gcode.Supported(filePath) {
+		return nil
+	}
+
+	f, err := d.storage.Open(ctx, filePath)
+	if err != nil {
+		slog.Warn("Failed to open g-code for estimation", "error", err, "path", filePath)
+		return nil
+	}
+	defer f.Close()
+
+	estimate, err := gcode.Parse(filePath, f)
+	if err != nil {
+		slog.Warn("Failed to parse g-code", "error", err, "path", filePath)
+		return nil
+	}
+	return estimate
+}
+
+// InspectFile analyzes or estimates a file that turned up in the folder
+// without going through DownloadAndSave, both are nil for other files
+func (d *DefaultService) InspectFile(folderPath, name string) (*model.Analysis, *gcode.Estimate) {
+	ctx := context.Background()
+	filePath := path.Join(folderPath, name)
+	return d.analyzeFile(ctx, filePath, d.cachedSize(folderPath, name)), d.estimateFile(ctx, filePath)
+}
+
+// cachedSize is the size GetChecksums last saw the file with, zero when it
+// hasn't seen the file
+func (d *DefaultService) cachedSize(folderPath, name string) int64 {
+	cached, ok := d.checksums.Load(folderPath)
+	if !ok {
+		return 0
+	}
+	return int64(cached.(map[string]cachedChecksum)[name].entry.Size)
+}
+
+func (d *DefaultService) download(ctx context.Context, file RequestFile, dst io.Writer) error {
+	if file.Size <= 19*1024*1024 {
+		return d.botApiDownloader.DownloadFile(ctx, file.TGFileID, dst)
+	}
+	return d.mtprotoDownloader.DownloadFile(ctx, file.TGFileID, dst)
+}
+
 func (d *DefaultService) ReadFiles(folderPath string) (chan ReadResult, error) {
-	dst := filepath.Join(d.cfg.DirPath, folderPath)
-	entries, err := os.ReadDir(dst)
+	ctx := context.Background()
+	entries, err := d.storage.List(ctx, folderPath)
 	if err != nil {
 		return nil, &ErrReadDir{Err: err}
 	}
@@ -142,38 +426,29 @@ func (d *DefaultService) ReadFiles(folderPath string) (chan ReadResult, error) {
 		for _, entry := range entries {
 			wg.Add(1)
 			sem <- struct{}{}
-			go func(entry os.DirEntry) {
+			go func(entry Entry) {
 				defer func() {
 					<-sem
 					wg.Done()
 				}()
 
-				if entry.IsDir() {
+				if entry.IsDir {
 					return
 				}
 
-				path := filepath.Join(dst, entry.Name())
-
-				fileInfo, err := entry.Info()
+				file, err := d.storage.Open(ctx, path.Join(folderPath, entry.Name))
 				if err != nil {
 					result <- ReadResult{
-						Name: entry.Name(),
-						Err:  &ErrOpenFile{Err: err},
-					}
-				}
-
-				file, err := os.Open(path)
-				if err != nil {
-					result <- ReadResult{
-						Name: entry.Name(),
+						Name: entry.Name,
 						Err:  &ErrOpenFile{Err: err},
 					}
+					return
 				}
 
 				result <- ReadResult{
-					Name: entry.Name(),
+					Name: entry.Name,
 					Body: file,
-					Size: uint64(fileInfo.Size()),
+					Size: entry.Size,
 				}
 			}(entry)
 		}
@@ -184,7 +459,131 @@ func (d *DefaultService) ReadFiles(folderPath string) (chan ReadResult, error) {
 	return result, nil
 }
 
+func (d *DefaultService) GetChecksums(folderPath string) (map[string]uint64, error) {
+	ctx := context.Background()
+	entries, err := d.storage.List(ctx, folderPath)
+	if err != nil {
+		return nil, &ErrReadDir{Err: err}
+	}
+
+	var cache map[string]cachedChecksum
+	if cached, ok := d.checksums.Load(folderPath); ok {
+		cache = cached.(map[string]cachedChecksum)
+	}
+
+	checksums := make(map[string]uint64, len(entries))
+	fresh := make(map[string]cachedChecksum, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+
+		if cached, ok := cache[entry.Name]; ok && cached.entry == entry {
+			checksums[entry.Name] = cached.checksum
+			fresh[entry.Name] = cached
+			continue
+		}
+
+		checksum, err := d.hashFile(ctx, path.Join(folderPath, entry.Name))
+		if err != nil {
+			return nil, &ErrOpenFile{Err: err}
+		}
+		checksums[entry.Name] = checksum
+		fresh[entry.Name] = cachedChecksum{entry: entry, checksum: checksum}
+	}
+	d.checksums.Store(folderPath, fresh)
+
+	return checksums, nil
+}
+
+func (d *DefaultService) hashFile(ctx context.Context, filePath string) (uint64, error) {
+	f, err := d.storage.Open(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	hasher := xxhash.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return 0, err
+	}
+	return hasher.Sum64(), nil
+}
+
+func (d *DefaultService) ListFolders() ([]string, error) {
+	entries, err := d.storage.List(context.Background(), "")
+	if err != nil {
+		return nil, &ErrReadDir{Err: err}
+	}
+
+	var folders []string
+	for _, entry := range entries {
+		if entry.IsDir {
+			folders = append(folders, entry.Name)
+		}
+	}
+	return folders, nil
+}
+
 func (d *DefaultService) DeleteFolder(folderPath string) error {
-	folderPath = filepath.Join(d.cfg.DirPath, folderPath)
-	return os.RemoveAll(folderPath)
+	d.checksums.Delete(folderPath)
+	return d.storage.RemoveAll(context.Background(), folderPath)
+}
+
+// CopyFolder copies the files of the folder and returns their checksums by name
+func (d *DefaultService) CopyFolder(srcPath, dstPath string) (map[string]uint64, error) {
+	ctx := context.Background()
+	entries, err := d.storage.List(ctx, srcPath)
+	if err != nil {
+		return nil, &ErrReadDir{Err: err}
+	}
+	if err := d.storage.MkdirAll(ctx, dstPath); err != nil {
+		return nil, &ErrPrepareFilepath{Err: err}
+	}
+
+	checksums := make(map[string]uint64, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		checksum, err := d.copyFile(ctx, path.Join(srcPath, entry.Name), path.Join(dstPath, entry.Name))
+		if err != nil {
+			return nil, err
+		}
+		checksums[entry.Name] = checksum
+	}
+	return checksums, nil
+}
+
+func (d *DefaultService) copyFile(ctx context.Context, srcPath, dstPath string) (uint64, error) {
+	src, err := d.storage.Open(ctx, srcPath)
+	if err != nil {
+		return 0, &ErrOpenFile{Err: err}
+	}
+	defer src.Close()
+
+	dst, err := d.storage.Create(ctx, dstPath)
+	if err != nil {
+		return 0, &ErrPrepareFilepath{Err: err}
+	}
+
+	hasher := xxhash.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hasher), src); err != nil {
+		if err := dst.Abort(); err != nil {
+			slog.Error("Failed to remove partially copied file", "error", err, "path", dstPath)
+		}
+		return 0, &ErrOpenFile{Err: err}
+	}
+	if err := dst.Commit(); err != nil {
+		return 0, &ErrPrepareFilepath{Err: err}
+	}
+	return hasher.Sum64(), nil
+}
+
+func (d *DefaultService) GetDedupReport() (*DedupReport, error) {
+	deduplicator, ok := d.storage.(Deduplicator)
+	if !ok {
+		return nil, ErrDedupDisabled
+	}
+	return deduplicator.DedupReport()
 }
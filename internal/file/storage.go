@@ -0,0 +1,58 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"print3d-order-bot/pkg/config"
+	"time"
+)
+
+type Storage interface {
+	MkdirAll(ctx context.Context, folderPath string) error
+	Create(ctx context.Context, filePath string) (FileWriter, error)
+	Open(ctx context.Context, filePath string) (io.ReadCloser, error)
+	List(ctx context.Context, folderPath string) ([]Entry, error)
+	Remove(ctx context.Context, filePath string) error
+	RemoveAll(ctx context.Context, folderPath string) error
+	MoveFolder(ctx context.Context, srcPath, dstPath string) error
+}
+
+// FileWriter is returned by Storage.Create. The file becomes visible only after
+// Commit, Abort discards everything written so far
+type FileWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// Deduplicator is implemented by storages that keep a single copy of identical files
+type Deduplicator interface {
+	DedupReport() (*DedupReport, error)
+}
+
+type Entry struct {
+	Name    string
+	Size    uint64
+	ModTime time.Time
+	IsDir   bool
+}
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+func NewStorage(cfg *config.FileServiceCfg) (Storage, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocalStorage(cfg.DirPath, cfg.Dedup), nil
+	case BackendS3:
+		if cfg.Dedup {
+			return nil, fmt.Errorf("deduplication is only supported by the %s backend", BackendLocal)
+		}
+		return NewS3Storage(cfg.DirPath, &cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
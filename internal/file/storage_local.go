@@ -0,0 +1,140 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type LocalStorage struct {
+	root   string
+	dedup  bool
+	blobMu sync.Mutex
+}
+
+func NewLocalStorage(root string, dedup bool) *LocalStorage {
+	return &LocalStorage{
+		root:  root,
+		dedup: dedup,
+	}
+}
+
+func (l *LocalStorage) path(name string) string {
+	return filepath.Join(l.root, name)
+}
+
+func (l *LocalStorage) MkdirAll(ctx context.Context, folderPath string) error {
+	return os.MkdirAll(l.path(folderPath), os.ModePerm)
+}
+
+func (l *LocalStorage) Create(ctx context.Context, filePath string) (FileWriter, error) {
+	path := l.path(filePath)
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrFileExists
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if l.dedup {
+		return l.createDeduplicated(path)
+	}
+
+	f, err := os.Create(partialPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: f, path: path}, nil
+}
+
+func (l *LocalStorage) Open(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return os.Open(l.path(filePath))
+}
+
+func (l *LocalStorage) List(ctx context.Context, folderPath string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(l.path(folderPath))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if isPartial(dirEntry.Name()) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name:    dirEntry.Name(),
+			Size:    uint64(info.Size()),
+			ModTime: info.ModTime(),
+			IsDir:   dirEntry.IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+func (l *LocalStorage) Remove(ctx context.Context, filePath string) error {
+	return os.Remove(l.path(filePath))
+}
+
+func (l *LocalStorage) RemoveAll(ctx context.Context, folderPath string) error {
+	if err := os.RemoveAll(l.path(folderPath)); err != nil {
+		return err
+	}
+	if l.dedup {
+		return l.collectBlobs()
+	}
+	return nil
+}
+
+func (l *LocalStorage) MoveFolder(ctx context.Context, srcPath, dstPath string) error {
+	dst := l.path(dstPath)
+	if _, err := os.Stat(dst); err == nil {
+		return ErrFileExists
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(l.path(srcPath), dst)
+}
+
+// localWriter writes into a hidden partial file next to the target, so that
+// nobody watching the folder sees a half-downloaded file
+type localWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localWriter) Commit() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.Name(), w.path)
+}
+
+func (w *localWriter) Abort() error {
+	w.Close()
+	return os.Remove(w.Name())
+}
+
+const partialSuffix = ".part"
+
+func partialPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+partialSuffix)
+}
+
+func isPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, partialSuffix)
+}
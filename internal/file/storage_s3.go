@@ -0,0 +1,180 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"print3d-order-bot/pkg/config"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var errUploadAborted = errors.New("upload aborted")
+
+// S3Storage keeps order folders as key prefixes in an S3-compatible bucket
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Storage(prefix string, cfg *config.S3Cfg) (*S3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Storage{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *S3Storage) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, name), "/")
+}
+
+func (s *S3Storage) folderKey(name string) string {
+	key := s.key(name)
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s *S3Storage) MkdirAll(ctx context.Context, folderPath string) error {
+	return nil
+}
+
+func (s *S3Storage) Create(ctx context.Context, filePath string) (FileWriter, error) {
+	key := s.key(filePath)
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return nil, ErrFileExists
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.client.PutObject(ctx, s.bucket, key, pr, -1, minio.PutObjectOptions{})
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &s3Writer{pw: pw, done: done}, nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.key(filePath), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *S3Storage) List(ctx context.Context, folderPath string) ([]Entry, error) {
+	prefix := s.folderKey(folderPath)
+
+	var entries []Entry
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		if dirName, ok := strings.CutSuffix(name, "/"); ok {
+			entries = append(entries, Entry{Name: dirName, IsDir: true})
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:    name,
+			Size:    uint64(object.Size),
+			ModTime: object.LastModified,
+		})
+	}
+
+	return entries, nil
+}
+
+func (s *S3Storage) Remove(ctx context.Context, filePath string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.key(filePath), minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) RemoveAll(ctx context.Context, folderPath string) error {
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.folderKey(folderPath),
+		Recursive: true,
+	})
+	for result := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) MoveFolder(ctx context.Context, srcPath, dstPath string) error {
+	srcPrefix := s.folderKey(srcPath)
+	dstPrefix := s.folderKey(dstPath)
+
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: dstPrefix, MaxKeys: 1}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		return ErrFileExists
+	}
+
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    srcPrefix,
+		Recursive: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+		dstKey := dstPrefix + strings.TrimPrefix(object.Key, srcPrefix)
+		if _, err := s.client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.bucket, Object: dstKey},
+			minio.CopySrcOptions{Bucket: s.bucket, Object: object.Key},
+		); err != nil {
+			return err
+		}
+	}
+
+	return s.RemoveAll(ctx, srcPath)
+}
+
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Commit() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *s3Writer) Abort() error {
+	w.pw.CloseWithError(errUploadAborted)
+	<-w.done
+	return nil
+}
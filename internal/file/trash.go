@@ -0,0 +1,86 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TrashDirName = ".trash"
+
+func (d *DefaultService) MoveToTrash(folderPath string) (string, error) {
+	name := strconv.FormatInt(time.Now().Unix(), 10) + "_" + folderPath
+	if err := d.storage.MoveFolder(context.Background(), folderPath, path.Join(TrashDirName, name)); err != nil {
+		return "", err
+	}
+	d.checksums.Delete(folderPath)
+	return name, nil
+}
+
+func (d *DefaultService) ListTrash() ([]TrashEntry, error) {
+	entries, err := d.storage.List(context.Background(), TrashDirName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &ErrReadDir{Err: err}
+	}
+
+	var trash []TrashEntry
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		trashEntry, ok := ParseTrashName(entry.Name)
+		if !ok {
+			continue
+		}
+		trash = append(trash, trashEntry)
+	}
+
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].TrashedAt.After(trash[j].TrashedAt)
+	})
+	return trash, nil
+}
+
+func (d *DefaultService) RestoreFromTrash(name string) (string, error) {
+	entry, ok := ParseTrashName(name)
+	if !ok {
+		return "", ErrInvalidTrashEntry
+	}
+	if err := d.storage.MoveFolder(context.Background(), path.Join(TrashDirName, name), entry.Folder); err != nil {
+		return "", err
+	}
+	return entry.Folder, nil
+}
+
+func (d *DefaultService) DeleteFromTrash(name string) error {
+	if _, ok := ParseTrashName(name); !ok {
+		return ErrInvalidTrashEntry
+	}
+	return d.storage.RemoveAll(context.Background(), path.Join(TrashDirName, name))
+}
+
+// ParseTrashName reads the trashing time and the original folder back from the
+// name of a trashed folder
+func ParseTrashName(name string) (TrashEntry, bool) {
+	timestamp, folder, ok := strings.Cut(name, "_")
+	if !ok || folder == "" {
+		return TrashEntry{}, false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return TrashEntry{}, false
+	}
+	return TrashEntry{
+		Name:      name,
+		Folder:    folder,
+		TrashedAt: time.Unix(unix, 0),
+	}, true
+}
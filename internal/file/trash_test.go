@@ -0,0 +1,93 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTrashName(t *testing.T) {
+	trashedAt := time.Unix(1718000000, 0)
+	for _, folder := range []string{
+		"2024-06-10_12-00-00_Ivanov_bracket",
+		"plain",
+		"with spaces (2)",
+	} {
+		name := "1718000000_" + folder
+		entry, ok := ParseTrashName(name)
+		if !ok {
+			t.Errorf("ParseTrashName(%q) failed", name)
+			continue
+		}
+		if entry.Name != name || entry.Folder != folder || !entry.TrashedAt.Equal(trashedAt) {
+			t.Errorf("ParseTrashName(%q) = %+v, want folder %q trashed at %v", name, entry, folder, trashedAt)
+		}
+	}
+
+	for _, name := range []string{"", "1718000000", "1718000000_", "_folder", "yesterday_folder"} {
+		if entry, ok := ParseTrashName(name); ok {
+			t.Errorf("ParseTrashName(%q) = %+v, want it rejected", name, entry)
+		}
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	root := t.TempDir()
+	service := NewDefaultService(NewLocalStorage(root, false))
+	const folder = "2024-06-10_12-00-00_Ivanov_bracket"
+	if err := os.MkdirAll(filepath.Join(root, folder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, folder, "bracket.stl"), []byte("solid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := service.MoveToTrash(folder)
+	if err != nil {
+		t.Fatalf("MoveToTrash() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, folder)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("folder still in place after MoveToTrash(), stat error = %v", err)
+	}
+
+	entries, err := service.ListTrash()
+	if err != nil {
+		t.Fatalf("ListTrash() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != name || entries[0].Folder != folder {
+		t.Fatalf("ListTrash() = %+v, want the trashed %q", entries, folder)
+	}
+
+	restored, err := service.RestoreFromTrash(name)
+	if err != nil {
+		t.Fatalf("RestoreFromTrash() error = %v", err)
+	}
+	if restored != folder {
+		t.Errorf("RestoreFromTrash() = %q, want %q", restored, folder)
+	}
+	data, err := os.ReadFile(filepath.Join(root, folder, "bracket.stl"))
+	if err != nil || string(data) != "solid" {
+		t.Errorf("restored file = %q, %v, want its content back", data, err)
+	}
+	if entries, err := service.ListTrash(); err != nil || len(entries) != 0 {
+		t.Errorf("ListTrash() = %+v, %v after the restore, want it empty", entries, err)
+	}
+
+	// A folder of the same name created meanwhile is never overwritten
+	name, err = service.MoveToTrash(folder)
+	if err != nil {
+		t.Fatalf("MoveToTrash() error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, folder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.RestoreFromTrash(name); !errors.Is(err, ErrFileExists) {
+		t.Errorf("RestoreFromTrash() over an existing folder error = %v, want %v", err, ErrFileExists)
+	}
+
+	if _, err := service.RestoreFromTrash("../" + folder); !errors.Is(err, ErrInvalidTrashEntry) {
+		t.Errorf("RestoreFromTrash() of a foreign name error = %v, want %v", err, ErrInvalidTrashEntry)
+	}
+}
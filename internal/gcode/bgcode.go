@@ -0,0 +1,149 @@
+package gcode
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Binary g-code as written by PrusaSlicer: a file header followed by blocks of
+// metadata, g-code and thumbnails, each optionally compressed and checksummed
+const (
+	bgcodeMagic = "GCDE"
+
+	checksumCRC32 = 1
+
+	blockFileMetadata    = 0
+	blockGCode           = 1
+	blockSlicerMetadata  = 2
+	blockPrinterMetadata = 3
+	blockPrintMetadata   = 4
+	blockThumbnail       = 5
+
+	compressionNone    = 0
+	compressionDeflate = 1
+
+	encodingINI   = 0
+	encodingPlain = 0
+
+	// maxBlockSize guards against corrupted sizes, PrusaSlicer writes blocks
+	// of 64 KiB
+	maxBlockSize = 16 << 20
+)
+
+type bgcodeHeader struct {
+	Magic        [4]byte
+	Version      uint32
+	ChecksumType uint16
+}
+
+type blockHeader struct {
+	Type             uint16
+	Compression      uint16
+	UncompressedSize uint32
+}
+
+// readBinary reads metadata blocks for the slicer estimates and replays the
+// g-code blocks. Blocks packed with heatshrink or MeatPack are skipped, the
+// metadata PrusaSlicer writes is enough for the estimate on its own
+func (p *parser) readBinary(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	var fh bgcodeHeader
+	if err := binary.Read(br, binary.LittleEndian, &fh); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+	}
+	if string(fh.Magic[:]) != bgcodeMagic {
+		return fmt.Errorf("%w: not a binary g-code file", ErrMalformedGCode)
+	}
+
+	for {
+		var bh blockHeader
+		err := binary.Read(br, binary.LittleEndian, &bh)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+		}
+
+		size := bh.UncompressedSize
+		if bh.Compression != compressionNone {
+			if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+			}
+		}
+		if size > maxBlockSize || bh.UncompressedSize > maxBlockSize {
+			return fmt.Errorf("%w: block of %d bytes", ErrMalformedGCode, size)
+		}
+
+		paramsSize := 2
+		if bh.Type == blockThumbnail {
+			paramsSize = 6
+		}
+		params := make([]byte, paramsSize)
+		if _, err := io.ReadFull(br, params); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+		}
+		encoding := binary.LittleEndian.Uint16(params)
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+		}
+		if fh.ChecksumType == checksumCRC32 {
+			if _, err := br.Discard(4); err != nil {
+				return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+			}
+		}
+
+		if err := p.block(bh, encoding, data); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) block(bh blockHeader, encoding uint16, data []byte) error {
+	switch bh.Type {
+	case blockFileMetadata, blockSlicerMetadata, blockPrinterMetadata, blockPrintMetadata:
+		if encoding != encodingINI {
+			return nil
+		}
+	case blockGCode:
+		if encoding != encodingPlain {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	switch bh.Compression {
+	case compressionNone:
+	case compressionDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, int64(bh.UncompressedSize)))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedGCode, err)
+		}
+	default:
+		return nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if bh.Type == blockGCode {
+			p.line(line)
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			p.header.set(key, value)
+		}
+	}
+	return nil
+}
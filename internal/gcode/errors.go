@@ -0,0 +1,9 @@
+package gcode
+
+import "errors"
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported g-code format")
+	ErrMalformedGCode    = errors.New("malformed g-code file")
+	ErrNoEstimate        = errors.New("g-code holds neither slicer estimates nor moves")
+)
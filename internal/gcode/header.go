@@ -0,0 +1,221 @@
+package gcode
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slicers maps lowercased markers found in comments to display names, the
+// first marker seen wins since generators announce themselves at the top
+var slicers = []struct {
+	marker string
+	name   string
+}{
+	{"prusaslicer", "PrusaSlicer"},
+	{"superslicer", "SuperSlicer"},
+	{"orcaslicer", "OrcaSlicer"},
+	{"bambustudio", "Bambu Studio"},
+	{"cura_steamengine", "Cura"},
+}
+
+// timeKeys ranks the print time keys of known slicers, lower is better. Model
+// printing time of Bambu Studio leaves out the filament changes
+var timeKeys = map[string]int{
+	"estimated printing time (normal mode)": 0,
+	"total estimated time":                  0,
+	"print.time":                            1,
+	"time":                                  1,
+	"model printing time":                   2,
+}
+
+// header gathers what the slicer wrote about the print, both from comments of
+// plain g-code and from metadata blocks of binary g-code
+type header struct {
+	slicer   string
+	time     time.Duration
+	timeRank int
+	// lengths and weights are per extruder as slicers report them
+	lengths     []float64
+	weights     []float64
+	totalLength float64
+	totalWeight float64
+}
+
+func newHeader() header {
+	return header{timeRank: len(timeKeys)}
+}
+
+// comment handles the text of a comment without the leading semicolon.
+// Bambu Studio puts several pairs on one line separated by semicolons
+func (h *header) comment(text string) {
+	for _, part := range strings.Split(text, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		h.detectSlicer(part)
+
+		sep := strings.IndexByte(part, '=')
+		if sep < 0 {
+			sep = strings.IndexByte(part, ':')
+		}
+		if sep < 0 {
+			continue
+		}
+		h.set(part[:sep], part[sep+1:])
+	}
+}
+
+func (h *header) detectSlicer(text string) {
+	if h.slicer != "" {
+		return
+	}
+	lower := strings.ToLower(text)
+	for _, s := range slicers {
+		if strings.Contains(lower, s.marker) {
+			h.slicer = s.name
+			return
+		}
+	}
+}
+
+func (h *header) set(key, value string) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+
+	if rank, ok := timeKeys[key]; ok {
+		if rank >= h.timeRank {
+			return
+		}
+		var d time.Duration
+		var parsed bool
+		if key == "time" || key == "print.time" {
+			d, parsed = parseSeconds(value)
+		} else {
+			d, parsed = parseDuration(value)
+		}
+		if parsed {
+			h.time = d
+			h.timeRank = rank
+		}
+		return
+	}
+
+	switch key {
+	case "producer":
+		h.detectSlicer(value)
+	case "filament used [mm]":
+		h.lengths = parseList(value, 1)
+	case "filament used":
+		// Cura reports metres as "1.23456m"
+		h.lengths = parseList(strings.ReplaceAll(value, "m", ""), 1000)
+	case "total filament length [mm]":
+		h.totalLength, _ = parseFloat(value)
+	case "filament used [g]":
+		h.weights = parseList(value, 1)
+	case "total filament used [g]", "total filament weight [g]":
+		h.totalWeight, _ = parseFloat(value)
+	}
+}
+
+func (h *header) hasTime() bool {
+	return h.timeRank < len(timeKeys)
+}
+
+func (h *header) length() float64 {
+	if sum := sum(h.lengths); sum > 0 {
+		return sum
+	}
+	return h.totalLength
+}
+
+func (h *header) weight() float64 {
+	if h.totalWeight > 0 {
+		return h.totalWeight
+	}
+	return sum(h.weights)
+}
+
+// extruders returns the tools with filament reported for them, nil when the
+// slicer only gave totals
+func (h *header) extruders() []int {
+	list := h.lengths
+	if len(list) == 0 {
+		list = h.weights
+	}
+	var extruders []int
+	for i, v := range list {
+		if v > 0 {
+			extruders = append(extruders, i)
+		}
+	}
+	return extruders
+}
+
+// parseDuration reads durations like "1d 2h 3m 4s" written by PrusaSlicer and
+// its forks
+func parseDuration(s string) (time.Duration, bool) {
+	var total time.Duration
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	for _, field := range fields {
+		if len(field) < 2 {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(field[:len(field)-1], 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		var unit time.Duration
+		switch field[len(field)-1] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'h':
+			unit = time.Hour
+		case 'm':
+			unit = time.Minute
+		case 's':
+			unit = time.Second
+		default:
+			return 0, false
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, true
+}
+
+func parseSeconds(s string) (time.Duration, bool) {
+	n, err := parseFloat(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n * float64(time.Second)), true
+}
+
+// parseList reads comma separated per extruder values, scaling each of them
+func parseList(s string, scale float64) []float64 {
+	var list []float64
+	for _, field := range strings.Split(s, ",") {
+		v, err := parseFloat(field)
+		if err != nil {
+			return nil
+		}
+		list = append(list, v*scale)
+	}
+	return list
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+func sum(list []float64) float64 {
+	var total float64
+	for _, v := range list {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,19 @@
+package gcode
+
+import "time"
+
+// Estimate describes a sliced print. Figures the slicer wrote into the file
+// are preferred over simulated ones since slicers know the printer's limits
+type Estimate struct {
+	Slicer    string        `json:"slicer,omitempty"`
+	PrintTime time.Duration `json:"print_time"`
+	// FilamentLength is in mm
+	FilamentLength float64 `json:"filament_length"`
+	// FilamentWeight is in grams and zero when the slicer didn't report it
+	FilamentWeight float64 `json:"filament_weight,omitempty"`
+	// Extruders holds zero based indices of the tools that extrude
+	Extruders []int `json:"extruders"`
+	// Simulated is set when the slicer didn't report the print time and it
+	// was worked out by replaying the moves
+	Simulated bool `json:"simulated"`
+}
@@ -0,0 +1,115 @@
+package gcode
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxLineLength bounds the lines that are looked at, longer ones are embedded
+// slicer settings or thumbnails and carry nothing of interest
+const maxLineLength = 64 << 10
+
+// Supported reports whether Parse understands the file, judging by its extension
+func Supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gcode", ".gco", ".bgcode":
+		return true
+	default:
+		return false
+	}
+}
+
+// Parse reads the estimates the slicer left in a sliced file and replays its
+// moves for whatever the slicer didn't report
+func Parse(name string, r io.Reader) (*Estimate, error) {
+	p := &parser{
+		header: newHeader(),
+		sim:    newSimulator(),
+	}
+
+	var err error
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gcode", ".gco":
+		err = p.readText(r)
+	case ".bgcode":
+		err = p.readBinary(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.estimate()
+}
+
+type parser struct {
+	header header
+	sim    simulator
+}
+
+func (p *parser) readText(r io.Reader) error {
+	br := bufio.NewReaderSize(r, maxLineLength)
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if bytes.IndexByte(line, 0) >= 0 {
+			return ErrMalformedGCode
+		}
+		if len(line) > 0 {
+			p.line(string(line))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) line(line string) {
+	code, comment, found := strings.Cut(line, ";")
+	if found {
+		p.header.comment(comment)
+	}
+	if code = strings.TrimSpace(code); code != "" {
+		p.sim.exec(code)
+	}
+}
+
+func (p *parser) estimate() (*Estimate, error) {
+	if !p.header.hasTime() && p.sim.moves == 0 {
+		return nil, ErrNoEstimate
+	}
+
+	estimate := &Estimate{
+		Slicer:         p.header.slicer,
+		FilamentLength: p.header.length(),
+		FilamentWeight: p.header.weight(),
+		Extruders:      p.header.extruders(),
+	}
+	if p.header.hasTime() {
+		estimate.PrintTime = p.header.time
+	} else {
+		estimate.PrintTime = p.sim.duration()
+		estimate.Simulated = true
+	}
+	if estimate.FilamentLength == 0 {
+		estimate.FilamentLength = p.sim.filament()
+	}
+	if len(estimate.Extruders) == 0 {
+		estimate.Extruders = p.sim.usedTools()
+	}
+	if len(estimate.Extruders) == 0 && estimate.FilamentLength > 0 {
+		estimate.Extruders = []int{0}
+	}
+	return estimate, nil
+}
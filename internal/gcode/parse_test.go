@@ -0,0 +1,235 @@
+package gcode
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const prusaSlicerSample = `; generated by PrusaSlicer 2.7.1+win64 on 2024-03-02 at 10:15:42 UTC
+M73 P0 R62
+M201 X1000 Y1000 Z200 E5000
+G28
+G1 Z.2 F720
+G1 X10 Y10 E1.2 F1800
+; filament used [mm] = 1234.56
+; filament used [cm3] = 2.97
+; filament used [g] = 3.70
+; filament cost = 0.09
+; total filament used [g] = 3.70
+; estimated printing time (normal mode) = 1h 2m 3s
+; estimated printing time (silent mode) = 1h 5m 10s
+`
+
+const curaSample = `;FLAVOR:Marlin
+;TIME:3723
+;Filament used: 1.5m
+;Layer height: 0.2
+;MINX:10
+;Generated with Cura_SteamEngine 5.6.0
+M140 S60
+G28
+;LAYER:0
+G1 F1500 X20 Y20 E2
+;TIME_ELAPSED:120.5
+`
+
+const bambuStudioSample = `; HEADER_BLOCK_START
+; BambuStudio 01.08.04.51
+; model printing time: 1h 30m 0s; total estimated time: 1h 40m 10s
+; total layer number: 100
+; total filament length [mm] : 2600.00
+; total filament weight [g] : 7.80
+; HEADER_BLOCK_END
+G28
+T1
+G1 X10 E5
+; filament used [mm] = 2500.00,100.00
+; filament used [g] = 7.50,0.30
+`
+
+// simulatedSample has no slicer comments: 10 mm at 10 mm/s with acceleration
+// too high to matter, 2 s of dwell and a retraction of 0.5 mm at the same speed
+const simulatedSample = `M204 S100000
+G1 X0 Y0 F600
+G1 X10 E1.5
+G4 P2000
+G1 E1
+`
+
+// bgcodeBlock is a block of a binary g-code file, compressed ones are packed
+// with zlib the way PrusaSlicer does
+type bgcodeBlock struct {
+	kind       uint16
+	compressed bool
+	data       string
+}
+
+func binaryGCode(t *testing.T, blocks []bgcodeBlock) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, bgcodeHeader{Magic: [4]byte{'G', 'C', 'D', 'E'}, Version: 1, ChecksumType: checksumCRC32})
+	for _, b := range blocks {
+		payload := []byte(b.data)
+		compression := uint16(compressionNone)
+		if b.compressed {
+			var packed bytes.Buffer
+			zw := zlib.NewWriter(&packed)
+			if _, err := zw.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			payload, compression = packed.Bytes(), compressionDeflate
+		}
+
+		var block bytes.Buffer
+		binary.Write(&block, binary.LittleEndian, blockHeader{Type: b.kind, Compression: compression, UncompressedSize: uint32(len(b.data))})
+		if b.compressed {
+			binary.Write(&block, binary.LittleEndian, uint32(len(payload)))
+		}
+		binary.Write(&block, binary.LittleEndian, uint16(encodingINI))
+		block.Write(payload)
+		binary.Write(&block, binary.LittleEndian, crc32.ChecksumIEEE(block.Bytes()))
+		buf.Write(block.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	bgcode := binaryGCode(t, []bgcodeBlock{
+		{kind: blockFileMetadata, data: "Producer=PrusaSlicer 2.7.1\n"},
+		{kind: blockPrinterMetadata, compressed: true, data: "printer_model=MK4\nestimated printing time (normal mode)=2h 0m 0s\nfilament used [mm]=3000.00\nfilament used [g]=9.00\n"},
+		{kind: blockThumbnail, data: "not a png"},
+		{kind: blockGCode, compressed: true, data: "G28\nG1 X10 E1 F1200\n"},
+		{kind: blockSlicerMetadata, compressed: true, data: "layer_height=0.2\n"},
+	})
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    *Estimate
+		wantErr error
+	}{
+		{
+			name: "prusaslicer",
+			file: "part_0.2mm_PLA_MK4_1h2m.gcode",
+			data: []byte(prusaSlicerSample),
+			want: &Estimate{Slicer: "PrusaSlicer", PrintTime: time.Hour + 2*time.Minute + 3*time.Second, FilamentLength: 1234.56, FilamentWeight: 3.7, Extruders: []int{0}},
+		},
+		{
+			name: "cura",
+			file: "CE3_part.gcode",
+			data: []byte(curaSample),
+			want: &Estimate{Slicer: "Cura", PrintTime: 3723 * time.Second, FilamentLength: 1500, Extruders: []int{0}},
+		},
+		{
+			name: "bambu studio",
+			file: "plate_1.gcode",
+			data: []byte(bambuStudioSample),
+			want: &Estimate{Slicer: "Bambu Studio", PrintTime: time.Hour + 40*time.Minute + 10*time.Second, FilamentLength: 2600, FilamentWeight: 7.8, Extruders: []int{0, 1}},
+		},
+		{
+			name: "without slicer comments",
+			file: "part.GCO",
+			data: []byte(simulatedSample),
+			want: &Estimate{PrintTime: 3050 * time.Millisecond, FilamentLength: 1, Extruders: []int{0}, Simulated: true},
+		},
+		{
+			name:    "comments only",
+			file:    "empty.gcode",
+			data:    []byte("; nothing to print\n"),
+			wantErr: ErrNoEstimate,
+		},
+		{
+			name:    "binary data",
+			file:    "part.gcode",
+			data:    []byte("G1 X1\x00\x01\x02"),
+			wantErr: ErrMalformedGCode,
+		},
+		{
+			name: "bgcode with deflate blocks",
+			file: "part.bgcode",
+			data: bgcode,
+			want: &Estimate{Slicer: "PrusaSlicer", PrintTime: 2 * time.Hour, FilamentLength: 3000, FilamentWeight: 9, Extruders: []int{0}},
+		},
+		{
+			name:    "truncated bgcode",
+			file:    "part.bgcode",
+			data:    bgcode[:len(bgcode)-20],
+			wantErr: ErrMalformedGCode,
+		},
+		{
+			name:    "bgcode with a wrong magic",
+			file:    "part.bgcode",
+			data:    append([]byte("GCDF"), bgcode[4:]...),
+			wantErr: ErrMalformedGCode,
+		},
+		{
+			name:    "unknown extension",
+			file:    "part.3mf",
+			data:    []byte(prusaSlicerSample),
+			wantErr: ErrUnsupportedFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.file, bytes.NewReader(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.Slicer != tt.want.Slicer || got.Simulated != tt.want.Simulated || !slices.Equal(got.Extruders, tt.want.Extruders) {
+				t.Errorf("Parse() = %q, simulated %t, extruders %v, want %q, %t, %v",
+					got.Slicer, got.Simulated, got.Extruders, tt.want.Slicer, tt.want.Simulated, tt.want.Extruders)
+			}
+			if (got.PrintTime - tt.want.PrintTime).Abs() > 50*time.Millisecond {
+				t.Errorf("Parse() print time = %v, want %v", got.PrintTime, tt.want.PrintTime)
+			}
+			if math.Abs(got.FilamentLength-tt.want.FilamentLength) > 1e-6 || math.Abs(got.FilamentWeight-tt.want.FilamentWeight) > 1e-6 {
+				t.Errorf("Parse() filament = %g mm, %g g, want %g mm, %g g",
+					got.FilamentLength, got.FilamentWeight, tt.want.FilamentLength, tt.want.FilamentWeight)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for name, want := range map[string]bool{
+		"part.gcode":  true,
+		"PART.GCO":    true,
+		"part.bgcode": true,
+		"part.stl":    false,
+		"gcode":       false,
+	} {
+		if got := Supported(name); got != want {
+			t.Errorf("Supported(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+// The header is expected to ignore everything it doesn't know
+func TestHeaderIgnoresUnknownKeys(t *testing.T) {
+	h := newHeader()
+	for _, line := range strings.Split("; foo = bar\n; filament used [mm] = oops\n; estimated printing time (normal mode) = soon", "\n") {
+		h.comment(strings.TrimPrefix(line, ";"))
+	}
+	if h.hasTime() || h.length() != 0 {
+		t.Errorf("header = %+v, want nothing parsed", h)
+	}
+}
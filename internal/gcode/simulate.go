@@ -0,0 +1,356 @@
+package gcode
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultFeedrate and defaultAcceleration stand in until the file sets
+	// its own, in mm/s and mm/s²
+	defaultFeedrate     = 50.0
+	defaultAcceleration = 1500.0
+)
+
+type word struct {
+	letter byte
+	value  float64
+}
+
+// move is a planned segment, speeds are in mm/s
+type move struct {
+	dist  float64
+	speed float64
+	dir   [3]float64
+	entry float64
+}
+
+// duration of the move with a trapezoidal speed profile between the entry and
+// the exit speed
+func (m move) duration(exit, accel float64) float64 {
+	v := m.speed
+	if accel <= 0 {
+		return m.dist / v
+	}
+	v0 := math.Min(m.entry, v)
+	v1 := math.Min(exit, v)
+	// the exit speed may be out of reach within the move
+	v1 = math.Min(v1, math.Sqrt(v0*v0+2*accel*m.dist))
+
+	accelDist := (v*v - v0*v0) / (2 * accel)
+	decelDist := (v*v - v1*v1) / (2 * accel)
+	if accelDist+decelDist <= m.dist {
+		return (v-v0)/accel + (v-v1)/accel + (m.dist-accelDist-decelDist)/v
+	}
+	peak := math.Sqrt((2*accel*m.dist + v0*v0 + v1*v1) / 2)
+	if peak < v0 {
+		// too fast to slow down in time, a real planner would have entered slower
+		return 2 * m.dist / (v0 + v1)
+	}
+	return (peak-v0)/accel + (peak-v1)/accel
+}
+
+// junctionSpeed lets straight continuations keep their speed and makes sharp
+// corners stop, a rough take on the junction deviation of firmwares
+func junctionSpeed(a, b move) float64 {
+	cos := a.dir[0]*b.dir[0] + a.dir[1]*b.dir[1] + a.dir[2]*b.dir[2]
+	if cos <= 0 {
+		return 0
+	}
+	return math.Min(a.speed, b.speed) * cos
+}
+
+// simulator replays motion commands with one move of lookahead
+type simulator struct {
+	pos       [4]float64
+	relative  bool
+	relativeE bool
+	feedrate  float64
+	accel     float64
+	tool      int
+	extruded  map[int]float64
+	seconds   float64
+	moves     int
+	pending   move
+	planned   bool
+}
+
+func newSimulator() simulator {
+	return simulator{
+		feedrate: defaultFeedrate,
+		accel:    defaultAcceleration,
+		extruded: make(map[int]float64),
+	}
+}
+
+// exec runs a line with its comment stripped
+func (s *simulator) exec(line string) {
+	words := parseWords(line)
+	if len(words) == 0 {
+		return
+	}
+	cmd, params := words[0], words[1:]
+	code := int(cmd.value)
+	if float64(code) != cmd.value {
+		return
+	}
+
+	switch cmd.letter {
+	case 'G':
+		switch code {
+		case 0, 1:
+			s.linear(params)
+		case 2, 3:
+			s.arc(params, code == 2)
+		case 4:
+			s.dwell(params)
+		case 28:
+			s.home(params)
+		case 90:
+			s.relative, s.relativeE = false, false
+		case 91:
+			s.relative, s.relativeE = true, true
+		case 92:
+			s.setPosition(params)
+		}
+	case 'M':
+		switch code {
+		case 82:
+			s.relativeE = false
+		case 83:
+			s.relativeE = true
+		case 204:
+			// P is the printing acceleration, older firmwares only take S
+			accel, ok := param(params, 'P')
+			if !ok {
+				accel, ok = param(params, 'S')
+			}
+			if ok && accel > 0 {
+				s.accel = accel
+			}
+		}
+	case 'T':
+		s.flush()
+		s.tool = code
+	}
+}
+
+func (s *simulator) target(params []word) [4]float64 {
+	target := s.pos
+	for i, axis := range []byte{'X', 'Y', 'Z', 'E'} {
+		v, ok := param(params, axis)
+		if !ok {
+			continue
+		}
+		if (i < 3 && s.relative) || (i == 3 && s.relativeE) {
+			target[i] += v
+		} else {
+			target[i] = v
+		}
+	}
+	if f, ok := param(params, 'F'); ok && f > 0 {
+		s.feedrate = f / 60
+	}
+	return target
+}
+
+func (s *simulator) linear(params []word) {
+	target := s.target(params)
+	d := [3]float64{target[0] - s.pos[0], target[1] - s.pos[1], target[2] - s.pos[2]}
+	s.travel(math.Sqrt(d[0]*d[0]+d[1]*d[1]+d[2]*d[2]), d, target[3]-s.pos[3])
+	s.pos = target
+}
+
+func (s *simulator) arc(params []word, clockwise bool) {
+	target := s.target(params)
+	x0, y0 := s.pos[0], s.pos[1]
+	x1, y1 := target[0], target[1]
+	chord := math.Hypot(x1-x0, y1-y0)
+
+	planar := chord
+	i, hasI := param(params, 'I')
+	j, hasJ := param(params, 'J')
+	if hasI || hasJ {
+		cx, cy := x0+i, y0+j
+		start := math.Atan2(y0-cy, x0-cx)
+		end := math.Atan2(y1-cy, x1-cx)
+		sweep := end - start
+		if clockwise {
+			sweep = -sweep
+		}
+		if sweep <= 1e-9 {
+			sweep += 2 * math.Pi
+		}
+		planar = math.Hypot(x0-cx, y0-cy) * sweep
+	} else if r, ok := param(params, 'R'); ok && r != 0 && chord > 0 {
+		sweep := 2 * math.Asin(math.Min(1, chord/(2*math.Abs(r))))
+		if r < 0 {
+			sweep = 2*math.Pi - sweep
+		}
+		planar = math.Abs(r) * sweep
+	}
+
+	// the chord stands in for the direction the arc leaves and enters with
+	d := [3]float64{x1 - x0, y1 - y0, target[2] - s.pos[2]}
+	s.travel(math.Hypot(planar, d[2]), d, target[3]-s.pos[3])
+	s.pos = target
+}
+
+// travel plans a move of dist mm along d, moves of the extruder alone take
+// as long as the filament needs to travel
+func (s *simulator) travel(dist float64, d [3]float64, de float64) {
+	if de != 0 {
+		s.extruded[s.tool] += de
+	}
+
+	var dir [3]float64
+	length := dist
+	if dist > 0 {
+		dir = [3]float64{d[0] / dist, d[1] / dist, d[2] / dist}
+	} else {
+		length = math.Abs(de)
+	}
+	if length == 0 {
+		return
+	}
+
+	s.moves++
+	next := move{dist: length, speed: s.feedrate, dir: dir}
+	if s.planned {
+		junction := junctionSpeed(s.pending, next)
+		s.seconds += s.pending.duration(junction, s.accel)
+		next.entry = junction
+	}
+	s.pending, s.planned = next, true
+}
+
+// flush brings the pending move to a stop
+func (s *simulator) flush() {
+	if s.planned {
+		s.seconds += s.pending.duration(0, s.accel)
+		s.planned = false
+	}
+}
+
+func (s *simulator) dwell(params []word) {
+	s.flush()
+	if ms, ok := param(params, 'P'); ok {
+		s.seconds += ms / 1000
+	} else if sec, ok := param(params, 'S'); ok {
+		s.seconds += sec
+	}
+}
+
+func (s *simulator) home(params []word) {
+	s.flush()
+	homed := false
+	for i, axis := range []byte{'X', 'Y', 'Z'} {
+		if _, ok := param(params, axis); ok {
+			s.pos[i] = 0
+			homed = true
+		}
+	}
+	if !homed {
+		s.pos[0], s.pos[1], s.pos[2] = 0, 0, 0
+	}
+}
+
+func (s *simulator) setPosition(params []word) {
+	set := false
+	for i, axis := range []byte{'X', 'Y', 'Z', 'E'} {
+		if v, ok := param(params, axis); ok {
+			s.pos[i] = v
+			set = true
+		}
+	}
+	if !set {
+		s.pos = [4]float64{}
+	}
+}
+
+func (s *simulator) duration() time.Duration {
+	s.flush()
+	return time.Duration(s.seconds * float64(time.Second))
+}
+
+// filament sums what each tool pushed out net of retractions, in mm
+func (s *simulator) filament() float64 {
+	var total float64
+	for _, e := range s.extruded {
+		total += math.Max(e, 0)
+	}
+	return total
+}
+
+func (s *simulator) usedTools() []int {
+	var tools []int
+	for tool, e := range s.extruded {
+		if e > 0 {
+			tools = append(tools, tool)
+		}
+	}
+	sort.Ints(tools)
+	return tools
+}
+
+// parseWords splits commands like "G1 X10 E0.5" as well as packed ones like
+// "G1X10E0.5" into words. Line numbers and checksums are dropped, lines that
+// don't start with a G, M or T command such as firmware macros yield nothing
+func parseWords(line string) []word {
+	if len(line) > 0 && (line[0] == 'N' || line[0] == 'n') {
+		_, line, _ = strings.Cut(line, " ")
+		line = strings.TrimSpace(line)
+	}
+	if len(line) < 2 || !isDigit(line[1]) {
+		return nil
+	}
+	switch upper(line[0]) {
+	case 'G', 'M', 'T':
+	default:
+		return nil
+	}
+
+	var words []word
+	for i := 0; i < len(line); {
+		c := upper(line[i])
+		if c == '*' {
+			break
+		}
+		if c < 'A' || c > 'Z' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(line) && (isDigit(line[j]) || line[j] == '.' || line[j] == '-' || line[j] == '+') {
+			j++
+		}
+		if v, err := strconv.ParseFloat(line[i+1:j], 64); err == nil {
+			words = append(words, word{letter: c, value: v})
+		}
+		i = j
+	}
+	return words
+}
+
+func param(params []word, letter byte) (float64, bool) {
+	for _, w := range params {
+		if w.letter == letter {
+			return w.value, true
+		}
+	}
+	return 0, false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
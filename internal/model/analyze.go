@@ -0,0 +1,32 @@
+package model
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+// Supported reports whether Analyze understands the file, judging by its extension
+func Supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".stl", ".obj", ".3mf":
+		return true
+	default:
+		return false
+	}
+}
+
+// Analyze parses the model, size is the length of r when known and is used to
+// tell binary STL files from ASCII ones
+func Analyze(name string, r io.Reader, size int64) (*Analysis, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".stl":
+		return analyzeSTL(r, size)
+	case ".obj":
+		return analyzeOBJ(r)
+	case ".3mf":
+		return analyze3MF(r, size)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
@@ -0,0 +1,270 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// cubeQuads index the corners of cubeVertices, every face is wound counter
+// clockwise seen from outside
+var cubeQuads = [6][4]int{
+	{0, 2, 3, 1},
+	{4, 5, 7, 6},
+	{0, 1, 5, 4},
+	{2, 6, 7, 3},
+	{0, 4, 6, 2},
+	{1, 3, 7, 5},
+}
+
+// cubeVertices places corner i at (x, y, z) taken from the bits of i
+func cubeVertices(size float64) []Vec3 {
+	vertices := make([]Vec3, 8)
+	for i := range vertices {
+		vertices[i] = Vec3{float64(i&1) * size, float64(i>>1&1) * size, float64(i>>2&1) * size}
+	}
+	return vertices
+}
+
+func cubeTriangles(size float64) [][3]Vec3 {
+	v := cubeVertices(size)
+	var triangles [][3]Vec3
+	for _, q := range cubeQuads {
+		triangles = append(triangles, [3]Vec3{v[q[0]], v[q[1]], v[q[2]]}, [3]Vec3{v[q[0]], v[q[2]], v[q[3]]})
+	}
+	return triangles
+}
+
+func binarySTL(header string, count uint32, triangles [][3]Vec3) []byte {
+	var buf bytes.Buffer
+	head := make([]byte, 80)
+	copy(head, header)
+	buf.Write(head)
+	binary.Write(&buf, binary.LittleEndian, count)
+	for _, t := range triangles {
+		binary.Write(&buf, binary.LittleEndian, [3]float32{})
+		for _, v := range t {
+			binary.Write(&buf, binary.LittleEndian, [3]float32{float32(v.X), float32(v.Y), float32(v.Z)})
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(0))
+	}
+	return buf.Bytes()
+}
+
+func asciiSTL(triangles [][3]Vec3) []byte {
+	var sb strings.Builder
+	sb.WriteString("solid cube\n")
+	for _, t := range triangles {
+		sb.WriteString("  facet normal 0 0 0\n    outer loop\n")
+		for _, v := range t {
+			fmt.Fprintf(&sb, "      vertex %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		sb.WriteString("    endloop\n  endfacet\n")
+	}
+	sb.WriteString("endsolid cube\n")
+	return []byte(sb.String())
+}
+
+// objCube writes the faces as quads referencing the vertices from the end
+func objCube(size float64) []byte {
+	var sb strings.Builder
+	vertices := cubeVertices(size)
+	for _, v := range vertices {
+		fmt.Fprintf(&sb, "v %g %g %g\n", v.X, v.Y, v.Z)
+	}
+	for _, q := range cubeQuads {
+		sb.WriteString("f")
+		for _, idx := range q {
+			fmt.Fprintf(&sb, " %d//%d", idx-len(vertices), -1)
+		}
+		sb.WriteString("\n")
+	}
+	return []byte(sb.String())
+}
+
+func meshXML(size float64) string {
+	var sb strings.Builder
+	sb.WriteString("<mesh><vertices>")
+	for _, v := range cubeVertices(size) {
+		fmt.Fprintf(&sb, `<vertex x="%g" y="%g" z="%g"/>`, v.X, v.Y, v.Z)
+	}
+	sb.WriteString("</vertices><triangles>")
+	for _, q := range cubeQuads {
+		fmt.Fprintf(&sb, `<triangle v1="%d" v2="%d" v3="%d"/><triangle v1="%d" v2="%d" v3="%d"/>`, q[0], q[1], q[2], q[0], q[2], q[3])
+	}
+	sb.WriteString("</triangles></mesh>")
+	return sb.String()
+}
+
+func threeMF(t *testing.T, resources, build string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(defaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<model unit="millimeter" xmlns="http://schemas.microsoft.com/3dmanufacturing/core/2015/02">
+<resources>%s</resources>
+<build>%s</build>
+</model>`, resources, build)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fanOut3MF nests levels of objects each placing the next one three times
+func fanOut3MF(t *testing.T, levels int) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<object id="1" type="model">%s</object>`, meshXML(1))
+	for id := 2; id <= levels+1; id++ {
+		fmt.Fprintf(&sb, `<object id="%d" type="model"><components>`, id)
+		for range 3 {
+			fmt.Fprintf(&sb, `<component objectid="%d"/>`, id-1)
+		}
+		sb.WriteString("</components></object>")
+	}
+	return threeMF(t, sb.String(), fmt.Sprintf(`<item objectid="%d"/>`, levels+1))
+}
+
+func TestAnalyze(t *testing.T) {
+	cube := cubeTriangles(10)
+	nan := cubeTriangles(10)
+	nan[3][1].Y = math.NaN()
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    *Analysis
+		wantErr error
+	}{
+		{
+			name: "binary stl",
+			file: "cube.stl",
+			data: binarySTL("exported by a CAD", 12, cube),
+			want: &Analysis{Triangles: 12, Max: Vec3{10, 10, 10}, Volume: 1000, Area: 600, Manifold: true},
+		},
+		{
+			name: "binary stl with a solid header",
+			file: "cube.stl",
+			data: binarySTL("solid cube exported in binary", 12, cube),
+			want: &Analysis{Triangles: 12, Max: Vec3{10, 10, 10}, Volume: 1000, Area: 600, Manifold: true},
+		},
+		{
+			name:    "truncated binary stl",
+			file:    "cube.stl",
+			data:    binarySTL("exported by a CAD", 12, cube[:5]),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name:    "truncated binary stl with a solid header",
+			file:    "cube.stl",
+			data:    binarySTL("solid cube", 12, cube[:5]),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name:    "binary stl with a nan vertex",
+			file:    "cube.stl",
+			data:    binarySTL("exported by a CAD", 12, nan),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name: "ascii stl",
+			file: "cube.STL",
+			data: asciiSTL(cube),
+			want: &Analysis{Triangles: 12, Max: Vec3{10, 10, 10}, Volume: 1000, Area: 600, Manifold: true},
+		},
+		{
+			name: "ascii stl with a missing facet",
+			file: "cube.stl",
+			data: asciiSTL(cube[1:]),
+			want: &Analysis{Triangles: 11, Max: Vec3{10, 10, 10}, Volume: 1000, Area: 550, Manifold: false},
+		},
+		{
+			name:    "ascii stl with an infinite vertex",
+			file:    "cube.stl",
+			data:    []byte("solid x\nfacet normal 0 0 1\nouter loop\nvertex 0 0 0\nvertex inf 0 0\nvertex 0 1 0\nendloop\nendfacet\nendsolid x\n"),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name: "obj with negative indices",
+			file: "cube.obj",
+			data: objCube(10),
+			want: &Analysis{Triangles: 12, Max: Vec3{10, 10, 10}, Volume: 1000, Area: 600, Manifold: true},
+		},
+		{
+			name:    "obj with an index past the vertices",
+			file:    "cube.obj",
+			data:    []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf -1 -2 -4\n"),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name: "3mf with nested components",
+			file: "plate.3mf",
+			data: threeMF(t, fmt.Sprintf(`
+<object id="1" type="model">%s</object>
+<object id="2" type="model"><components>
+	<component objectid="1"/>
+	<component objectid="1" transform="1 0 0 0 1 0 0 0 1 20 0 0"/>
+</components></object>
+<object id="3" type="model"><components>
+	<component objectid="2" transform="1 0 0 0 1 0 0 0 1 0 0 5"/>
+</components></object>`, meshXML(10)), `<item objectid="3"/>`),
+			want: &Analysis{Triangles: 24, Min: Vec3{0, 0, 5}, Max: Vec3{30, 10, 15}, Volume: 2000, Area: 1200, Manifold: true},
+		},
+		{
+			name:    "3mf with a component cycle",
+			file:    "plate.3mf",
+			data:    threeMF(t, `<object id="1" type="model"><components><component objectid="1"/></components></object>`, `<item objectid="1"/>`),
+			wantErr: ErrMalformedModel,
+		},
+		{
+			name:    "3mf fanning out past the instance limit",
+			file:    "plate.3mf",
+			data:    fanOut3MF(t, 12),
+			wantErr: ErrUnsupportedFormat,
+		},
+		{
+			name:    "unknown extension",
+			file:    "cube.step",
+			data:    []byte("ISO-10303-21;"),
+			wantErr: ErrUnsupportedFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Analyze(tt.file, bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Analyze() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if got.Triangles != tt.want.Triangles || got.Manifold != tt.want.Manifold {
+				t.Errorf("Analyze() = %d triangles, manifold %t, want %d, %t", got.Triangles, got.Manifold, tt.want.Triangles, tt.want.Manifold)
+			}
+			if got.Min != tt.want.Min || got.Max != tt.want.Max {
+				t.Errorf("Analyze() bounds = %v-%v, want %v-%v", got.Min, got.Max, tt.want.Min, tt.want.Max)
+			}
+			if !near(got.Volume, tt.want.Volume) || !near(got.Area, tt.want.Area) {
+				t.Errorf("Analyze() volume %g, area %g, want %g, %g", got.Volume, got.Area, tt.want.Volume, tt.want.Area)
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
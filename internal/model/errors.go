@@ -0,0 +1,8 @@
+package model
+
+import "errors"
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported model format")
+	ErrMalformedModel    = errors.New("malformed model file")
+)
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"math"
+)
+
+type vertexKey struct {
+	shell int
+	x     float32
+	y     float32
+	z     float32
+}
+
+type edgeKey struct {
+	a, b uint32
+}
+
+// edgeUse counts how often an edge is walked in each direction, a closed and
+// consistently oriented surface walks every edge once each way
+type edgeUse struct {
+	forward, backward uint32
+}
+
+// meshBuilder welds vertices by position within a shell, separate objects of
+// a 3MF file are separate shells so that touching parts aren't mistaken for
+// non-manifold edges
+type meshBuilder struct {
+	shell    int
+	vertices map[vertexKey]uint32
+	edges    map[edgeKey]edgeUse
+	analysis Analysis
+	signed   float64
+}
+
+func newMeshBuilder() *meshBuilder {
+	return &meshBuilder{
+		vertices: make(map[vertexKey]uint32),
+		edges:    make(map[edgeKey]edgeUse),
+		analysis: Analysis{
+			Min: Vec3{math.Inf(1), math.Inf(1), math.Inf(1)},
+			Max: Vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+		},
+	}
+}
+
+func (m *meshBuilder) newShell() {
+	m.shell++
+}
+
+func (m *meshBuilder) addTriangle(a, b, c Vec3) {
+	m.analysis.Triangles++
+	for _, v := range [3]Vec3{a, b, c} {
+		m.analysis.Min = Vec3{math.Min(m.analysis.Min.X, v.X), math.Min(m.analysis.Min.Y, v.Y), math.Min(m.analysis.Min.Z, v.Z)}
+		m.analysis.Max = Vec3{math.Max(m.analysis.Max.X, v.X), math.Max(m.analysis.Max.Y, v.Y), math.Max(m.analysis.Max.Z, v.Z)}
+	}
+	m.signed += a.dot(b.cross(c)) / 6
+	m.analysis.Area += b.sub(a).cross(c.sub(a)).length() / 2
+
+	ia, ib, ic := m.vertex(a), m.vertex(b), m.vertex(c)
+	// Degenerate triangles have no surface, their edges would only add noise
+	if ia == ib || ib == ic || ia == ic {
+		return
+	}
+	m.addEdge(ia, ib)
+	m.addEdge(ib, ic)
+	m.addEdge(ic, ia)
+}
+
+func (m *meshBuilder) vertex(v Vec3) uint32 {
+	key := vertexKey{shell: m.shell, x: float32(v.X), y: float32(v.Y), z: float32(v.Z)}
+	id, ok := m.vertices[key]
+	if !ok {
+		id = uint32(len(m.vertices))
+		m.vertices[key] = id
+	}
+	return id
+}
+
+func (m *meshBuilder) addEdge(from, to uint32) {
+	key, forward := edgeKey{from, to}, true
+	if from > to {
+		key, forward = edgeKey{to, from}, false
+	}
+	use := m.edges[key]
+	if forward {
+		use.forward++
+	} else {
+		use.backward++
+	}
+	m.edges[key] = use
+}
+
+func (m *meshBuilder) result() (*Analysis, error) {
+	if m.analysis.Triangles == 0 {
+		return nil, ErrMalformedModel
+	}
+
+	analysis := m.analysis
+	analysis.Volume = math.Abs(m.signed)
+	// Finite coordinates can still overflow once transformed or multiplied,
+	// the analysis is stored as JSON which has no room for infinities
+	if !analysis.Min.finite() || !analysis.Max.finite() || !isFinite(analysis.Volume) || !isFinite(analysis.Area) {
+		return nil, fmt.Errorf("%w: coordinates are out of range", ErrMalformedModel)
+	}
+	analysis.Manifold = true
+	for _, use := range m.edges {
+		if use.forward != 1 || use.backward != 1 {
+			analysis.Manifold = false
+			break
+		}
+	}
+	return &analysis, nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import "math"
+
+type Vec3 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+func (v Vec3) sub(o Vec3) Vec3 {
+	return Vec3{v.X - o.X, v.Y - o.Y, v.Z - o.Z}
+}
+
+func (v Vec3) cross(o Vec3) Vec3 {
+	return Vec3{v.Y*o.Z - v.Z*o.Y, v.Z*o.X - v.X*o.Z, v.X*o.Y - v.Y*o.X}
+}
+
+func (v Vec3) dot(o Vec3) float64 {
+	return v.X*o.X + v.Y*o.Y + v.Z*o.Z
+}
+
+func (v Vec3) length() float64 {
+	return math.Sqrt(v.dot(v))
+}
+
+func (v Vec3) finite() bool {
+	return isFinite(v.X) && isFinite(v.Y) && isFinite(v.Z)
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// Analysis describes a mesh in millimetres, files without units are assumed
+// to be modelled in millimetres as slicers do
+type Analysis struct {
+	Triangles int  `json:"triangles"`
+	Min       Vec3 `json:"min"`
+	Max       Vec3 `json:"max"`
+	// Volume is in mm³ and only meaningful for closed meshes
+	Volume float64 `json:"volume"`
+	// Area is in mm²
+	Area float64 `json:"area"`
+	// Manifold is false when some edge isn't shared by exactly two triangles
+	// or the triangles around it are inconsistently oriented
+	Manifold bool `json:"manifold"`
+}
+
+func (a *Analysis) Size() Vec3 {
+	return a.Max.sub(a.Min)
+}
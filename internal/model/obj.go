@@ -0,0 +1,67 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// analyzeOBJ reads vertices and faces only, texture coordinates, normals and
+// groups don't change the geometry
+func analyzeOBJ(r io.Reader) (*Analysis, error) {
+	mb := newMeshBuilder()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var vertices []Vec3
+	var polygon []Vec3
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			v, err := parseVec3(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			vertices = append(vertices, v)
+		case "f":
+			polygon = polygon[:0]
+			for _, ref := range fields[1:] {
+				idx, err := objVertexIndex(ref, len(vertices))
+				if err != nil {
+					return nil, err
+				}
+				polygon = append(polygon, vertices[idx])
+			}
+			addPolygon(mb, polygon)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+	return mb.result()
+}
+
+// objVertexIndex resolves "v", "v/vt", "v//vn" and "v/vt/vn" references,
+// negative indices count back from the last vertex read
+func objVertexIndex(ref string, count int) (int, error) {
+	vStr, _, _ := strings.Cut(ref, "/")
+	idx, err := strconv.Atoi(vStr)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+	if idx < 0 {
+		idx += count
+	} else {
+		idx--
+	}
+	if idx < 0 || idx >= count {
+		return 0, fmt.Errorf("%w: vertex %s is out of range", ErrMalformedModel, vStr)
+	}
+	return idx, nil
+}
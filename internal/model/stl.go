@@ -0,0 +1,127 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	stlHeaderSize   = 84
+	stlTriangleSize = 50
+	// stlSniffSize is enough to see the first facet of an ASCII file
+	stlSniffSize = 512
+)
+
+// analyzeSTL tells the encodings apart by the triangle count matching the
+// size first, since plenty of binary files start their header with "solid"
+func analyzeSTL(r io.Reader, size int64) (*Analysis, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, err := br.Peek(stlSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	if len(head) >= stlHeaderSize {
+		count := binary.LittleEndian.Uint32(head[80:stlHeaderSize])
+		if size == stlHeaderSize+stlTriangleSize*int64(count) {
+			return analyzeBinarySTL(br, count)
+		}
+	}
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("solid")) && (bytes.Contains(head, []byte("facet")) || bytes.Contains(head, []byte("endsolid"))) {
+		return analyzeASCIISTL(br)
+	}
+	if len(head) >= stlHeaderSize {
+		return analyzeBinarySTL(br, binary.LittleEndian.Uint32(head[80:stlHeaderSize]))
+	}
+	return nil, ErrMalformedModel
+}
+
+func analyzeBinarySTL(r io.Reader, count uint32) (*Analysis, error) {
+	if _, err := io.CopyN(io.Discard, r, stlHeaderSize); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+
+	mb := newMeshBuilder()
+	buf := make([]byte, stlTriangleSize)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("%w: truncated at triangle %d of %d", ErrMalformedModel, i, count)
+		}
+		// The normal is skipped, slicers recompute it from the winding as well
+		var v [3]Vec3
+		for j := range v {
+			offset := 12 + j*12
+			v[j] = Vec3{
+				X: float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset:]))),
+				Y: float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset+4:]))),
+				Z: float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset+8:]))),
+			}
+			if !v[j].finite() {
+				return nil, fmt.Errorf("%w: triangle %d has a non-finite vertex", ErrMalformedModel, i)
+			}
+		}
+		mb.addTriangle(v[0], v[1], v[2])
+	}
+	return mb.result()
+}
+
+func analyzeASCIISTL(r io.Reader) (*Analysis, error) {
+	mb := newMeshBuilder()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var loop []Vec3
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "vertex":
+			v, err := parseVec3(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			loop = append(loop, v)
+		case "endloop":
+			addPolygon(mb, loop)
+			loop = loop[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+	return mb.result()
+}
+
+func parseVec3(fields []string) (Vec3, error) {
+	if len(fields) < 3 {
+		return Vec3{}, fmt.Errorf("%w: vertex needs three coordinates", ErrMalformedModel)
+	}
+	var coords [3]float64
+	for i := range coords {
+		c, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return Vec3{}, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+		}
+		if !isFinite(c) {
+			return Vec3{}, fmt.Errorf("%w: non-finite coordinate %q", ErrMalformedModel, fields[i])
+		}
+		coords[i] = c
+	}
+	return Vec3{coords[0], coords[1], coords[2]}, nil
+}
+
+// addPolygon fan-triangulates convex polygons, triangles pass through as is
+func addPolygon(mb *meshBuilder, polygon []Vec3) {
+	for i := 2; i < len(polygon); i++ {
+		mb.addTriangle(polygon[0], polygon[i-1], polygon[i])
+	}
+}
@@ -0,0 +1,344 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// max3MFSize caps archives that have to be read into memory first
+	max3MFSize = 512 << 20
+	// maxPartSize guards against zip bombs, it fits the biggest plates of
+	// sliced projects
+	maxPartSize = 512 << 20
+	// maxComponentDepth stops component cycles
+	maxComponentDepth = 16
+	// maxElements caps the vertices and triangles held in memory over all
+	// parts, maxTriangles the triangles placed on the plate and maxInstances
+	// the objects placed, so that components referencing each other several
+	// times can't fan out into an endless plate
+	maxElements  = 20_000_000
+	maxTriangles = 10_000_000
+	maxInstances = 100_000
+
+	defaultModelPath = "3D/3dmodel.model"
+)
+
+var unitScales = map[string]float64{
+	"micron":     0.001,
+	"millimeter": 1,
+	"centimeter": 10,
+	"inch":       25.4,
+	"foot":       304.8,
+	"meter":      1000,
+}
+
+// matrix is a 3MF transform "m00 m01 m02 m10 m11 m12 m20 m21 m22 m30 m31 m32",
+// points are row vectors multiplied from the left
+type matrix [12]float64
+
+var identity = matrix{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+
+func parseMatrix(s string) (matrix, error) {
+	if s == "" {
+		return identity, nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 12 {
+		return matrix{}, fmt.Errorf("%w: transform needs 12 values", ErrMalformedModel)
+	}
+	var m matrix
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return matrix{}, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+		}
+		if !isFinite(v) {
+			return matrix{}, fmt.Errorf("%w: non-finite transform value %q", ErrMalformedModel, f)
+		}
+		m[i] = v
+	}
+	return m, nil
+}
+
+func (m matrix) apply(v Vec3) Vec3 {
+	return Vec3{
+		X: v.X*m[0] + v.Y*m[3] + v.Z*m[6] + m[9],
+		Y: v.X*m[1] + v.Y*m[4] + v.Z*m[7] + m[10],
+		Z: v.X*m[2] + v.Y*m[5] + v.Z*m[8] + m[11],
+	}
+}
+
+// then returns the transform applying m first and o after it
+func (m matrix) then(o matrix) matrix {
+	var r matrix
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				r[row*3+col] += m[row*3+k] * o[k*3+col]
+			}
+		}
+	}
+	r[9] += o[9]
+	r[10] += o[10]
+	r[11] += o[11]
+	return r
+}
+
+type component struct {
+	objectID  string
+	path      string
+	transform matrix
+}
+
+type object struct {
+	vertices   []Vec3
+	triangles  [][3]int
+	components []component
+}
+
+type modelPart struct {
+	scale   float64
+	objects map[string]*object
+	// order keeps objects in document order for files without build items
+	order []string
+	items []component
+}
+
+type document struct {
+	zip   *zip.Reader
+	parts map[string]*modelPart
+	// elements, triangles and instances count up to their limits
+	elements  int
+	triangles int
+	instances int
+}
+
+// analyze3MF places every build item the way the slicer would, so the result
+// describes the plate rather than the individual objects
+func analyze3MF(r io.Reader, size int64) (*Analysis, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok || size <= 0 {
+		data, err := io.ReadAll(io.LimitReader(r, max3MFSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > max3MFSize {
+			return nil, fmt.Errorf("%w: archive is larger than %d bytes", ErrUnsupportedFormat, max3MFSize)
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+	doc := &document{zip: zr, parts: make(map[string]*modelPart)}
+
+	rootPath := doc.rootModelPath()
+	root, err := doc.part(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	items := root.items
+	if len(items) == 0 {
+		for _, id := range root.order {
+			items = append(items, component{objectID: id, transform: identity})
+		}
+	}
+
+	mb := newMeshBuilder()
+	scale := matrix{root.scale, 0, 0, 0, root.scale, 0, 0, 0, root.scale, 0, 0, 0}
+	for _, item := range items {
+		path := rootPath
+		if item.path != "" {
+			path = item.path
+		}
+		if err := doc.emit(mb, path, item.objectID, item.transform.then(scale), 0); err != nil {
+			return nil, err
+		}
+	}
+	return mb.result()
+}
+
+func (d *document) rootModelPath() string {
+	f, err := d.zip.Open("_rels/.rels")
+	if err != nil {
+		return defaultModelPath
+	}
+	defer f.Close()
+
+	var rels struct {
+		Relationships []struct {
+			Target string `xml:"Target,attr"`
+			Type   string `xml:"Type,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(f, maxPartSize)).Decode(&rels); err != nil {
+		return defaultModelPath
+	}
+	for _, rel := range rels.Relationships {
+		if strings.HasSuffix(rel.Type, "/3dmodel") {
+			return strings.TrimPrefix(rel.Target, "/")
+		}
+	}
+	return defaultModelPath
+}
+
+func (d *document) emit(mb *meshBuilder, path, objectID string, transform matrix, depth int) error {
+	if depth > maxComponentDepth {
+		return fmt.Errorf("%w: components nest too deep", ErrMalformedModel)
+	}
+	part, err := d.part(path)
+	if err != nil {
+		return err
+	}
+	obj, ok := part.objects[objectID]
+	if !ok {
+		return fmt.Errorf("%w: object %s is missing from %s", ErrMalformedModel, objectID, path)
+	}
+
+	d.instances++
+	d.triangles += len(obj.triangles)
+	if d.instances > maxInstances || d.triangles > maxTriangles {
+		return fmt.Errorf("%w: the plate has more than %d objects or %d triangles", ErrUnsupportedFormat, maxInstances, maxTriangles)
+	}
+	if len(obj.triangles) > 0 {
+		mb.newShell()
+		for _, t := range obj.triangles {
+			for _, idx := range t {
+				if idx < 0 || idx >= len(obj.vertices) {
+					return fmt.Errorf("%w: vertex %d is out of range", ErrMalformedModel, idx)
+				}
+			}
+			mb.addTriangle(transform.apply(obj.vertices[t[0]]), transform.apply(obj.vertices[t[1]]), transform.apply(obj.vertices[t[2]]))
+		}
+	}
+	for _, c := range obj.components {
+		componentPath := path
+		if c.path != "" {
+			componentPath = c.path
+		}
+		if err := d.emit(mb, componentPath, c.objectID, c.transform.then(transform), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *document) part(path string) (*modelPart, error) {
+	if part, ok := d.parts[path]; ok {
+		return part, nil
+	}
+	f, err := d.zip.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+	}
+	defer f.Close()
+
+	part, err := d.parseModelPart(io.LimitReader(f, maxPartSize))
+	if err != nil {
+		return nil, err
+	}
+	d.parts[path] = part
+	return part, nil
+}
+
+// hold counts a vertex or triangle about to be kept in memory
+func (d *document) hold() error {
+	d.elements++
+	if d.elements > maxElements {
+		return fmt.Errorf("%w: the model has more than %d vertices and triangles", ErrUnsupportedFormat, maxElements)
+	}
+	return nil
+}
+
+// parseModelPart streams the XML, model parts of sliced projects easily reach
+// hundreds of megabytes
+func (d *document) parseModelPart(r io.Reader) (*modelPart, error) {
+	part := &modelPart{scale: 1, objects: make(map[string]*object)}
+	decoder := xml.NewDecoder(r)
+
+	var current *object
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return part, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			attrs := make(map[string]string, len(t.Attr))
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+
+			switch t.Name.Local {
+			case "model":
+				if scale, ok := unitScales[attrs["unit"]]; ok {
+					part.scale = scale
+				}
+			case "object":
+				current = &object{}
+				part.objects[attrs["id"]] = current
+				part.order = append(part.order, attrs["id"])
+			case "vertex":
+				if current == nil {
+					continue
+				}
+				if err := d.hold(); err != nil {
+					return nil, err
+				}
+				v, err := parseVec3([]string{attrs["x"], attrs["y"], attrs["z"]})
+				if err != nil {
+					return nil, err
+				}
+				current.vertices = append(current.vertices, v)
+			case "triangle":
+				if current == nil {
+					continue
+				}
+				if err := d.hold(); err != nil {
+					return nil, err
+				}
+				var tri [3]int
+				for i, name := range [3]string{"v1", "v2", "v3"} {
+					idx, err := strconv.Atoi(attrs[name])
+					if err != nil {
+						return nil, fmt.Errorf("%w: %v", ErrMalformedModel, err)
+					}
+					tri[i] = idx
+				}
+				current.triangles = append(current.triangles, tri)
+			case "component", "item":
+				transform, err := parseMatrix(attrs["transform"])
+				if err != nil {
+					return nil, err
+				}
+				c := component{
+					objectID:  attrs["objectid"],
+					path:      strings.TrimPrefix(attrs["path"], "/"),
+					transform: transform,
+				}
+				if t.Name.Local == "item" {
+					part.items = append(part.items, c)
+				} else if current != nil {
+					current.components = append(current.components, c)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "object" {
+				current = nil
+			}
+		}
+	}
+}
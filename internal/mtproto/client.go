@@ -7,6 +7,7 @@ import (
 	"log"
 	"print3d-order-bot/internal/mtproto/internal"
 	"print3d-order-bot/pkg/config"
+	"sync"
 	"time"
 
 	"github.com/gotd/td/telegram"
@@ -24,6 +25,8 @@ type Client struct {
 	cancel     context.CancelFunc
 	done       chan struct{}
 	ready      chan struct{}
+	// channelHashes caches access hashes of supergroups by channel ID
+	channelHashes sync.Map
 }
 
 func NewClient(ctx context.Context, cfg *config.MTProtoCfg) (*Client, error) {
@@ -78,8 +81,13 @@ func NewClient(ctx context.Context, cfg *config.MTProtoCfg) (*Client, error) {
 	}
 }
 
-func (c *Client) UploadFile(ctx context.Context, filename string, file io.ReadCloser, userID int64) error {
+func (c *Client) UploadFile(ctx context.Context, filename string, file io.ReadCloser, chatID int64) error {
 	defer file.Close()
+	peer, err := c.resolvePeer(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
 	upload, err := c.uploader.FromReader(ctx, filename, file)
 	if err != nil {
 		return err
@@ -87,17 +95,44 @@ func (c *Client) UploadFile(ctx context.Context, filename string, file io.ReadCl
 
 	document := message.UploadedDocument(upload).Filename(filename)
 
-	peer := &tg.InputPeerUser{
-		UserID:     userID,
-		AccessHash: 0,
-	}
-
 	if _, err := c.sender.To(peer).Media(ctx, document); err != nil {
 		return err
 	}
 	return nil
 }
 
+// channelIDOffset is the prefix of supergroup IDs in the Bot API, -100xxxxxxxxxx
+const channelIDOffset = -1000000000000
+
+// resolvePeer converts a Bot API chat ID into an MTProto peer
+func (c *Client) resolvePeer(ctx context.Context, chatID int64) (tg.InputPeerClass, error) {
+	switch {
+	case chatID > 0:
+		return &tg.InputPeerUser{UserID: chatID, AccessHash: 0}, nil
+	case chatID > channelIDOffset:
+		return &tg.InputPeerChat{ChatID: -chatID}, nil
+	}
+
+	channelID := channelIDOffset - chatID
+	if hash, ok := c.channelHashes.Load(channelID); ok {
+		return &tg.InputPeerChannel{ChannelID: channelID, AccessHash: hash.(int64)}, nil
+	}
+
+	result, err := c.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{
+		&tg.InputChannel{ChannelID: channelID, AccessHash: 0},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve chat %d: %w", chatID, err)
+	}
+	for _, chat := range result.GetChats() {
+		if channel, ok := chat.(*tg.Channel); ok && channel.ID == channelID {
+			c.channelHashes.Store(channelID, channel.AccessHash)
+			return channel.AsInputPeer(), nil
+		}
+	}
+	return nil, fmt.Errorf("chat %d not found", chatID)
+}
+
 func (c *Client) DownloadFile(ctx context.Context, fileID string, dst io.Writer) error {
 	fileInfo, err := internal.ParseFileID(fileID)
 	if err != nil {
@@ -2,4 +2,13 @@ package order
 
 import "errors"
 
-var ErrRestorationPeriodExpired = errors.New("restoration period expired")
+var (
+	ErrRestorationPeriodExpired = errors.New("restoration period expired")
+	ErrInvalidStatusTransition  = errors.New("invalid status transition")
+	ErrOrderNotTerminal         = errors.New("order is neither closed nor cancelled")
+	ErrOrderNotFound            = errors.New("order not found")
+	ErrOrderUnpaid              = errors.New("order is not fully paid")
+	ErrInvalidPayment           = errors.New("invalid payment")
+	ErrInvalidOrderItem         = errors.New("invalid order item")
+	ErrCostFromItems            = errors.New("order cost is set by its items")
+)
@@ -1,6 +1,8 @@
 package order
 
 import (
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
 	"time"
 )
 
@@ -8,17 +10,23 @@ type File struct {
 	Name     string
 	Checksum uint64
 	TgFileID *string
+	Analysis *model.Analysis
+	GCode    *gcode.Estimate
 }
 
 type RequestNewOrder struct {
 	PrintType  string
+	ClientID   *int
 	ClientName string
 	Cost       float32
 	Comments   []string
 	Contacts   []string
 	Links      []string
 	CreatedAt  time.Time
+	DueAt      *time.Time
 	FolderPath string
+	// Items replace Cost with their total when there are any
+	Items []OrderItem
 }
 
 type RequestEditOrder struct {
@@ -32,37 +40,300 @@ type RequestEditOrder struct {
 type Status string
 
 const (
-	StatusActive Status = "active"
-	StatusClosed        = "closed"
+	StatusNew                Status = "new"
+	StatusQuoted             Status = "quoted"
+	StatusAwaitingPrepayment Status = "awaiting_prepayment"
+	StatusQueued             Status = "queued"
+	StatusPrinting           Status = "printing"
+	StatusPostProcessing     Status = "post_processing"
+	StatusReady              Status = "ready"
+	StatusDelivered          Status = "delivered"
+	StatusClosed             Status = "closed"
+	StatusCancelled          Status = "cancelled"
 )
 
+var Statuses = []Status{
+	StatusNew,
+	StatusQuoted,
+	StatusAwaitingPrepayment,
+	StatusQueued,
+	StatusPrinting,
+	StatusPostProcessing,
+	StatusReady,
+	StatusDelivered,
+	StatusClosed,
+	StatusCancelled,
+}
+
+func (s Status) IsValid() bool {
+	for _, status := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether the order has left the production pipeline
+// and can only be brought back by restoring it
+func (s Status) IsTerminal() bool {
+	return s == StatusClosed || s == StatusCancelled
+}
+
+// IsDone reports whether the order no longer has a deadline to meet
+func (s Status) IsDone() bool {
+	return s == StatusReady || s == StatusDelivered || s.IsTerminal()
+}
+
 type ResponseOrder struct {
 	ID         int
 	Status     Status
 	PrintType  string
+	ClientID   int
 	ClientName string
 	Cost       float32
 	Comments   []string
 	Contacts   []string
 	Links      []string
 	CreatedAt  time.Time
-	ClosedAt   *time.Time
-	FolderPath string
-	Files      []File
+	DueAt      *time.Time
+	// ResponsibleID is the Telegram user reminded about the deadline, zero when unknown
+	ResponsibleID int64
+	Items         []OrderItem
+	Payments      []Payment
+	Paid          float32
+	// Outstanding is the part of the cost still to be paid, never below zero
+	Outstanding     float32
+	ClosedAt        *time.Time
+	StatusChangedAt map[Status]time.Time
+	FolderPath      string
+	ArchivePath     string
+	// UnarchivedAt is set while the files of an archived order are unpacked
+	// back into its folder, the archive itself is kept
+	UnarchivedAt *time.Time
+	Files        []File
+}
+
+// PrintEstimate sums the slicer estimates of the sliced order files, the
+// filament length is in mm and its weight in grams
+func (o *ResponseOrder) PrintEstimate() (printTime time.Duration, length, weight float64) {
+	for _, file := range o.Files {
+		if file.GCode == nil {
+			continue
+		}
+		printTime += file.GCode.PrintTime
+		length += file.GCode.FilamentLength
+		weight += file.GCode.FilamentWeight
+	}
+	return printTime, length, weight
+}
+
+// OrderItem is a line of the bill of materials, FileName is empty for items
+// without a model file
+type OrderItem struct {
+	ID       int
+	FileName string
+	Quantity int
+	Material string
+	Color    string
+	// LayerHeight is in millimetres, Infill in percent
+	LayerHeight *float32
+	Infill      *int
+	UnitPrice   float32
+}
+
+func (i *OrderItem) Total() float32 {
+	return float32(i.Quantity) * i.UnitPrice
+}
+
+func ItemsTotal(items []OrderItem) float32 {
+	var total float32
+	for _, item := range items {
+		total += item.Total()
+	}
+	return total
+}
+
+type DBOrderItem struct {
+	ID          int      `db:"id"`
+	OrderID     int      `db:"order_id"`
+	FileName    *string  `db:"file_name"`
+	Position    int      `db:"position"`
+	Quantity    int      `db:"quantity"`
+	Material    string   `db:"material"`
+	Color       string   `db:"color"`
+	LayerHeight *float32 `db:"layer_height"`
+	Infill      *int     `db:"infill"`
+	UnitPrice   float32  `db:"unit_price"`
+}
+
+type PaymentMethod string
+
+const (
+	PaymentCash     PaymentMethod = "cash"
+	PaymentCard     PaymentMethod = "card"
+	PaymentTransfer PaymentMethod = "transfer"
+	PaymentOther    PaymentMethod = "other"
+)
+
+var PaymentMethods = []PaymentMethod{
+	PaymentCash,
+	PaymentCard,
+	PaymentTransfer,
+	PaymentOther,
+}
+
+func (m PaymentMethod) IsValid() bool {
+	for _, method := range PaymentMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+type RequestNewPayment struct {
+	Amount float32
+	Method PaymentMethod
+	PaidAt time.Time
+	Note   string
+}
+
+type Payment struct {
+	ID     int
+	Amount float32
+	Method PaymentMethod
+	PaidAt time.Time
+	Note   string
+	UserID int64
+}
+
+type DBPayment struct {
+	ID      int           `db:"id"`
+	OrderID int           `db:"order_id"`
+	Amount  float32       `db:"amount"`
+	Method  PaymentMethod `db:"method"`
+	PaidAt  time.Time     `db:"paid_at"`
+	Note    *string       `db:"note"`
+	UserID  int64         `db:"user_id"`
+}
+
+// ArchiveMonth counts the orders closed or cancelled during the month
+type ArchiveMonth struct {
+	Year  int
+	Month time.Month
+	Count int
+}
+
+type DBArchiveMonth struct {
+	Month string `db:"month"`
+	Count int    `db:"count"`
+}
+
+type SortOrder string
+
+const (
+	SortCreatedAsc  SortOrder = "created_asc"
+	SortCreatedDesc SortOrder = "created_desc"
+	SortCostDesc    SortOrder = "cost_desc"
+	SortCostAsc     SortOrder = "cost_asc"
+	SortDueAsc      SortOrder = "due_asc"
+)
+
+// OrderFilter narrows the order list, zero values don't filter. Without
+// statuses only active and recently finished orders are listed
+type OrderFilter struct {
+	PrintTypes  []string
+	Statuses    []Status
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	CostMin     *float32
+	CostMax     *float32
+	ClientID    *int
+	HasFiles    *bool
+	Sort        SortOrder
+}
+
+// IsEmpty reports whether the filter lists the same orders as the default view
+func (f *OrderFilter) IsEmpty() bool {
+	return len(f.PrintTypes) == 0 && len(f.Statuses) == 0 && f.CreatedFrom == nil && f.CreatedTo == nil &&
+		f.CostMin == nil && f.CostMax == nil && f.ClientID == nil && f.HasFiles == nil &&
+		(f.Sort == "" || f.Sort == SortCreatedAsc)
+}
+
+// OrderSummary is the part of the order shown in lists
+type OrderSummary struct {
+	ID            int
+	Status        Status
+	PrintType     string
+	ClientName    string
+	CreatedAt     time.Time
+	DueAt         *time.Time
+	ResponsibleID int64
+	// PrintTime sums the slicer estimates of the order files
+	PrintTime time.Duration
+}
+
+type DBOrderSummary struct {
+	ID            int        `db:"id"`
+	Status        Status     `db:"status"`
+	PrintType     string     `db:"print_type"`
+	ClientName    string     `db:"client_name"`
+	CreatedAt     time.Time  `db:"created_at"`
+	DueAt         *time.Time `db:"due_at"`
+	ResponsibleID *int64     `db:"responsible_id"`
+	PrintTime     int64      `db:"print_time"`
 }
 
 type DBNewOrder struct {
-	ID         int        `db:"id"`
-	Status     Status     `db:"status"`
-	PrintType  string     `db:"print_type"`
-	ClientName string     `db:"client_name"`
-	Cost       float32    `db:"cost"`
-	Comments   []string   `db:"comments"`
-	Contacts   []string   `db:"contacts"`
-	Links      []string   `db:"links"`
-	CreatedAt  time.Time  `db:"created_at"`
-	ClosedAt   *time.Time `db:"closed_at"`
-	FolderPath string     `db:"folder_path"`
+	ID                   int        `db:"id"`
+	Status               Status     `db:"status"`
+	PrintType            string     `db:"print_type"`
+	ClientID             *int       `db:"client_id"`
+	ClientName           string     `db:"client_name"`
+	Cost                 float32    `db:"cost"`
+	Comments             []string   `db:"comments"`
+	Contacts             []string   `db:"contacts"`
+	Links                []string   `db:"links"`
+	CreatedAt            time.Time  `db:"created_at"`
+	DueAt                *time.Time `db:"due_at"`
+	ResponsibleID        *int64     `db:"responsible_id"`
+	FolderPath           string     `db:"folder_path"`
+	ArchivePath          *string    `db:"archive_path"`
+	UnarchivedAt         *time.Time `db:"unarchived_at"`
+	QuotedAt             *time.Time `db:"quoted_at"`
+	AwaitingPrepaymentAt *time.Time `db:"awaiting_prepayment_at"`
+	QueuedAt             *time.Time `db:"queued_at"`
+	PrintingAt           *time.Time `db:"printing_at"`
+	PostProcessingAt     *time.Time `db:"post_processing_at"`
+	ReadyAt              *time.Time `db:"ready_at"`
+	DeliveredAt          *time.Time `db:"delivered_at"`
+	ClosedAt             *time.Time `db:"closed_at"`
+	CancelledAt          *time.Time `db:"cancelled_at"`
+}
+
+// StatusTimestamps maps every status the order has been moved to onto the
+// moment it happened
+func (o *DBNewOrder) StatusTimestamps() map[Status]time.Time {
+	timestamps := map[Status]time.Time{
+		StatusNew: o.CreatedAt,
+	}
+	for status, ts := range map[Status]*time.Time{
+		StatusQuoted:             o.QuotedAt,
+		StatusAwaitingPrepayment: o.AwaitingPrepaymentAt,
+		StatusQueued:             o.QueuedAt,
+		StatusPrinting:           o.PrintingAt,
+		StatusPostProcessing:     o.PostProcessingAt,
+		StatusReady:              o.ReadyAt,
+		StatusDelivered:          o.DeliveredAt,
+		StatusClosed:             o.ClosedAt,
+		StatusCancelled:          o.CancelledAt,
+	} {
+		if ts != nil {
+			timestamps[status] = *ts
+		}
+	}
+	return timestamps
 }
 
 type DBEditOrder struct {
@@ -75,8 +346,49 @@ type DBEditOrder struct {
 }
 
 type DBFile struct {
-	Name     string  `db:"name"`
-	Checksum uint64  `db:"checksum"`
-	TgFileID *string `db:"tg_file_id"`
-	OrderID  int     `db:"order_id"`
+	Name     string          `db:"name"`
+	Checksum uint64          `db:"checksum"`
+	TgFileID *string         `db:"tg_file_id"`
+	Analysis *model.Analysis `db:"analysis"`
+	GCode    *gcode.Estimate `db:"gcode"`
+	OrderID  int             `db:"order_id"`
+}
+
+// SystemUserID marks changes made by background services rather than a
+// Telegram user
+const SystemUserID int64 = 0
+
+type EventType string
+
+const (
+	EventCreated       EventType = "created"
+	EventEdited        EventType = "edited"
+	EventStatusChanged EventType = "status_changed"
+	EventFileAdded     EventType = "file_added"
+	EventFileRemoved   EventType = "file_removed"
+	EventFileUpdated   EventType = "file_updated"
+	EventArchived      EventType = "archived"
+	EventUnarchived    EventType = "unarchived"
+	EventPaymentAdded  EventType = "payment_added"
+)
+
+type OrderEvent struct {
+	ID        int
+	UserID    int64
+	Type      EventType
+	Field     string
+	OldValue  string
+	NewValue  string
+	CreatedAt time.Time
+}
+
+type DBOrderEvent struct {
+	ID        int       `db:"id"`
+	OrderID   int       `db:"order_id"`
+	UserID    int64     `db:"user_id"`
+	Type      EventType `db:"type"`
+	Field     *string   `db:"field"`
+	OldValue  *string   `db:"old_value"`
+	NewValue  *string   `db:"new_value"`
+	CreatedAt time.Time `db:"created_at"`
 }
@@ -0,0 +1,52 @@
+package order
+
+import (
+	"fmt"
+	"slices"
+)
+
+type Pipeline map[Status][]Status
+
+func DefaultPipeline() Pipeline {
+	return Pipeline{
+		StatusNew:                {StatusQuoted, StatusQueued, StatusCancelled},
+		StatusQuoted:             {StatusAwaitingPrepayment, StatusQueued, StatusCancelled},
+		StatusAwaitingPrepayment: {StatusQueued, StatusCancelled},
+		StatusQueued:             {StatusPrinting, StatusCancelled},
+		StatusPrinting:           {StatusPostProcessing, StatusReady, StatusQueued, StatusCancelled},
+		StatusPostProcessing:     {StatusReady, StatusCancelled},
+		StatusReady:              {StatusDelivered, StatusCancelled},
+		StatusDelivered:          {StatusClosed},
+	}
+}
+
+func NewPipeline(transitions map[string][]string) (Pipeline, error) {
+	if len(transitions) == 0 {
+		return DefaultPipeline(), nil
+	}
+
+	pipeline := make(Pipeline, len(transitions))
+	for from, targets := range transitions {
+		fromStatus := Status(from)
+		if !fromStatus.IsValid() {
+			return nil, fmt.Errorf("unknown order status in pipeline: %s", from)
+		}
+		for _, to := range targets {
+			toStatus := Status(to)
+			if !toStatus.IsValid() {
+				return nil, fmt.Errorf("unknown order status in pipeline: %s", to)
+			}
+			pipeline[fromStatus] = append(pipeline[fromStatus], toStatus)
+		}
+	}
+
+	return pipeline, nil
+}
+
+func (p Pipeline) CanTransition(from, to Status) bool {
+	return slices.Contains(p[from], to)
+}
+
+func (p Pipeline) Next(from Status) []Status {
+	return p[from]
+}
@@ -2,47 +2,99 @@ package order
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 )
 
 type Service interface {
-	NewOrder(ctx context.Context, order RequestNewOrder, files []File) error
-	AddFilesToOrder(ctx context.Context, orderID int, files []File) error
+	NewOrder(ctx context.Context, order RequestNewOrder, files []File, userID int64) (int, error)
+	AddFilesToOrder(ctx context.Context, orderID int, files []File, userID int64) error
 	GetOrderFilenames(ctx context.Context, orderID int) ([]string, error)
 	GetActiveOrdersIDs(ctx context.Context) ([]int, error)
 	GetActiveOrdersFolders(ctx context.Context) ([]string, error)
+	GetAllOrdersFolders(ctx context.Context) ([]string, error)
+	KeepRestoredFolder(ctx context.Context, folder string) error
+	GetRestoredFolders(ctx context.Context) ([]string, error)
+	PruneRestoredFolders(ctx context.Context, existing []string, listedAt time.Time, retention time.Duration) error
+	ListOrders(ctx context.Context, filter OrderFilter) ([]int, error)
+	GetArchiveMonths(ctx context.Context) ([]ArchiveMonth, error)
+	GetClosedOrdersIDs(ctx context.Context, year int, month time.Month) ([]int, error)
+	SearchOrders(ctx context.Context, query string) ([]int, error)
+	GetOrderSummaries(ctx context.Context, ids []int) ([]OrderSummary, error)
+	GetOrdersDueWithin(ctx context.Context, within time.Duration) ([]OrderSummary, error)
+	GetOverdueOrders(ctx context.Context) ([]OrderSummary, error)
+	MarkDueReminded(ctx context.Context, orderID int) error
 	GetOrderByID(ctx context.Context, orderID int) (*ResponseOrder, error)
-	CloseOrder(ctx context.Context, orderID int) error
-	RestoreOrder(ctx context.Context, orderID int) error
-	EditOrder(ctx context.Context, orderID int, order RequestEditOrder) error
-	RemoveOrderFiles(ctx context.Context, orderID int, filenames []string) error
-	UpdateOrderFiles(ctx context.Context, orderID int, files []File) error
+	GetOrderIDByFolder(ctx context.Context, folderPath string) (int, error)
+	GetNextStatuses(status Status) []Status
+	ChangeOrderStatus(ctx context.Context, orderID int, status Status, userID int64) error
+	ForceChangeOrderStatus(ctx context.Context, orderID int, status Status, userID int64) error
+	RestoreOrder(ctx context.Context, orderID int, userID int64) error
+	ForceRestoreOrder(ctx context.Context, orderID int, userID int64) error
+	EditOrder(ctx context.Context, orderID int, order RequestEditOrder, userID int64) error
+	RemoveOrderFiles(ctx context.Context, orderID int, filenames []string, userID int64) error
+	UpdateOrderFiles(ctx context.Context, orderID int, files []File, userID int64) error
+	GetOrderHistory(ctx context.Context, orderID int) ([]OrderEvent, error)
+	GetArchivableOrdersIDs(ctx context.Context) ([]int, error)
+	SetOrderArchive(ctx context.Context, orderID int, archivePath string, userID int64) error
+	SetOrderUnarchived(ctx context.Context, orderID int, unarchived bool) error
+	AddPayment(ctx context.Context, orderID int, payment RequestNewPayment, userID int64) error
+	ReplaceOrderItems(ctx context.Context, orderID int, items []OrderItem, userID int64) error
 }
 
+const (
+	maxSearchResults         = 100
+	archiveMonthLayout       = "2006-01"
+	defaultRestorationPeriod = 24 * time.Hour
+)
+
 type DefaultService struct {
-	repo Repo
+	repo              Repo
+	pipeline          Pipeline
+	restorationPeriod time.Duration
 }
 
-func NewDefaultService(repo Repo) Service {
+func NewDefaultService(repo Repo, pipeline Pipeline, restorationPeriod time.Duration) Service {
 	return &DefaultService{
-		repo: repo,
+		repo:              repo,
+		pipeline:          pipeline,
+		restorationPeriod: restorationPeriodOrDefault(restorationPeriod),
 	}
 }
 
-func (d *DefaultService) NewOrder(ctx context.Context, order RequestNewOrder, files []File) error {
+func restorationPeriodOrDefault(period time.Duration) time.Duration {
+	if period <= 0 {
+		return defaultRestorationPeriod
+	}
+	return period
+}
+
+func (d *DefaultService) NewOrder(ctx context.Context, order RequestNewOrder, files []File, userID int64) (int, error) {
 	dbOrder := DBNewOrder{
-		Status:     StatusActive,
+		Status:     StatusNew,
 		PrintType:  order.PrintType,
+		ClientID:   order.ClientID,
 		ClientName: order.ClientName,
 		Cost:       order.Cost,
 		Comments:   order.Comments,
 		Contacts:   order.Contacts,
 		Links:      order.Links,
 		CreatedAt:  order.CreatedAt,
+		DueAt:      order.DueAt,
 		FolderPath: order.FolderPath,
 	}
+	if userID != SystemUserID {
+		dbOrder.ResponsibleID = &userID
+	}
+	if len(order.Items) > 0 {
+		if !validItems(order.Items) {
+			return 0, ErrInvalidOrderItem
+		}
+		dbOrder.Cost = ItemsTotal(order.Items)
+	}
 
 	dbFiles := make([]DBFile, len(files))
 	for i, file := range files {
@@ -50,29 +102,34 @@ func (d *DefaultService) NewOrder(ctx context.Context, order RequestNewOrder, fi
 			Name:     file.Name,
 			Checksum: file.Checksum,
 			TgFileID: file.TgFileID,
+			Analysis: file.Analysis,
+			GCode:    file.GCode,
 		}
 	}
 
-	if err := d.repo.NewOrder(ctx, dbOrder, dbFiles); err != nil {
+	orderID, err := d.repo.NewOrder(ctx, dbOrder, dbFiles, toDBOrderItems(order.Items), userID)
+	if err != nil {
 		slog.Error("Failed to create new order", "error", err)
-		return err
+		return 0, err
 	}
 
-	return nil
+	return orderID, nil
 }
 
-func (d *DefaultService) AddFilesToOrder(ctx context.Context, orderID int, files []File) error {
+func (d *DefaultService) AddFilesToOrder(ctx context.Context, orderID int, files []File, userID int64) error {
 	dbFiles := make([]DBFile, len(files))
 	for i, file := range files {
 		dbFiles[i] = DBFile{
 			Name:     file.Name,
 			Checksum: file.Checksum,
 			TgFileID: file.TgFileID,
+			Analysis: file.Analysis,
+			GCode:    file.GCode,
 			OrderID:  orderID,
 		}
 	}
 
-	if err := d.repo.AddFilesToOrder(ctx, orderID, dbFiles); err != nil {
+	if err := d.repo.AddFilesToOrder(ctx, orderID, dbFiles, userID); err != nil {
 		slog.Error("Failed to add files to order", "error", err, "orderID", orderID)
 		return err
 	}
@@ -108,6 +165,196 @@ func (d *DefaultService) GetActiveOrdersFolders(ctx context.Context) ([]string,
 	return folders, nil
 }
 
+func (d *DefaultService) ListOrders(ctx context.Context, filter OrderFilter) ([]int, error) {
+	ids, err := d.repo.ListOrders(ctx, filter)
+	if err != nil {
+		slog.Error("Error listing orders", "error", err, "filter", filter)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (d *DefaultService) GetArchiveMonths(ctx context.Context) ([]ArchiveMonth, error) {
+	dbMonths, err := d.repo.GetArchiveMonths(ctx)
+	if err != nil {
+		slog.Error("Error retrieving archive months", "error", err)
+		return nil, err
+	}
+
+	months := make([]ArchiveMonth, 0, len(dbMonths))
+	for _, dbMonth := range dbMonths {
+		parsed, err := time.Parse(archiveMonthLayout, dbMonth.Month)
+		if err != nil {
+			slog.Error("Unexpected archive month", "error", err, "month", dbMonth.Month)
+			continue
+		}
+		months = append(months, ArchiveMonth{
+			Year:  parsed.Year(),
+			Month: parsed.Month(),
+			Count: dbMonth.Count,
+		})
+	}
+	return months, nil
+}
+
+// GetClosedOrdersIDs lists the orders closed or cancelled during the month,
+// the most recently finished first
+func (d *DefaultService) GetClosedOrdersIDs(ctx context.Context, year int, month time.Month) ([]int, error) {
+	key := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Format(archiveMonthLayout)
+	ids, err := d.repo.GetClosedOrdersIDs(ctx, key)
+	if err != nil {
+		slog.Error("Error retrieving closed orders IDs", "error", err, "month", key)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetAllOrdersFolders returns the folders of every order, finished ones included
+func (d *DefaultService) GetAllOrdersFolders(ctx context.Context) ([]string, error) {
+	folders, err := d.repo.GetOrdersFolders(ctx, false)
+	if err != nil {
+		slog.Error("Error retrieving orders folders", "error", err)
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// KeepRestoredFolder protects a folder brought back from the trash from the
+// orphan sweep, it has no order to keep it otherwise
+func (d *DefaultService) KeepRestoredFolder(ctx context.Context, folder string) error {
+	if err := d.repo.AddRestoredFolder(ctx, folder); err != nil {
+		slog.Error("Error keeping restored folder", "error", err, "folder", folder)
+		return err
+	}
+	return nil
+}
+
+func (d *DefaultService) GetRestoredFolders(ctx context.Context) ([]string, error) {
+	folders, err := d.repo.GetRestoredFolders(ctx)
+	if err != nil {
+		slog.Error("Error retrieving restored folders", "error", err)
+		return nil, err
+	}
+	return folders, nil
+}
+
+// PruneRestoredFolders stops keeping restored folders once they belong to an
+// order or are no longer among the existing ones listed at listedAt. Folders
+// kept longer than the retention are left to the orphan sweep again, a zero
+// retention keeps them for good
+func (d *DefaultService) PruneRestoredFolders(ctx context.Context, existing []string, listedAt time.Time, retention time.Duration) error {
+	var restoredBefore time.Time
+	if retention > 0 {
+		restoredBefore = time.Now().Add(-retention)
+	}
+	if err := d.repo.DeleteRestoredFolders(ctx, existing, listedAt, restoredBefore); err != nil {
+		slog.Error("Error pruning restored folders", "error", err)
+		return err
+	}
+	return nil
+}
+
+// SearchOrders looks the query words up in client names, comments, contacts,
+// links and file names, the newest orders come first
+func (d *DefaultService) SearchOrders(ctx context.Context, query string) ([]int, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	ids, err := d.repo.SearchOrders(ctx, terms, maxSearchResults)
+	if err != nil {
+		slog.Error("Error searching orders", "error", err, "query", query)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetOrderSummaries keeps the order of ids, missing orders are skipped
+func (d *DefaultService) GetOrderSummaries(ctx context.Context, ids []int) ([]OrderSummary, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	dbSummaries, err := d.repo.GetOrderSummaries(ctx, ids)
+	if err != nil {
+		slog.Error("Error retrieving order summaries", "error", err)
+		return nil, err
+	}
+
+	byID := make(map[int]DBOrderSummary, len(dbSummaries))
+	for _, summary := range dbSummaries {
+		byID[summary.ID] = summary
+	}
+	summaries := make([]OrderSummary, 0, len(ids))
+	for _, id := range ids {
+		summary, ok := byID[id]
+		if !ok {
+			continue
+		}
+		summaries = append(summaries, toOrderSummary(summary))
+	}
+	return summaries, nil
+}
+
+// GetOrdersDueWithin returns the unfinished orders due in the given time that
+// haven't been reminded about yet
+func (d *DefaultService) GetOrdersDueWithin(ctx context.Context, within time.Duration) ([]OrderSummary, error) {
+	dbSummaries, err := d.repo.GetOrdersDueBefore(ctx, time.Now().Add(within))
+	if err != nil {
+		slog.Error("Error retrieving orders due soon", "error", err)
+		return nil, err
+	}
+	return toOrderSummaries(dbSummaries), nil
+}
+
+func (d *DefaultService) GetOverdueOrders(ctx context.Context) ([]OrderSummary, error) {
+	dbSummaries, err := d.repo.GetOverdueOrders(ctx)
+	if err != nil {
+		slog.Error("Error retrieving overdue orders", "error", err)
+		return nil, err
+	}
+	return toOrderSummaries(dbSummaries), nil
+}
+
+func (d *DefaultService) MarkDueReminded(ctx context.Context, orderID int) error {
+	if err := d.repo.MarkDueReminded(ctx, orderID); err != nil {
+		slog.Error("Error marking order as reminded", "error", err, "orderID", orderID)
+		return err
+	}
+	return nil
+}
+
+func toOrderSummaries(dbSummaries []DBOrderSummary) []OrderSummary {
+	summaries := make([]OrderSummary, len(dbSummaries))
+	for i, summary := range dbSummaries {
+		summaries[i] = toOrderSummary(summary)
+	}
+	return summaries
+}
+
+func toOrderSummary(summary DBOrderSummary) OrderSummary {
+	return OrderSummary{
+		ID:            summary.ID,
+		Status:        summary.Status,
+		PrintType:     summary.PrintType,
+		ClientName:    summary.ClientName,
+		CreatedAt:     summary.CreatedAt,
+		DueAt:         summary.DueAt,
+		ResponsibleID: derefOrZero(summary.ResponsibleID),
+		PrintTime:     time.Duration(summary.PrintTime),
+	}
+}
+
+func (d *DefaultService) GetOrderIDByFolder(ctx context.Context, folderPath string) (int, error) {
+	id, err := d.repo.GetOrderIDByFolder(ctx, folderPath)
+	if err != nil && !errors.Is(err, ErrOrderNotFound) {
+		slog.Error("Error retrieving order by folder", "error", err, "folder", folderPath)
+	}
+	return id, err
+}
+
 func (d *DefaultService) GetOrderByID(ctx context.Context, orderID int) (*ResponseOrder, error) {
 	dbOrder, err := d.repo.GetOrderByID(ctx, orderID)
 	if err != nil {
@@ -130,52 +377,144 @@ func (d *DefaultService) GetOrderByID(ctx context.Context, orderID int) (*Respon
 			Name:     file.Name,
 			Checksum: file.Checksum,
 			TgFileID: file.TgFileID,
+			Analysis: file.Analysis,
+			GCode:    file.GCode,
+		}
+	}
+
+	dbItems, err := d.repo.GetOrderItems(ctx, orderID)
+	if err != nil {
+		slog.Error("Error retrieving order items", "error", err, "orderID", orderID)
+		return nil, err
+	}
+
+	items := fromDBOrderItems(dbItems)
+
+	dbPayments, err := d.repo.GetOrderPayments(ctx, orderID)
+	if err != nil {
+		slog.Error("Error retrieving order payments", "error", err, "orderID", orderID)
+		return nil, err
+	}
+
+	payments := make([]Payment, len(dbPayments))
+	for i, payment := range dbPayments {
+		payments[i] = Payment{
+			ID:     payment.ID,
+			Amount: payment.Amount,
+			Method: payment.Method,
+			PaidAt: payment.PaidAt,
+			Note:   derefOrEmpty(payment.Note),
+			UserID: payment.UserID,
 		}
 	}
+	paid, outstanding := paymentTotals(dbOrder.Cost, dbPayments)
 
 	order := &ResponseOrder{
-		ID:         dbOrder.ID,
-		Status:     dbOrder.Status,
-		PrintType:  dbOrder.PrintType,
-		ClientName: dbOrder.ClientName,
-		Cost:       dbOrder.Cost,
-		Comments:   dbOrder.Comments,
-		Contacts:   dbOrder.Contacts,
-		Links:      dbOrder.Links,
-		CreatedAt:  dbOrder.CreatedAt,
-		ClosedAt:   dbOrder.ClosedAt,
-		FolderPath: dbOrder.FolderPath,
-		Files:      files,
+		ID:              dbOrder.ID,
+		Status:          dbOrder.Status,
+		PrintType:       dbOrder.PrintType,
+		ClientID:        derefOrZero(dbOrder.ClientID),
+		ClientName:      dbOrder.ClientName,
+		Cost:            dbOrder.Cost,
+		Comments:        dbOrder.Comments,
+		Contacts:        dbOrder.Contacts,
+		Links:           dbOrder.Links,
+		CreatedAt:       dbOrder.CreatedAt,
+		DueAt:           dbOrder.DueAt,
+		ResponsibleID:   derefOrZero(dbOrder.ResponsibleID),
+		Items:           items,
+		Payments:        payments,
+		Paid:            paid,
+		Outstanding:     outstanding,
+		ClosedAt:        dbOrder.ClosedAt,
+		StatusChangedAt: dbOrder.StatusTimestamps(),
+		FolderPath:      dbOrder.FolderPath,
+		ArchivePath:     derefOrEmpty(dbOrder.ArchivePath),
+		UnarchivedAt:    dbOrder.UnarchivedAt,
+		Files:           files,
 	}
 
 	return order, nil
 }
 
-func (d *DefaultService) CloseOrder(ctx context.Context, orderID int) error {
-	if err := d.repo.UpdateOrderStatus(ctx, orderID, StatusClosed); err != nil {
-		slog.Error("Error closing order", "error", err, "orderID", orderID)
+func (d *DefaultService) GetNextStatuses(status Status) []Status {
+	return d.pipeline.Next(status)
+}
+
+func (d *DefaultService) ChangeOrderStatus(ctx context.Context, orderID int, status Status, userID int64) error {
+	return d.changeOrderStatus(ctx, orderID, status, userID, false)
+}
+
+// ForceChangeOrderStatus delivers the order even if it isn't fully paid, the
+// pipeline is still respected
+func (d *DefaultService) ForceChangeOrderStatus(ctx context.Context, orderID int, status Status, userID int64) error {
+	return d.changeOrderStatus(ctx, orderID, status, userID, true)
+}
+
+// changeOrderStatus checks the transition under the lock of the order row, so
+// concurrent changes can't both pass it
+func (d *DefaultService) changeOrderStatus(ctx context.Context, orderID int, status Status, userID int64, force bool) error {
+	err := d.repo.UpdateOrderStatus(ctx, orderID, status, userID, func(current Status, cost, paid float32) error {
+		if !d.pipeline.CanTransition(current, status) {
+			return ErrInvalidStatusTransition
+		}
+		if status == StatusDelivered && !force && outstandingAmount(cost, paid) > 0 {
+			return ErrOrderUnpaid
+		}
+		return nil
+	})
+	if errors.Is(err, ErrInvalidStatusTransition) || errors.Is(err, ErrOrderUnpaid) {
+		return err
+	}
+	if err != nil {
+		slog.Error("Error changing order status", "error", err, "orderID", orderID, "status", status)
 		return err
 	}
 	return nil
 }
 
-func (d *DefaultService) RestoreOrder(ctx context.Context, orderID int) error {
+func (d *DefaultService) RestoreOrder(ctx context.Context, orderID int, userID int64) error {
+	return d.restoreOrder(ctx, orderID, userID, false)
+}
+
+// ForceRestoreOrder restores the order even after the restoration period, the
+// caller is responsible for bringing its files back from the archive
+func (d *DefaultService) ForceRestoreOrder(ctx context.Context, orderID int, userID int64) error {
+	return d.restoreOrder(ctx, orderID, userID, true)
+}
+
+func (d *DefaultService) restoreOrder(ctx context.Context, orderID int, userID int64, force bool) error {
 	order, err := d.repo.GetOrderByID(ctx, orderID)
 	if err != nil {
 		slog.Error("Error restoring order", "error", err, "orderID", orderID)
 		return err
 	}
-	if order.ClosedAt != nil && time.Since(order.ClosedAt.UTC()).Hours() >= 24 {
+	if !order.Status.IsTerminal() {
+		return ErrOrderNotTerminal
+	}
+
+	timestamps := order.StatusTimestamps()
+	if finishedAt, ok := timestamps[order.Status]; ok && !force && time.Since(finishedAt) >= d.restorationPeriod {
 		return ErrRestorationPeriodExpired
 	}
-	if err := d.repo.UpdateOrderStatus(ctx, orderID, StatusActive); err != nil {
+
+	err = d.repo.UpdateOrderStatus(ctx, orderID, lastProductionStatus(timestamps), userID, func(current Status, _, _ float32) error {
+		if !current.IsTerminal() {
+			return ErrOrderNotTerminal
+		}
+		return nil
+	})
+	if errors.Is(err, ErrOrderNotTerminal) {
+		return err
+	}
+	if err != nil {
 		slog.Error("Error restoring order", "error", err, "orderID", orderID)
 		return err
 	}
 	return nil
 }
 
-func (d *DefaultService) EditOrder(ctx context.Context, orderID int, order RequestEditOrder) error {
+func (d *DefaultService) EditOrder(ctx context.Context, orderID int, order RequestEditOrder, userID int64) error {
 	dbOrder := DBEditOrder{
 		ID:               orderID,
 		PrintType:        order.PrintType,
@@ -184,35 +523,234 @@ func (d *DefaultService) EditOrder(ctx context.Context, orderID int, order Reque
 		Comments:         order.Comments,
 		OverrideComments: order.OverrideComments,
 	}
-	if err := d.repo.EditOrder(ctx, dbOrder); err != nil {
+	err := d.repo.EditOrder(ctx, dbOrder, userID)
+	if errors.Is(err, ErrCostFromItems) {
+		return err
+	}
+	if err != nil {
 		slog.Error("Error editing order", "error", err, "orderID", orderID)
 		return err
 	}
 	return nil
 }
 
-func (d *DefaultService) RemoveOrderFiles(ctx context.Context, orderID int, filenames []string) error {
-	if err := d.repo.DeleteOrderFiles(ctx, orderID, filenames); err != nil {
+func (d *DefaultService) RemoveOrderFiles(ctx context.Context, orderID int, filenames []string, userID int64) error {
+	if err := d.repo.DeleteOrderFiles(ctx, orderID, filenames, userID); err != nil {
 		slog.Error("Error removing order files", "error", err, "orderID", orderID)
 		return err
 	}
 	return nil
 }
 
-func (d *DefaultService) UpdateOrderFiles(ctx context.Context, orderID int, files []File) error {
+func (d *DefaultService) UpdateOrderFiles(ctx context.Context, orderID int, files []File, userID int64) error {
 	dbFiles := make([]DBFile, len(files))
 	for i, file := range files {
 		dbFiles[i] = DBFile{
 			Name:     file.Name,
 			Checksum: file.Checksum,
 			TgFileID: file.TgFileID,
+			Analysis: file.Analysis,
+			GCode:    file.GCode,
 		}
 	}
 
-	if err := d.repo.UpdateOrderFiles(ctx, orderID, dbFiles); err != nil {
+	if err := d.repo.UpdateOrderFiles(ctx, orderID, dbFiles, userID); err != nil {
 		slog.Error("Failed to update order files", "error", err, "orderID", orderID)
 		return err
 	}
 
 	return nil
 }
+
+func (d *DefaultService) GetOrderHistory(ctx context.Context, orderID int) ([]OrderEvent, error) {
+	dbEvents, err := d.repo.GetOrderEvents(ctx, orderID)
+	if err != nil {
+		slog.Error("Error retrieving order history", "error", err, "orderID", orderID)
+		return nil, err
+	}
+
+	events := make([]OrderEvent, len(dbEvents))
+	for i, event := range dbEvents {
+		events[i] = OrderEvent{
+			ID:        event.ID,
+			UserID:    event.UserID,
+			Type:      event.Type,
+			Field:     derefOrEmpty(event.Field),
+			OldValue:  derefOrEmpty(event.OldValue),
+			NewValue:  derefOrEmpty(event.NewValue),
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	return events, nil
+}
+
+func (d *DefaultService) GetArchivableOrdersIDs(ctx context.Context) ([]int, error) {
+	ids, err := d.repo.GetArchivableOrdersIDs(ctx)
+	if err != nil {
+		slog.Error("Error retrieving archivable orders IDs", "error", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SetOrderArchive records where the order files were archived to, an empty
+// path marks the files as unpacked back into the order folder
+func (d *DefaultService) SetOrderArchive(ctx context.Context, orderID int, archivePath string, userID int64) error {
+	var path *string
+	if archivePath != "" {
+		path = &archivePath
+	}
+	if err := d.repo.UpdateOrderArchivePath(ctx, orderID, path, userID); err != nil {
+		slog.Error("Error updating order archive", "error", err, "orderID", orderID)
+		return err
+	}
+	return nil
+}
+
+// SetOrderUnarchived marks the files of an archived order as unpacked into its
+// folder or, once they are removed again, as only kept in the archive
+func (d *DefaultService) SetOrderUnarchived(ctx context.Context, orderID int, unarchived bool) error {
+	var unarchivedAt *time.Time
+	if unarchived {
+		now := time.Now()
+		unarchivedAt = &now
+	}
+	if err := d.repo.UpdateOrderUnarchivedAt(ctx, orderID, unarchivedAt); err != nil {
+		slog.Error("Error updating order unarchive time", "error", err, "orderID", orderID)
+		return err
+	}
+	return nil
+}
+
+func (d *DefaultService) AddPayment(ctx context.Context, orderID int, payment RequestNewPayment, userID int64) error {
+	if payment.Amount <= 0 || !payment.Method.IsValid() {
+		return ErrInvalidPayment
+	}
+
+	dbPayment := DBPayment{
+		OrderID: orderID,
+		Amount:  payment.Amount,
+		Method:  payment.Method,
+		PaidAt:  payment.PaidAt,
+	}
+	if note := strings.TrimSpace(payment.Note); note != "" {
+		dbPayment.Note = &note
+	}
+	if err := d.repo.AddPayment(ctx, dbPayment, userID); err != nil {
+		slog.Error("Error adding payment", "error", err, "orderID", orderID)
+		return err
+	}
+	return nil
+}
+
+// ReplaceOrderItems saves the edited bill of materials, the order cost becomes
+// its total. Removing every item keeps the cost as it was
+func (d *DefaultService) ReplaceOrderItems(ctx context.Context, orderID int, items []OrderItem, userID int64) error {
+	if !validItems(items) {
+		return ErrInvalidOrderItem
+	}
+	if err := d.repo.ReplaceOrderItems(ctx, orderID, toDBOrderItems(items), userID); err != nil {
+		slog.Error("Error replacing order items", "error", err, "orderID", orderID)
+		return err
+	}
+	return nil
+}
+
+func validItems(items []OrderItem) bool {
+	for _, item := range items {
+		if item.Quantity <= 0 || item.UnitPrice < 0 || strings.TrimSpace(item.Material) == "" {
+			return false
+		}
+		if item.Infill != nil && (*item.Infill < 0 || *item.Infill > 100) {
+			return false
+		}
+	}
+	return true
+}
+
+func toDBOrderItems(items []OrderItem) []DBOrderItem {
+	dbItems := make([]DBOrderItem, len(items))
+	for i, item := range items {
+		dbItems[i] = DBOrderItem{
+			Position:    i,
+			Quantity:    item.Quantity,
+			Material:    item.Material,
+			Color:       item.Color,
+			LayerHeight: item.LayerHeight,
+			Infill:      item.Infill,
+			UnitPrice:   item.UnitPrice,
+		}
+		if item.FileName != "" {
+			dbItems[i].FileName = &item.FileName
+		}
+	}
+	return dbItems
+}
+
+func fromDBOrderItems(dbItems []DBOrderItem) []OrderItem {
+	items := make([]OrderItem, len(dbItems))
+	for i, item := range dbItems {
+		items[i] = OrderItem{
+			ID:          item.ID,
+			FileName:    derefOrEmpty(item.FileName),
+			Quantity:    item.Quantity,
+			Material:    item.Material,
+			Color:       item.Color,
+			LayerHeight: item.LayerHeight,
+			Infill:      item.Infill,
+			UnitPrice:   item.UnitPrice,
+		}
+	}
+	return items
+}
+
+// paymentTotals sums the payments up, leftovers below a kopeck don't count
+// as owed
+func paymentTotals(cost float32, payments []DBPayment) (paid, outstanding float32) {
+	for _, payment := range payments {
+		paid += payment.Amount
+	}
+	return paid, outstandingAmount(cost, paid)
+}
+
+// outstandingAmount is never below zero and ignores fractions of a kopeck
+func outstandingAmount(cost, paid float32) float32 {
+	outstanding := cost - paid
+	if outstanding < 0.01 {
+		return 0
+	}
+	return outstanding
+}
+
+// lastProductionStatus picks the most recent non-terminal status, so a restored
+// order returns to the stage it was closed or cancelled at
+func lastProductionStatus(timestamps map[Status]time.Time) Status {
+	result := StatusNew
+	var latest time.Time
+	for status, ts := range timestamps {
+		if status.IsTerminal() {
+			continue
+		}
+		if ts.After(latest) {
+			result = status
+			latest = ts
+		}
+	}
+	return result
+}
+
+func derefOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefOrZero[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
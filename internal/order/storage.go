@@ -2,8 +2,11 @@ package order
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"print3d-order-bot/pkg"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/squirrel"
@@ -12,36 +15,63 @@ import (
 )
 
 type Repo interface {
-	NewOrder(ctx context.Context, order DBNewOrder, files []DBFile) error
-	AddFilesToOrder(ctx context.Context, orderID int, files []DBFile) error
+	NewOrder(ctx context.Context, order DBNewOrder, files []DBFile, items []DBOrderItem, userID int64) (int, error)
+	AddFilesToOrder(ctx context.Context, orderID int, files []DBFile, userID int64) error
 	GetOrdersIDs(ctx context.Context, getActive bool) ([]int, error)
 	GetOrdersFolders(ctx context.Context, getActive bool) ([]string, error)
+	AddRestoredFolder(ctx context.Context, folder string) error
+	GetRestoredFolders(ctx context.Context) ([]string, error)
+	DeleteRestoredFolders(ctx context.Context, existing []string, listedAt, restoredBefore time.Time) error
+	ListOrders(ctx context.Context, filter OrderFilter) ([]int, error)
+	GetArchiveMonths(ctx context.Context) ([]DBArchiveMonth, error)
+	GetClosedOrdersIDs(ctx context.Context, month string) ([]int, error)
+	SearchOrders(ctx context.Context, terms []string, limit uint64) ([]int, error)
+	GetOrderSummaries(ctx context.Context, ids []int) ([]DBOrderSummary, error)
+	GetOrdersDueBefore(ctx context.Context, deadline time.Time) ([]DBOrderSummary, error)
+	GetOverdueOrders(ctx context.Context) ([]DBOrderSummary, error)
+	MarkDueReminded(ctx context.Context, orderID int) error
 	GetOrderByID(ctx context.Context, orderID int) (*DBNewOrder, error)
-	UpdateOrderStatus(ctx context.Context, orderID int, status Status) error
-	EditOrder(ctx context.Context, order DBEditOrder) error
+	GetOrderIDByFolder(ctx context.Context, folderPath string) (int, error)
+	UpdateOrderStatus(ctx context.Context, orderID int, status Status, userID int64, check StatusCheck) error
+	EditOrder(ctx context.Context, order DBEditOrder, userID int64) error
 	DeleteOrder(ctx context.Context, orderID int) error
 	GetOrderFiles(ctx context.Context, orderID int) ([]DBFile, error)
 	GetOrderFilenames(ctx context.Context, orderID int) ([]string, error)
-	DeleteOrderFiles(ctx context.Context, orderID int, filenames []string) error
-	UpdateOrderFiles(ctx context.Context, orderID int, files []DBFile) error
+	DeleteOrderFiles(ctx context.Context, orderID int, filenames []string, userID int64) error
+	UpdateOrderFiles(ctx context.Context, orderID int, files []DBFile, userID int64) error
+	GetOrderEvents(ctx context.Context, orderID int) ([]DBOrderEvent, error)
+	GetArchivableOrdersIDs(ctx context.Context) ([]int, error)
+	UpdateOrderArchivePath(ctx context.Context, orderID int, archivePath *string, userID int64) error
+	UpdateOrderUnarchivedAt(ctx context.Context, orderID int, unarchivedAt *time.Time) error
+	AddPayment(ctx context.Context, payment DBPayment, userID int64) error
+	GetOrderPayments(ctx context.Context, orderID int) ([]DBPayment, error)
+	GetOrderItems(ctx context.Context, orderID int) ([]DBOrderItem, error)
+	ReplaceOrderItems(ctx context.Context, orderID int, items []DBOrderItem, userID int64) error
+}
+
+// querier lets reads run both on the pool and inside a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 }
 
 type DefaultRepo struct {
-	pool    *pgxpool.Pool
-	builder squirrel.StatementBuilderType
+	pool              *pgxpool.Pool
+	builder           squirrel.StatementBuilderType
+	restorationPeriod time.Duration
 }
 
-func NewDefaultRepo(pool *pgxpool.Pool) Repo {
+func NewDefaultRepo(pool *pgxpool.Pool, restorationPeriod time.Duration) Repo {
 	return &DefaultRepo{
-		pool:    pool,
-		builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		pool:              pool,
+		builder:           squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		restorationPeriod: restorationPeriodOrDefault(restorationPeriod),
 	}
 }
 
-func (d *DefaultRepo) NewOrder(ctx context.Context, order DBNewOrder, files []DBFile) error {
+func (d *DefaultRepo) NewOrder(ctx context.Context, order DBNewOrder, files []DBFile, items []DBOrderItem, userID int64) (int, error) {
 	tx, err := d.pool.Begin(ctx)
 	if err != nil {
-		return &pkg.ErrDBProcedure{
+		return 0, &pkg.ErrDBProcedure{
 			Cause: "failed to begin transaction",
 			Info:  "NewOrder",
 			Err:   err,
@@ -50,46 +80,91 @@ func (d *DefaultRepo) NewOrder(ctx context.Context, order DBNewOrder, files []DB
 
 	orderID, err := d.insertOrder(ctx, order, tx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if files == nil || len(files) == 0 {
-		tx.Commit(ctx)
-		return nil
+	events := []DBOrderEvent{{OrderID: orderID, UserID: userID, Type: EventCreated}}
+	for _, file := range files {
+		events = append(events, fileEvent(orderID, userID, EventFileAdded, file.Name))
+	}
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return 0, err
 	}
 
-	builder := d.builder.Insert("order_files").
-		Columns("name", "checksum", "tg_file_id", "order_id")
-	for _, file := range files {
-		builder = builder.Values(file.Name, file.Checksum, file.TgFileID, orderID)
+	if len(files) > 0 {
+		builder := d.builder.Insert("order_files").
+			Columns("name", "checksum", "tg_file_id", "analysis", "gcode", "order_id")
+		for _, file := range files {
+			builder = builder.Values(file.Name, file.Checksum, file.TgFileID, file.Analysis, file.GCode, orderID)
+		}
+		query, args, err := builder.ToSql()
+		if err != nil {
+			tx.Rollback(ctx)
+			return 0, &pkg.ErrDBProcedure{
+				Cause: "failed to build query",
+				Info:  "NewOrder",
+				Err:   err,
+			}
+		}
+
+		if _, err := tx.Exec(ctx, query, args...); err != nil {
+			tx.Rollback(ctx)
+			return 0, &pkg.ErrDBProcedure{
+				Cause: "failed to insert file data",
+				Info:  fmt.Sprintf("NewOrder; query: %s", query),
+				Err:   err,
+			}
+		}
+	}
+
+	if err := d.insertItems(ctx, tx, orderID, items); err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	tx.Commit(ctx)
+	return orderID, nil
+}
+
+// insertItems links the items to the order files by name, items whose file
+// didn't make it into the order are kept without one
+func (d *DefaultRepo) insertItems(ctx context.Context, tx pgx.Tx, orderID int, items []DBOrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	builder := d.builder.Insert("order_items").
+		Columns("order_id", "file_id", "position", "quantity", "material", "color", "layer_height", "infill", "unit_price")
+	for i, item := range items {
+		var fileID any
+		if item.FileName != nil {
+			fileID = squirrel.Expr("(select id from order_files where order_id = ? and name = ?)", orderID, *item.FileName)
+		}
+		builder = builder.Values(orderID, fileID, i, item.Quantity, item.Material, item.Color, item.LayerHeight, item.Infill, item.UnitPrice)
 	}
 	query, args, err := builder.ToSql()
 	if err != nil {
-		tx.Rollback(ctx)
 		return &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "NewOrder",
+			Info:  "InsertItems",
 			Err:   err,
 		}
 	}
 
 	if _, err := tx.Exec(ctx, query, args...); err != nil {
-		tx.Rollback(ctx)
 		return &pkg.ErrDBProcedure{
-			Cause: "failed to insert file data",
-			Info:  fmt.Sprintf("NewOrder; query: %s", query),
+			Cause: "failed to insert order items",
+			Info:  fmt.Sprintf("InsertItems; query: %s", query),
 			Err:   err,
 		}
 	}
-
-	tx.Commit(ctx)
 	return nil
 }
 
 func (d *DefaultRepo) insertOrder(ctx context.Context, order DBNewOrder, tx pgx.Tx) (int, error) {
 	stmt := d.builder.Insert("orders").
-		Columns("status", "print_type", "client_name", "cost", "comments", "contacts", "links", "created_at", "folder_path").
-		Values(order.Status, order.PrintType, order.ClientName, order.Cost, order.Comments, order.Contacts, order.Links, order.CreatedAt, order.FolderPath).
+		Columns("status", "print_type", "client_id", "client_name", "cost", "comments", "contacts", "links", "created_at", "due_at", "responsible_id", "folder_path").
+		Values(order.Status, order.PrintType, order.ClientID, order.ClientName, order.Cost, order.Comments, order.Contacts, order.Links, order.CreatedAt, order.DueAt, order.ResponsibleID, order.FolderPath).
 		Suffix("returning id")
 	query, args, err := stmt.ToSql()
 	if err != nil {
@@ -114,14 +189,25 @@ func (d *DefaultRepo) insertOrder(ctx context.Context, order DBNewOrder, tx pgx.
 	return orderID, nil
 }
 
-func (d *DefaultRepo) AddFilesToOrder(ctx context.Context, orderID int, files []DBFile) error {
+func (d *DefaultRepo) AddFilesToOrder(ctx context.Context, orderID int, files []DBFile, userID int64) error {
 	if len(files) == 0 {
 		return nil
 	}
+	// The reconciler and the bot may both register the same file, the later
+	// call only fills in what the first one didn't know. The analysis and the
+	// g-code estimate of a changed file no longer apply. xmax is zero only for
+	// freshly inserted rows, so updates don't produce duplicate events
 	builder := d.builder.Insert("order_files").
-		Columns("name", "checksum", "tg_file_id", "order_id")
+		Columns("name", "checksum", "tg_file_id", "analysis", "gcode", "order_id").
+		Suffix(`on conflict (order_id, name) do update
+			set checksum = excluded.checksum, tg_file_id = coalesce(excluded.tg_file_id, order_files.tg_file_id),
+			analysis = case when excluded.checksum = order_files.checksum
+				then coalesce(excluded.analysis, order_files.analysis) else excluded.analysis end,
+			gcode = case when excluded.checksum = order_files.checksum
+				then coalesce(excluded.gcode, order_files.gcode) else excluded.gcode end
+			returning name, xmax = 0`)
 	for _, file := range files {
-		builder = builder.Values(file.Name, file.Checksum, file.TgFileID, orderID)
+		builder = builder.Values(file.Name, file.Checksum, file.TgFileID, file.Analysis, file.GCode, orderID)
 	}
 	query, args, err := builder.ToSql()
 	if err != nil {
@@ -132,25 +218,65 @@ func (d *DefaultRepo) AddFilesToOrder(ctx context.Context, orderID int, files []
 		}
 	}
 
-	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "NewOrderFiles",
+			Err:   err,
+		}
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("NewOrderFiles; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	var events []DBOrderEvent
+	for rows.Next() {
+		var name string
+		var inserted bool
+		if err := rows.Scan(&name, &inserted); err != nil {
+			rows.Close()
+			tx.Rollback(ctx)
+			return &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("NewOrderFiles; query: %s", query),
+				Err:   err,
+			}
+		}
+		if inserted {
+			events = append(events, fileEvent(orderID, userID, EventFileAdded, name))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback(ctx)
 		return &pkg.ErrDBProcedure{
 			Cause: "failed to execute query",
 			Info:  fmt.Sprintf("NewOrderFiles; query: %s", query),
 			Err:   err,
 		}
 	}
+
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
 	return nil
 }
 
 func (d *DefaultRepo) GetOrdersIDs(ctx context.Context, getActive bool) ([]int, error) {
 	stmt := d.builder.Select("id").From("orders").OrderBy("created_at")
 	if getActive {
-		stmt = stmt.Where(squirrel.Or{
-			squirrel.Eq{"status": StatusActive},
-			squirrel.And{
-				squirrel.NotEq{"closed_at": nil},
-				squirrel.Expr("closed_at >= NOW() - INTERVAL '1 day'"),
-			}})
+		stmt = stmt.Where(d.activeOrdersCond())
 	}
 	query, args, err := stmt.ToSql()
 	if err != nil {
@@ -191,12 +317,7 @@ func (d *DefaultRepo) GetOrdersIDs(ctx context.Context, getActive bool) ([]int,
 func (d *DefaultRepo) GetOrdersFolders(ctx context.Context, getActive bool) ([]string, error) {
 	stmt := d.builder.Select("folder_path").From("orders").OrderBy("created_at")
 	if getActive {
-		stmt = stmt.Where(squirrel.Or{
-			squirrel.Eq{"status": StatusActive},
-			squirrel.And{
-				squirrel.NotEq{"closed_at": nil},
-				squirrel.Expr("closed_at >= NOW() - INTERVAL '1 day'"),
-			}})
+		stmt = stmt.Where(d.activeOrdersCond())
 	}
 	query, args, err := stmt.ToSql()
 	if err != nil {
@@ -234,222 +355,1139 @@ func (d *DefaultRepo) GetOrdersFolders(ctx context.Context, getActive bool) ([]s
 	return paths, nil
 }
 
-func (d *DefaultRepo) GetOrderByID(ctx context.Context, orderID int) (*DBNewOrder, error) {
-	stmt := d.builder.Select("id", "status", "print_type", "client_name", "cost", "comments", "contacts", "links", "created_at", "closed_at", "folder_path").From("orders").Where(squirrel.Eq{"id": orderID})
+func (d *DefaultRepo) AddRestoredFolder(ctx context.Context, folder string) error {
+	stmt := d.builder.Insert("restored_folders").
+		Columns("folder").
+		Values(folder).
+		Suffix("on conflict (folder) do update set restored_at = now()")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "AddRestoredFolder",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to insert restored folder",
+			Info:  fmt.Sprintf("AddRestoredFolder; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) GetRestoredFolders(ctx context.Context) ([]string, error) {
+	stmt := d.builder.Select("folder").From("restored_folders")
 	query, args, err := stmt.ToSql()
 	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "GetOrderByID",
+			Info:  "GetRestoredFolders",
 			Err:   err,
 		}
 	}
 
-	var order DBNewOrder
-	if err := d.pool.QueryRow(ctx, query, args...).Scan(&order.ID, &order.Status, &order.PrintType, &order.ClientName, &order.Cost, &order.Comments, &order.Contacts, &order.Links, &order.CreatedAt, &order.ClosedAt, &order.FolderPath); err != nil {
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
-			Cause: "failed to select order",
-			Info:  fmt.Sprintf("GetOrderByID; query: %s", query),
+			Cause: "failed to select restored folders",
+			Info:  fmt.Sprintf("GetRestoredFolders; query: %s", query),
 			Err:   err,
 		}
 	}
+	defer rows.Close()
 
-	return &order, nil
+	var folders []string
+	for rows.Next() {
+		var folder string
+		if err := rows.Scan(&folder); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetRestoredFolders; query: %s", query),
+				Err:   err,
+			}
+		}
+		folders = append(folders, folder)
+	}
+
+	return folders, nil
 }
 
-func (d *DefaultRepo) UpdateOrderStatus(ctx context.Context, orderID int, status Status) error {
-	stmt := d.builder.Update("orders").Set("status", status)
-	switch status {
-	case StatusClosed:
-		stmt = stmt.Set("closed_at", time.Now())
-	case StatusActive:
-		stmt = stmt.Set("closed_at", nil)
+// DeleteRestoredFolders forgets the restored folders that were attached to an
+// order or were gone from the existing ones by listedAt, and those restored
+// before restoredBefore unless it is zero
+func (d *DefaultRepo) DeleteRestoredFolders(ctx context.Context, existing []string, listedAt, restoredBefore time.Time) error {
+	cond := squirrel.Or{
+		squirrel.Expr("folder in (select folder_path from orders where folder_path is not null)"),
+		squirrel.And{
+			squirrel.Expr("not (folder = any(?))", existing),
+			squirrel.Lt{"restored_at": listedAt},
+		},
 	}
-	stmt = stmt.Where(squirrel.Eq{"id": orderID})
+	if !restoredBefore.IsZero() {
+		cond = append(cond, squirrel.Lt{"restored_at": restoredBefore})
+	}
+	stmt := d.builder.Delete("restored_folders").Where(cond)
 	query, args, err := stmt.ToSql()
 	if err != nil {
 		return &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "UpdateOrderStatus",
+			Info:  "DeleteRestoredFolders",
 			Err:   err,
 		}
 	}
 
 	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
 		return &pkg.ErrDBProcedure{
-			Cause: "failed to execute query",
-			Info:  fmt.Sprintf("UpdateOrderStatus; query: %s", query),
+			Cause: "failed to delete restored folders",
+			Info:  fmt.Sprintf("DeleteRestoredFolders; query: %s", query),
 			Err:   err,
 		}
 	}
 	return nil
 }
 
-func (d *DefaultRepo) EditOrder(ctx context.Context, order DBEditOrder) error {
-	stmt := d.builder.Update("orders").Where(squirrel.Eq{"id": order.ID})
-	if order.PrintType != nil {
-		stmt = stmt.Set("print_type", *order.PrintType)
+func (d *DefaultRepo) ListOrders(ctx context.Context, filter OrderFilter) ([]int, error) {
+	cond := squirrel.And{}
+	if len(filter.Statuses) > 0 {
+		cond = append(cond, squirrel.Eq{"status": filter.Statuses})
+	} else {
+		cond = append(cond, d.activeOrdersCond())
 	}
-	if order.ClientName != nil {
-		stmt = stmt.Set("client_name", *order.ClientName)
+	if len(filter.PrintTypes) > 0 {
+		cond = append(cond, squirrel.Eq{"print_type": filter.PrintTypes})
 	}
-	if order.Cost != nil {
-		stmt = stmt.Set("cost", *order.Cost)
+	if filter.CreatedFrom != nil {
+		cond = append(cond, squirrel.GtOrEq{"created_at": *filter.CreatedFrom})
 	}
-	if order.Comments != nil && order.OverrideComments != nil {
-		if *order.OverrideComments == true {
-			stmt = stmt.Set("comments", order.Comments)
-		} else {
-			stmt = stmt.Set("comments", squirrel.Expr("array_cat(comments, ?)", order.Comments))
+	if filter.CreatedTo != nil {
+		cond = append(cond, squirrel.Lt{"created_at": *filter.CreatedTo})
+	}
+	if filter.CostMin != nil {
+		cond = append(cond, squirrel.GtOrEq{"cost": *filter.CostMin})
+	}
+	if filter.CostMax != nil {
+		cond = append(cond, squirrel.LtOrEq{"cost": *filter.CostMax})
+	}
+	if filter.ClientID != nil {
+		cond = append(cond, squirrel.Eq{"client_id": *filter.ClientID})
+	}
+	if filter.HasFiles != nil {
+		hasFiles := "exists (select 1 from order_files where order_files.order_id = orders.id)"
+		if !*filter.HasFiles {
+			hasFiles = "not " + hasFiles
 		}
+		cond = append(cond, squirrel.Expr(hasFiles))
 	}
+
+	stmt := d.builder.Select("id").
+		From("orders").
+		Where(cond).
+		OrderBy(sortOrderClause(filter.Sort), "id")
 	query, args, err := stmt.ToSql()
 	if err != nil {
-		return &pkg.ErrDBProcedure{
+		return nil, &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "EditOrder",
+			Info:  "ListOrders",
 			Err:   err,
 		}
 	}
-	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
-		return &pkg.ErrDBProcedure{
-			Cause: "failed to execute query",
-			Info:  "EditOrder",
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select orders",
+			Info:  fmt.Sprintf("ListOrders; query: %s", query),
 			Err:   err,
 		}
 	}
-	return nil
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("ListOrders; query: %s", query),
+				Err:   err,
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
 }
 
-func (d *DefaultRepo) DeleteOrder(ctx context.Context, orderID int) error {
-	stmt := d.builder.Delete("orders").Where(squirrel.Eq{"id": orderID})
-	query, args, err := stmt.ToSql()
+// GetArchiveMonths groups finished orders by the "YYYY-MM" month they were
+// closed or cancelled in, the latest month first
+func (d *DefaultRepo) GetArchiveMonths(ctx context.Context) ([]DBArchiveMonth, error) {
+	query, args, err := d.builder.Select(finishedMonthExpr+" as month", "count(*)").
+		From("orders").
+		Where(squirrel.Eq{"status": []Status{StatusClosed, StatusCancelled}}).
+		GroupBy("month").
+		OrderBy("month desc").
+		ToSql()
 	if err != nil {
-		return &pkg.ErrDBProcedure{
+		return nil, &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "DeleteOrder",
+			Info:  "GetArchiveMonths",
 			Err:   err,
 		}
 	}
-	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
-		return &pkg.ErrDBProcedure{
-			Cause: "failed to delete order",
-			Info:  fmt.Sprintf("DeleteOrder; orderID: %d", orderID),
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select archive months",
+			Info:  fmt.Sprintf("GetArchiveMonths; query: %s", query),
 			Err:   err,
 		}
 	}
-	return nil
+	defer rows.Close()
+
+	var months []DBArchiveMonth
+	for rows.Next() {
+		var month DBArchiveMonth
+		if err := rows.Scan(&month.Month, &month.Count); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetArchiveMonths; query: %s", query),
+				Err:   err,
+			}
+		}
+		months = append(months, month)
+	}
+
+	return months, nil
 }
 
-func (d *DefaultRepo) GetOrderFiles(ctx context.Context, orderID int) ([]DBFile, error) {
-	stmt := d.builder.Select("*").From("order_files").Where(squirrel.Eq{"order_id": orderID})
-	query, args, err := stmt.ToSql()
+func (d *DefaultRepo) GetClosedOrdersIDs(ctx context.Context, month string) ([]int, error) {
+	query, args, err := d.builder.Select("id").
+		From("orders").
+		Where(squirrel.And{
+			squirrel.Eq{"status": []Status{StatusClosed, StatusCancelled}},
+			squirrel.Expr(finishedMonthExpr+" = ?", month),
+		}).
+		OrderBy("coalesce(closed_at, cancelled_at) desc", "id").
+		ToSql()
 	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "GetOrderFiles",
+			Info:  "GetClosedOrdersIDs",
 			Err:   err,
 		}
 	}
+
 	rows, err := d.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
-			Cause: "failed to select order files",
-			Info:  fmt.Sprintf("GetOrderFiles; query: %s", query),
+			Cause: "failed to select orders",
+			Info:  fmt.Sprintf("GetClosedOrdersIDs; query: %s", query),
 			Err:   err,
 		}
 	}
 	defer rows.Close()
 
-	var orderFiles []DBFile
+	var ids []int
 	for rows.Next() {
-		var file DBFile
-		if err := rows.Scan(&file.Name, &file.Checksum, &file.TgFileID, &file.OrderID); err != nil {
+		var id int
+		if err := rows.Scan(&id); err != nil {
 			return nil, &pkg.ErrDBProcedure{
 				Cause: "failed to scan row",
-				Info:  fmt.Sprintf("GetOrderFiles; query: %s", query),
+				Info:  fmt.Sprintf("GetClosedOrdersIDs; query: %s", query),
 				Err:   err,
 			}
 		}
-		orderFiles = append(orderFiles, file)
+		ids = append(ids, id)
 	}
 
-	return orderFiles, nil
+	return ids, nil
 }
 
-func (d *DefaultRepo) GetOrderFilenames(ctx context.Context, orderID int) ([]string, error) {
-	stmt := d.builder.Select("name").From("order_files").Where(squirrel.Eq{"order_id": orderID})
+// SearchOrders returns the newest orders where every term is found either in
+// the order text or in one of its file names
+func (d *DefaultRepo) SearchOrders(ctx context.Context, terms []string, limit uint64) ([]int, error) {
+	cond := squirrel.And{}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		cond = append(cond, squirrel.Or{
+			squirrel.ILike{"search_text": pattern},
+			squirrel.Expr("exists (select 1 from order_files where order_files.order_id = orders.id and order_files.name ilike ?)", pattern),
+		})
+	}
+	stmt := d.builder.Select("id").
+		From("orders").
+		Where(cond).
+		OrderBy("created_at desc").
+		Limit(limit)
 	query, args, err := stmt.ToSql()
 	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "GetOrderFilenames",
+			Info:  "SearchOrders",
 			Err:   err,
 		}
 	}
+
 	rows, err := d.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, &pkg.ErrDBProcedure{
-			Cause: "failed to select order files",
-			Info:  fmt.Sprintf("GetOrderFilenames; query: %s", query),
+			Cause: "failed to select orders",
+			Info:  fmt.Sprintf("SearchOrders; query: %s", query),
 			Err:   err,
 		}
 	}
 	defer rows.Close()
 
-	var orderFilenames []string
+	var ids []int
 	for rows.Next() {
-		var filename string
-		if err := rows.Scan(&filename); err != nil {
+		var id int
+		if err := rows.Scan(&id); err != nil {
 			return nil, &pkg.ErrDBProcedure{
 				Cause: "failed to scan row",
-				Info:  fmt.Sprintf("GetOrderFilenames; query: %s", query),
+				Info:  fmt.Sprintf("SearchOrders; query: %s", query),
 				Err:   err,
 			}
 		}
-		orderFilenames = append(orderFilenames, filename)
+		ids = append(ids, id)
 	}
 
-	return orderFilenames, nil
+	return ids, nil
 }
 
-func (d *DefaultRepo) DeleteOrderFiles(ctx context.Context, orderID int, filenames []string) error {
-	if len(filenames) == 0 {
-		return nil
-	}
-	stmt := d.builder.Delete("order_files").
+func (d *DefaultRepo) GetOrderSummaries(ctx context.Context, ids []int) ([]DBOrderSummary, error) {
+	stmt := d.summariesSelect().Where(squirrel.Eq{"id": ids})
+	return d.selectSummaries(ctx, stmt, "GetOrderSummaries")
+}
+
+// GetOrdersDueBefore returns unfinished orders due between now and the deadline
+// that haven't been reminded about yet
+func (d *DefaultRepo) GetOrdersDueBefore(ctx context.Context, deadline time.Time) ([]DBOrderSummary, error) {
+	stmt := d.summariesSelect().
 		Where(squirrel.And{
-			squirrel.Eq{"order_id": orderID},
-			squirrel.Eq{"name": filenames},
-		})
-	query, args, err := stmt.ToSql()
+			squirrel.NotEq{"status": doneStatuses},
+			squirrel.Gt{"due_at": time.Now()},
+			squirrel.LtOrEq{"due_at": deadline},
+			squirrel.Eq{"due_reminded_at": nil},
+		}).
+		OrderBy("due_at")
+	return d.selectSummaries(ctx, stmt, "GetOrdersDueBefore")
+}
+
+func (d *DefaultRepo) GetOverdueOrders(ctx context.Context) ([]DBOrderSummary, error) {
+	stmt := d.summariesSelect().
+		Where(squirrel.And{
+			squirrel.NotEq{"status": doneStatuses},
+			squirrel.LtOrEq{"due_at": time.Now()},
+		}).
+		OrderBy("due_at")
+	return d.selectSummaries(ctx, stmt, "GetOverdueOrders")
+}
+
+func (d *DefaultRepo) MarkDueReminded(ctx context.Context, orderID int) error {
+	query, args, err := d.builder.Update("orders").
+		Set("due_reminded_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": orderID}).
+		ToSql()
 	if err != nil {
 		return &pkg.ErrDBProcedure{
 			Cause: "failed to build query",
-			Info:  "DeleteOrderFiles",
+			Info:  "MarkDueReminded",
 			Err:   err,
 		}
 	}
 
 	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
 		return &pkg.ErrDBProcedure{
-			Cause: "failed to execute query",
-			Info:  fmt.Sprintf("DeleteOrderFiles; query: %s", query),
+			Cause: "failed to update order",
+			Info:  fmt.Sprintf("MarkDueReminded; query: %s", query),
 			Err:   err,
 		}
 	}
 	return nil
 }
 
-func (d *DefaultRepo) UpdateOrderFiles(ctx context.Context, orderID int, files []DBFile) error {
-	if len(files) == 0 {
-		return nil
+// summariesSelect also sums the slicer print time of the order files, in
+// nanoseconds as the estimates store it
+func (d *DefaultRepo) summariesSelect() squirrel.SelectBuilder {
+	return d.builder.Select("id", "status", "print_type", "client_name", "created_at", "due_at", "responsible_id",
+		`coalesce((select sum((f.gcode->>'print_time')::bigint) from order_files f where f.order_id = orders.id), 0)::bigint as print_time`).
+		From("orders")
+}
+
+func (d *DefaultRepo) selectSummaries(ctx context.Context, stmt squirrel.SelectBuilder, info string) ([]DBOrderSummary, error) {
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  info,
+			Err:   err,
+		}
 	}
-	tx, err := d.pool.Begin(ctx)
+
+	rows, err := d.pool.Query(ctx, query, args...)
 	if err != nil {
-		return &pkg.ErrDBProcedure{
-			Cause: "failed to start transaction",
-			Info:  "UpdateOrderFiles",
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select orders",
+			Info:  fmt.Sprintf("%s; query: %s", info, query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var summaries []DBOrderSummary
+	for rows.Next() {
+		var summary DBOrderSummary
+		if err := rows.Scan(&summary.ID, &summary.Status, &summary.PrintType, &summary.ClientName, &summary.CreatedAt, &summary.DueAt, &summary.ResponsibleID, &summary.PrintTime); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("%s; query: %s", info, query),
+				Err:   err,
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+func (d *DefaultRepo) GetArchivableOrdersIDs(ctx context.Context) ([]int, error) {
+	stmt := d.builder.Select("id").From("orders").
+		Where(squirrel.And{
+			squirrel.Eq{"status": []Status{StatusClosed, StatusCancelled}},
+			squirrel.NotEq{"folder_path": nil},
+			squirrel.Lt{"coalesce(closed_at, cancelled_at)": d.restorationCutoff()},
+			// Files unpacked from a kept archive get the restoration period of
+			// their own before they are cleaned up again
+			squirrel.Or{
+				squirrel.Eq{"archive_path": nil},
+				squirrel.Lt{"unarchived_at": d.restorationCutoff()},
+			},
+		}).
+		OrderBy("created_at")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetArchivableOrdersIDs",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select orders",
+			Info:  fmt.Sprintf("GetArchivableOrdersIDs; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetArchivableOrdersIDs; query: %s", query),
+				Err:   err,
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (d *DefaultRepo) UpdateOrderArchivePath(ctx context.Context, orderID int, archivePath *string, userID int64) error {
+	stmt := d.builder.Update("orders").
+		Set("archive_path", archivePath).
+		Set("unarchived_at", nil).
+		Where(squirrel.Eq{"id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpdateOrderArchivePath",
+			Err:   err,
+		}
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "UpdateOrderArchivePath",
+			Err:   err,
+		}
+	}
+
+	var oldPath *string
+	if err := tx.QueryRow(ctx, "select archive_path from orders where id = $1 for update", orderID).Scan(&oldPath); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to select order archive path",
+			Info:  "UpdateOrderArchivePath",
+			Err:   err,
+		}
+	}
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("UpdateOrderArchivePath; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	event := DBOrderEvent{
+		OrderID:  orderID,
+		UserID:   userID,
+		Type:     EventArchived,
+		Field:    qptr("archive_path"),
+		OldValue: oldPath,
+		NewValue: archivePath,
+	}
+	if archivePath == nil {
+		event.Type = EventUnarchived
+	}
+	if err := d.insertEvents(ctx, tx, []DBOrderEvent{event}); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) UpdateOrderUnarchivedAt(ctx context.Context, orderID int, unarchivedAt *time.Time) error {
+	stmt := d.builder.Update("orders").
+		Set("unarchived_at", unarchivedAt).
+		Where(squirrel.Eq{"id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpdateOrderUnarchivedAt",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("UpdateOrderUnarchivedAt; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) AddPayment(ctx context.Context, payment DBPayment, userID int64) error {
+	stmt := d.builder.Insert("payments").
+		Columns("order_id", "amount", "method", "paid_at", "note", "user_id").
+		Values(payment.OrderID, payment.Amount, payment.Method, payment.PaidAt, payment.Note, userID)
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "AddPayment",
+			Err:   err,
+		}
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "AddPayment",
+			Err:   err,
+		}
+	}
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to insert payment",
+			Info:  fmt.Sprintf("AddPayment; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	// The method goes into the field so the history can tell how it was paid
+	event := DBOrderEvent{
+		OrderID:  payment.OrderID,
+		UserID:   userID,
+		Type:     EventPaymentAdded,
+		Field:    qptr(string(payment.Method)),
+		NewValue: qptr(formatCost(payment.Amount)),
+	}
+	if err := d.insertEvents(ctx, tx, []DBOrderEvent{event}); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) GetOrderPayments(ctx context.Context, orderID int) ([]DBPayment, error) {
+	stmt := d.builder.Select("id", "order_id", "amount", "method", "paid_at", "note", "user_id").
+		From("payments").
+		Where(squirrel.Eq{"order_id": orderID}).
+		OrderBy("paid_at", "id")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderPayments",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select payments",
+			Info:  fmt.Sprintf("GetOrderPayments; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var payments []DBPayment
+	for rows.Next() {
+		var payment DBPayment
+		if err := rows.Scan(&payment.ID, &payment.OrderID, &payment.Amount, &payment.Method, &payment.PaidAt, &payment.Note, &payment.UserID); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetOrderPayments; query: %s", query),
+				Err:   err,
+			}
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+func (d *DefaultRepo) GetOrderItems(ctx context.Context, orderID int) ([]DBOrderItem, error) {
+	return d.selectItems(ctx, d.pool, orderID)
+}
+
+func (d *DefaultRepo) selectItems(ctx context.Context, q querier, orderID int) ([]DBOrderItem, error) {
+	stmt := d.builder.Select("i.id", "i.order_id", "f.name", "i.position", "i.quantity", "i.material", "i.color", "i.layer_height", "i.infill", "i.unit_price").
+		From("order_items i").
+		LeftJoin("order_files f on f.id = i.file_id").
+		Where(squirrel.Eq{"i.order_id": orderID}).
+		OrderBy("i.position")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "SelectItems",
+			Err:   err,
+		}
+	}
+
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select order items",
+			Info:  fmt.Sprintf("SelectItems; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var items []DBOrderItem
+	for rows.Next() {
+		var item DBOrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.FileName, &item.Position, &item.Quantity, &item.Material, &item.Color, &item.LayerHeight, &item.Infill, &item.UnitPrice); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("SelectItems; query: %s", query),
+				Err:   err,
+			}
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// ReplaceOrderItems swaps the bill of materials of the order for the given
+// one and sets the order cost to its total unless it's empty
+func (d *DefaultRepo) ReplaceOrderItems(ctx context.Context, orderID int, items []DBOrderItem, userID int64) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "ReplaceOrderItems",
+			Err:   err,
+		}
+	}
+
+	var oldCost float32
+	if err := tx.QueryRow(ctx, "select cost from orders where id = $1 for update", orderID).Scan(&oldCost); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to select order cost",
+			Info:  "ReplaceOrderItems",
+			Err:   err,
+		}
+	}
+
+	oldItems, err := d.selectItems(ctx, tx, orderID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "delete from order_items where order_id = $1", orderID); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to delete order items",
+			Info:  "ReplaceOrderItems",
+			Err:   err,
+		}
+	}
+
+	if err := d.insertItems(ctx, tx, orderID, items); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	// Without items the cost goes back to being entered by hand, so it's kept
+	cost := oldCost
+	if len(items) > 0 {
+		cost = ItemsTotal(fromDBOrderItems(items))
+	}
+	if _, err := tx.Exec(ctx, "update orders set cost = $1 where id = $2", cost, orderID); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to update order cost",
+			Info:  "ReplaceOrderItems",
+			Err:   err,
+		}
+	}
+
+	events := []DBOrderEvent{editEvent(orderID, userID, "items", itemsSummary(oldItems), itemsSummary(items))}
+	if cost != oldCost {
+		events = append(events, editEvent(orderID, userID, "cost", formatCost(oldCost), formatCost(cost)))
+	}
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) GetOrderByID(ctx context.Context, orderID int) (*DBNewOrder, error) {
+	stmt := d.builder.Select("id", "status", "print_type", "client_id", "client_name", "cost", "comments", "contacts", "links", "created_at", "due_at", "responsible_id", "folder_path", "archive_path", "unarchived_at").
+		Columns(statusTimestampColumns...).
+		From("orders").
+		Where(squirrel.Eq{"id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderByID",
+			Err:   err,
+		}
+	}
+
+	var order DBNewOrder
+	if err := d.pool.QueryRow(ctx, query, args...).Scan(
+		&order.ID, &order.Status, &order.PrintType, &order.ClientID, &order.ClientName, &order.Cost, &order.Comments, &order.Contacts, &order.Links, &order.CreatedAt, &order.DueAt, &order.ResponsibleID, &order.FolderPath, &order.ArchivePath, &order.UnarchivedAt,
+		&order.QuotedAt, &order.AwaitingPrepaymentAt, &order.QueuedAt, &order.PrintingAt, &order.PostProcessingAt, &order.ReadyAt, &order.DeliveredAt, &order.ClosedAt, &order.CancelledAt,
+	); err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select order",
+			Info:  fmt.Sprintf("GetOrderByID; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	return &order, nil
+}
+
+func (d *DefaultRepo) GetOrderIDByFolder(ctx context.Context, folderPath string) (int, error) {
+	stmt := d.builder.Select("id").
+		From("orders").
+		Where(squirrel.Eq{"folder_path": folderPath})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return 0, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderIDByFolder",
+			Err:   err,
+		}
+	}
+
+	var orderID int
+	if err := d.pool.QueryRow(ctx, query, args...).Scan(&orderID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrOrderNotFound
+		}
+		return 0, &pkg.ErrDBProcedure{
+			Cause: "failed to select order",
+			Info:  fmt.Sprintf("GetOrderIDByFolder; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	return orderID, nil
+}
+
+// StatusCheck runs while the order row is locked, its error aborts the status
+// change. paid is the sum of the order payments
+type StatusCheck func(current Status, cost, paid float32) error
+
+func (d *DefaultRepo) UpdateOrderStatus(ctx context.Context, orderID int, status Status, userID int64, check StatusCheck) error {
+	stmt := d.builder.Update("orders").Set("status", status)
+	if column, ok := statusTimestampColumn(status); ok {
+		stmt = stmt.Set(column, time.Now())
+	}
+	if !status.IsTerminal() {
+		stmt = stmt.Set("closed_at", nil).Set("cancelled_at", nil)
+	}
+	stmt = stmt.Where(squirrel.Eq{"id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpdateOrderStatus",
+			Err:   err,
+		}
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "UpdateOrderStatus",
+			Err:   err,
+		}
+	}
+
+	var oldStatus Status
+	var cost, paid float32
+	if err := tx.QueryRow(ctx, `select status, cost, coalesce((select sum(amount) from payments where order_id = orders.id), 0)
+		from orders where id = $1 for update`, orderID).Scan(&oldStatus, &cost, &paid); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to select order status",
+			Info:  "UpdateOrderStatus",
+			Err:   err,
+		}
+	}
+	if check != nil {
+		if err := check(oldStatus, cost, paid); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("UpdateOrderStatus; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	event := DBOrderEvent{
+		OrderID:  orderID,
+		UserID:   userID,
+		Type:     EventStatusChanged,
+		Field:    qptr("status"),
+		OldValue: qptr(string(oldStatus)),
+		NewValue: qptr(string(status)),
+	}
+	if err := d.insertEvents(ctx, tx, []DBOrderEvent{event}); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) EditOrder(ctx context.Context, order DBEditOrder, userID int64) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "EditOrder",
+			Err:   err,
+		}
+	}
+
+	var old DBEditOrder
+	if err := tx.QueryRow(ctx, "select print_type, client_name, cost, comments from orders where id = $1 for update", order.ID).
+		Scan(&old.PrintType, &old.ClientName, &old.Cost, &old.Comments); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to select order",
+			Info:  "EditOrder",
+			Err:   err,
+		}
+	}
+
+	var events []DBOrderEvent
+	stmt := d.builder.Update("orders").Where(squirrel.Eq{"id": order.ID})
+	if order.PrintType != nil {
+		stmt = stmt.Set("print_type", *order.PrintType)
+		events = append(events, editEvent(order.ID, userID, "print_type", *old.PrintType, *order.PrintType))
+	}
+	if order.ClientName != nil {
+		stmt = stmt.Set("client_name", *order.ClientName)
+		events = append(events, editEvent(order.ID, userID, "client_name", *old.ClientName, *order.ClientName))
+	}
+	if order.Cost != nil {
+		// The cost of an order with items is their total, set by ReplaceOrderItems
+		var hasItems bool
+		if err := tx.QueryRow(ctx, "select exists(select 1 from order_items where order_id = $1)", order.ID).Scan(&hasItems); err != nil {
+			tx.Rollback(ctx)
+			return &pkg.ErrDBProcedure{
+				Cause: "failed to check order items",
+				Info:  "EditOrder",
+				Err:   err,
+			}
+		}
+		if hasItems {
+			tx.Rollback(ctx)
+			return ErrCostFromItems
+		}
+		stmt = stmt.Set("cost", *order.Cost)
+		events = append(events, editEvent(order.ID, userID, "cost", formatCost(*old.Cost), formatCost(*order.Cost)))
+	}
+	if order.Comments != nil && order.OverrideComments != nil {
+		newComments := order.Comments
+		if *order.OverrideComments == true {
+			stmt = stmt.Set("comments", order.Comments)
+		} else {
+			stmt = stmt.Set("comments", squirrel.Expr("array_cat(comments, ?)", order.Comments))
+			newComments = append(old.Comments, order.Comments...)
+		}
+		events = append(events, editEvent(order.ID, userID, "comments", strings.Join(old.Comments, "\n"), strings.Join(newComments, "\n")))
+	}
+	if len(events) == 0 {
+		tx.Rollback(ctx)
+		return nil
+	}
+
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "EditOrder",
+			Err:   err,
+		}
+	}
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  "EditOrder",
+			Err:   err,
+		}
+	}
+
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) DeleteOrder(ctx context.Context, orderID int) error {
+	stmt := d.builder.Delete("orders").Where(squirrel.Eq{"id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "DeleteOrder",
+			Err:   err,
+		}
+	}
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to delete order",
+			Info:  fmt.Sprintf("DeleteOrder; orderID: %d", orderID),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) GetOrderFiles(ctx context.Context, orderID int) ([]DBFile, error) {
+	stmt := d.builder.Select("name", "checksum", "tg_file_id", "analysis", "gcode", "order_id").From("order_files").Where(squirrel.Eq{"order_id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderFiles",
+			Err:   err,
+		}
+	}
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select order files",
+			Info:  fmt.Sprintf("GetOrderFiles; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var orderFiles []DBFile
+	for rows.Next() {
+		var file DBFile
+		if err := rows.Scan(&file.Name, &file.Checksum, &file.TgFileID, &file.Analysis, &file.GCode, &file.OrderID); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetOrderFiles; query: %s", query),
+				Err:   err,
+			}
+		}
+		orderFiles = append(orderFiles, file)
+	}
+
+	return orderFiles, nil
+}
+
+func (d *DefaultRepo) GetOrderFilenames(ctx context.Context, orderID int) ([]string, error) {
+	stmt := d.builder.Select("name").From("order_files").Where(squirrel.Eq{"order_id": orderID})
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderFilenames",
+			Err:   err,
+		}
+	}
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select order files",
+			Info:  fmt.Sprintf("GetOrderFilenames; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var orderFilenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetOrderFilenames; query: %s", query),
+				Err:   err,
+			}
+		}
+		orderFilenames = append(orderFilenames, filename)
+	}
+
+	return orderFilenames, nil
+}
+
+func (d *DefaultRepo) DeleteOrderFiles(ctx context.Context, orderID int, filenames []string, userID int64) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+	// Only the rows actually removed get an event, a concurrent sweep may
+	// have deleted some of the names already
+	stmt := d.builder.Delete("order_files").
+		Where(squirrel.And{
+			squirrel.Eq{"order_id": orderID},
+			squirrel.Eq{"name": filenames},
+		}).
+		Suffix("returning name")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "DeleteOrderFiles",
+			Err:   err,
+		}
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to begin transaction",
+			Info:  "DeleteOrderFiles",
+			Err:   err,
+		}
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("DeleteOrderFiles; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	var events []DBOrderEvent
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			tx.Rollback(ctx)
+			return &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("DeleteOrderFiles; query: %s", query),
+				Err:   err,
+			}
+		}
+		events = append(events, fileEvent(orderID, userID, EventFileRemoved, name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback(ctx)
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to execute query",
+			Info:  fmt.Sprintf("DeleteOrderFiles; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	tx.Commit(ctx)
+	return nil
+}
+
+func (d *DefaultRepo) UpdateOrderFiles(ctx context.Context, orderID int, files []DBFile, userID int64) error {
+	if len(files) == 0 {
+		return nil
+	}
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to start transaction",
+			Info:  "UpdateOrderFiles",
 			Err:   err,
 		}
 	}
@@ -462,7 +1500,9 @@ func (d *DefaultRepo) UpdateOrderFiles(ctx context.Context, orderID int, files [
 			}).
 			Set("name", file.Name).
 			Set("checksum", file.Checksum).
-			Set("tg_file_id", file.TgFileID)
+			Set("tg_file_id", file.TgFileID).
+			Set("analysis", file.Analysis).
+			Set("gcode", file.GCode)
 		query, args, err := stmt.ToSql()
 		if err != nil {
 			tx.Rollback(ctx)
@@ -483,6 +1523,189 @@ func (d *DefaultRepo) UpdateOrderFiles(ctx context.Context, orderID int, files [
 		}
 	}
 
+	events := make([]DBOrderEvent, len(files))
+	for i, file := range files {
+		events[i] = fileEvent(orderID, userID, EventFileUpdated, file.Name)
+	}
+	if err := d.insertEvents(ctx, tx, events); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
 	tx.Commit(ctx)
 	return nil
 }
+
+func (d *DefaultRepo) GetOrderEvents(ctx context.Context, orderID int) ([]DBOrderEvent, error) {
+	stmt := d.builder.Select("id", "order_id", "user_id", "type", "field", "old_value", "new_value", "created_at").
+		From("order_events").
+		Where(squirrel.Eq{"order_id": orderID}).
+		OrderBy("created_at", "id")
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetOrderEvents",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select order events",
+			Info:  fmt.Sprintf("GetOrderEvents; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var events []DBOrderEvent
+	for rows.Next() {
+		var event DBOrderEvent
+		if err := rows.Scan(&event.ID, &event.OrderID, &event.UserID, &event.Type, &event.Field, &event.OldValue, &event.NewValue, &event.CreatedAt); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetOrderEvents; query: %s", query),
+				Err:   err,
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (d *DefaultRepo) insertEvents(ctx context.Context, tx pgx.Tx, events []DBOrderEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	builder := d.builder.Insert("order_events").
+		Columns("order_id", "user_id", "type", "field", "old_value", "new_value")
+	for _, event := range events {
+		builder = builder.Values(event.OrderID, event.UserID, event.Type, event.Field, event.OldValue, event.NewValue)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "InsertEvents",
+			Err:   err,
+		}
+	}
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to insert order events",
+			Info:  fmt.Sprintf("InsertEvents; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+var statusTimestampColumns = []string{
+	"quoted_at",
+	"awaiting_prepayment_at",
+	"queued_at",
+	"printing_at",
+	"post_processing_at",
+	"ready_at",
+	"delivered_at",
+	"closed_at",
+	"cancelled_at",
+}
+
+func statusTimestampColumn(status Status) (string, bool) {
+	if status == StatusNew || !status.IsValid() {
+		return "", false
+	}
+	return string(status) + "_at", true
+}
+
+func sortOrderClause(sort SortOrder) string {
+	switch sort {
+	case SortCreatedDesc:
+		return "created_at desc"
+	case SortCostDesc:
+		return "cost desc"
+	case SortCostAsc:
+		return "cost"
+	case SortDueAsc:
+		return "due_at nulls last"
+	default:
+		return "created_at"
+	}
+}
+
+// doneStatuses have no deadline to meet anymore, see Status.IsDone
+var doneStatuses = []Status{StatusReady, StatusDelivered, StatusClosed, StatusCancelled}
+
+// finishedMonthExpr is formatted in the database so grouping and lookups by
+// month agree regardless of the bot's time zone
+const finishedMonthExpr = "to_char(coalesce(closed_at, cancelled_at), 'YYYY-MM')"
+
+// activeOrdersCond matches unfinished orders and those finished within the
+// restoration period
+func (d *DefaultRepo) activeOrdersCond() squirrel.Sqlizer {
+	cutoff := d.restorationCutoff()
+	return squirrel.Or{
+		squirrel.NotEq{"status": []Status{StatusClosed, StatusCancelled}},
+		squirrel.GtOrEq{"closed_at": cutoff},
+		squirrel.GtOrEq{"cancelled_at": cutoff},
+	}
+}
+
+func (d *DefaultRepo) restorationCutoff() time.Time {
+	return time.Now().Add(-d.restorationPeriod)
+}
+
+func fileEvent(orderID int, userID int64, eventType EventType, filename string) DBOrderEvent {
+	event := DBOrderEvent{
+		OrderID: orderID,
+		UserID:  userID,
+		Type:    eventType,
+		Field:   qptr("file"),
+	}
+	if eventType == EventFileRemoved {
+		event.OldValue = qptr(filename)
+	} else {
+		event.NewValue = qptr(filename)
+	}
+	return event
+}
+
+func editEvent(orderID int, userID int64, field, oldValue, newValue string) DBOrderEvent {
+	return DBOrderEvent{
+		OrderID:  orderID,
+		UserID:   userID,
+		Type:     EventEdited,
+		Field:    qptr(field),
+		OldValue: qptr(oldValue),
+		NewValue: qptr(newValue),
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// itemsSummary lists the items without prices, so it can be shown to those
+// who can't see costs
+func itemsSummary(items []DBOrderItem) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = strings.TrimSpace(fmt.Sprintf("%d× %s %s", item.Quantity, item.Material, item.Color))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func formatCost(cost float32) string {
+	return strconv.FormatFloat(float64(cost), 'f', -1, 32)
+}
+
+func qptr[T any](o T) *T {
+	return &o
+}
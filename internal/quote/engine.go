@@ -0,0 +1,151 @@
+package quote
+
+import (
+	"math"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/pkg/config"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMachineRate      = 120
+	defaultLabourRate       = 600
+	defaultSetupTime        = 20 * time.Minute
+	defaultPostProcessTime  = 10 * time.Minute
+	defaultShellThickness   = 1.2
+	defaultInfill           = 20
+	defaultDensity          = 1.24
+	defaultFlowRate         = 15
+	defaultFilamentDiameter = 1.75
+	defaultSupportFactor    = 0.2
+	defaultLayerHeight      = 0.05
+	defaultLayerTime        = 8 * time.Second
+
+	// priceStep is what totals are rounded up to
+	priceStep = 10
+)
+
+type Engine interface {
+	Quote(req Request) (*Quote, error)
+}
+
+type DefaultEngine struct {
+	cfg *config.QuoteCfg
+}
+
+func NewDefaultEngine(cfg *config.QuoteCfg) Engine {
+	return &DefaultEngine{
+		cfg: cfg,
+	}
+}
+
+func (d *DefaultEngine) Quote(req Request) (*Quote, error) {
+	q := &Quote{Unit: req.Unit}
+	var parts []Part
+	for _, part := range req.Parts {
+		// Sliced files only describe extruded prints
+		if req.Unit == catalog.UnitMilliliter {
+			part.GCode = nil
+		}
+		if (part.Analysis == nil && part.GCode == nil) || part.Quantity <= 0 {
+			q.Skipped = append(q.Skipped, part.Name)
+			continue
+		}
+		if part.GCode == nil && !part.Analysis.Manifold {
+			q.Approximate = true
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, ErrNothingToQuote
+	}
+
+	if req.Unit == catalog.UnitMilliliter {
+		d.quoteResin(q, parts)
+	} else {
+		d.quoteFilament(q, parts, req.Material.Name)
+	}
+	q.MaterialCost = q.MaterialAmount * float64(req.Material.Price)
+	q.MachineCost = q.MachineTime.Hours() * orDefault(d.cfg.MachineRate, defaultMachineRate)
+
+	count := 0
+	for _, part := range parts {
+		count += part.Quantity
+	}
+	q.LabourTime = durationOrDefault(d.cfg.SetupTime, defaultSetupTime) +
+		time.Duration(count)*durationOrDefault(d.cfg.PostProcessTime, defaultPostProcessTime)
+	q.LabourCost = q.LabourTime.Hours() * orDefault(d.cfg.LabourRate, defaultLabourRate)
+
+	total := (q.MaterialCost + q.MachineCost + q.LabourCost) * (1 + d.cfg.Markup)
+	total = math.Max(total, d.cfg.MinPrice)
+	q.Total = float32(math.Ceil(total/priceStep) * priceStep)
+	return q, nil
+}
+
+// quoteFilament takes sliced parts at the slicer's word. For models it counts
+// the shell as solid and the interior at the infill percentage, parts are
+// printed one after another
+func (d *DefaultEngine) quoteFilament(q *Quote, parts []Part, material string) {
+	cfg := d.cfg.FDM
+	shellThickness := orDefault(cfg.ShellThickness, defaultShellThickness)
+	infill := orDefault(cfg.Infill, defaultInfill) / 100
+	density := orDefault(cfg.Density, defaultDensity)
+	for name, value := range cfg.Densities {
+		if strings.EqualFold(name, material) && value > 0 {
+			density = value
+		}
+	}
+
+	radius := orDefault(cfg.FilamentDiameter, defaultFilamentDiameter) / 2
+
+	var used float64
+	for _, part := range parts {
+		quantity := float64(part.Quantity)
+		if part.GCode != nil {
+			weight := part.GCode.FilamentWeight
+			if weight <= 0 {
+				weight = part.GCode.FilamentLength * math.Pi * radius * radius / 1000 * density
+			}
+			q.MaterialAmount += weight * quantity
+			q.MachineTime += time.Duration(float64(part.GCode.PrintTime) * quantity)
+			continue
+		}
+		volume := part.Analysis.Volume / 1000
+		shell := math.Min(part.Analysis.Area*shellThickness/1000, volume)
+		used += (shell + (volume-shell)*infill) * quantity
+	}
+	q.MaterialAmount += used * density
+	q.MachineTime += time.Duration(used / orDefault(cfg.FlowRate, defaultFlowRate) * float64(time.Hour))
+}
+
+// quoteResin prints every part on one plate, so the time only depends on the
+// tallest part
+func (d *DefaultEngine) quoteResin(q *Quote, parts []Part) {
+	cfg := d.cfg.SLA
+	supportFactor := orDefault(cfg.SupportFactor, defaultSupportFactor)
+
+	var volume, height float64
+	for _, part := range parts {
+		volume += part.Analysis.Volume / 1000 * float64(part.Quantity)
+		height = math.Max(height, part.Analysis.Size().Z)
+	}
+	q.MaterialAmount = volume * (1 + supportFactor)
+
+	layers := math.Ceil(height / orDefault(cfg.LayerHeight, defaultLayerHeight))
+	q.MachineTime = time.Duration(layers) * durationOrDefault(cfg.LayerTime, defaultLayerTime)
+}
+
+func orDefault(value, fallback float64) float64 {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func durationOrDefault(value, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,174 @@
+package quote
+
+import (
+	"errors"
+	"math"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
+	"print3d-order-bot/pkg/config"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cube is the analysis of a closed cube with the given side in mm
+func cube(side float64) *model.Analysis {
+	return &model.Analysis{
+		Triangles: 12,
+		Max:       model.Vec3{X: side, Y: side, Z: side},
+		Volume:    side * side * side,
+		Area:      6 * side * side,
+		Manifold:  true,
+	}
+}
+
+func TestQuote(t *testing.T) {
+	cfg := config.QuoteCfg{
+		MachineRate:     100,
+		LabourRate:      600,
+		SetupTime:       30 * time.Minute,
+		PostProcessTime: 6 * time.Minute,
+		FDM: config.FDMQuoteCfg{
+			ShellThickness: 1,
+			Infill:         20,
+			Density:        1.25,
+			Densities:      map[string]float64{"PETG": 1.27},
+			FlowRate:       10,
+		},
+		SLA: config.SLAQuoteCfg{
+			SupportFactor: 0.2,
+			LayerHeight:   0.125,
+			LayerTime:     10 * time.Second,
+		},
+	}
+	pla := catalog.Material{Technology: "FDM", Name: "PLA", Price: 2}
+	petg := catalog.Material{Technology: "FDM", Name: "PETG", Price: 3}
+	resin := catalog.Material{Technology: "SLA", Name: "Standard", Price: 5}
+	open := cube(20)
+	open.Manifold = false
+
+	tests := []struct {
+		name        string
+		cfg         func(*config.QuoteCfg)
+		req         Request
+		amount      float64
+		hours       float64
+		total       float32
+		skipped     []string
+		approximate bool
+		wantErr     error
+	}{
+		{
+			// 8 cm³ with a 2.4 cm³ shell and 20% of the rest is 3.52 cm³ a part,
+			// 42 minutes of labour
+			name:   "fdm models",
+			req:    Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{{Name: "cube.stl", Analysis: cube(20), Quantity: 2}}},
+			amount: 7.04 * 1.25,
+			hours:  0.704,
+			total:  510,
+		},
+		{
+			name:   "fdm density per material",
+			req:    Request{Unit: catalog.UnitGram, Material: petg, Parts: []Part{{Name: "cube.stl", Analysis: cube(20), Quantity: 1}}},
+			amount: 3.52 * 1.27,
+			hours:  0.352,
+			total:  410,
+		},
+		{
+			// The slicer weight and time are taken as they are, 36 minutes of labour
+			name: "fdm sliced",
+			req: Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{
+				{Name: "cube.gcode", GCode: &gcode.Estimate{PrintTime: 2 * time.Hour, FilamentWeight: 10.5}, Quantity: 1},
+			}},
+			amount: 10.5,
+			hours:  2,
+			total:  590,
+		},
+		{
+			// 1 m of 1.75 mm filament is 2.405 cm³
+			name: "fdm sliced without weight",
+			req: Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{
+				{Name: "cube.gcode", GCode: &gcode.Estimate{PrintTime: time.Hour, FilamentLength: 1000}, Quantity: 1},
+			}},
+			amount: math.Pi * 0.875 * 0.875 * 1.25,
+			hours:  1,
+			total:  470,
+		},
+		{
+			name: "fdm with an open mesh and a part without geometry",
+			req: Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{
+				{Name: "open.stl", Analysis: open, Quantity: 1},
+				{Name: "notes.txt", Quantity: 1},
+			}},
+			amount:      3.52 * 1.25,
+			hours:       0.352,
+			total:       410,
+			skipped:     []string{"notes.txt"},
+			approximate: true,
+		},
+		{
+			// 11 cm³ and 20% of supports, 160 layers of the tallest part and
+			// 54 minutes of labour
+			name: "sla",
+			req: Request{Unit: catalog.UnitMilliliter, Material: resin, Parts: []Part{
+				{Name: "big.stl", Analysis: cube(20), Quantity: 1},
+				{Name: "small.stl", Analysis: cube(10), Quantity: 3},
+			}},
+			amount: 13.2,
+			hours:  1600.0 / 3600,
+			total:  660,
+		},
+		{
+			name: "sla ignores sliced files",
+			req: Request{Unit: catalog.UnitMilliliter, Material: resin, Parts: []Part{
+				{Name: "big.gcode", GCode: &gcode.Estimate{PrintTime: time.Hour, FilamentWeight: 10}, Quantity: 1},
+			}},
+			wantErr: ErrNothingToQuote,
+		},
+		{
+			name:   "markup and minimum price",
+			cfg:    func(cfg *config.QuoteCfg) { cfg.Markup, cfg.MinPrice = 0.5, 1000 },
+			req:    Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{{Name: "cube.stl", Analysis: cube(20), Quantity: 1}}},
+			amount: 3.52 * 1.25,
+			hours:  0.352,
+			total:  1000,
+		},
+		{
+			name:    "nothing to quote",
+			req:     Request{Unit: catalog.UnitGram, Material: pla, Parts: []Part{{Name: "cube.stl", Analysis: cube(20), Quantity: 0}}},
+			wantErr: ErrNothingToQuote,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			q, err := NewDefaultEngine(&cfg).Quote(tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Quote() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Quote() error = %v", err)
+			}
+			if math.Abs(q.MaterialAmount-tt.amount) > 1e-6 {
+				t.Errorf("Quote() material = %g, want %g", q.MaterialAmount, tt.amount)
+			}
+			if math.Abs(q.MachineTime.Hours()-tt.hours) > 1e-6 {
+				t.Errorf("Quote() machine time = %v, want %gh", q.MachineTime, tt.hours)
+			}
+			if q.Total != tt.total {
+				t.Errorf("Quote() total = %g, want %g", q.Total, tt.total)
+			}
+			if !slices.Equal(q.Skipped, tt.skipped) || q.Approximate != tt.approximate {
+				t.Errorf("Quote() skipped %v, approximate %t, want %v, %t", q.Skipped, q.Approximate, tt.skipped, tt.approximate)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package quote
+
+import "errors"
+
+// ErrNothingToQuote means none of the parts has a usable geometry
+var ErrNothingToQuote = errors.New("nothing to quote")
@@ -0,0 +1,44 @@
+package quote
+
+import (
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
+	"time"
+)
+
+type Part struct {
+	Name string
+	// Analysis is nil for files that couldn't be analyzed
+	Analysis *model.Analysis
+	// GCode is the slicer estimate of a sliced file, it is preferred over the
+	// geometry for filament. Parts with neither are left out
+	GCode    *gcode.Estimate
+	Quantity int
+}
+
+// Request prices the parts in one material. Materials priced per gram are
+// extruded, the ones priced per millilitre are cured resin
+type Request struct {
+	Unit     catalog.Unit
+	Material catalog.Material
+	Parts    []Part
+}
+
+type Quote struct {
+	Unit catalog.Unit
+	// MaterialAmount is in grams or millilitres depending on Unit
+	MaterialAmount float64
+	MaterialCost   float64
+	MachineTime    time.Duration
+	MachineCost    float64
+	LabourTime     time.Duration
+	LabourCost     float64
+	// Total includes the markup, is at least the minimum price and is
+	// rounded up to whole tens
+	Total float32
+	// Skipped lists the parts left out for lack of geometry or an estimate
+	Skipped []string
+	// Approximate is set when some mesh isn't closed, its volume is a guess
+	Approximate bool
+}
@@ -2,12 +2,13 @@ package reconciler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
-	"os"
-	"path/filepath"
 	fileSvc "print3d-order-bot/internal/file"
 	orderSvc "print3d-order-bot/internal/order"
 	"print3d-order-bot/pkg/config"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,16 +17,21 @@ type Service interface {
 	Start(ctx context.Context)
 	Stop(ctx context.Context) error
 	ReconcileOrder(ctx context.Context, orderID int)
+	ReconcileFolder(ctx context.Context, folder string)
+	RestoreOrderFiles(ctx context.Context, orderID int, userID int64) error
+	UnpackOrderFiles(ctx context.Context, orderID int) error
 }
 
+const defaultInterval = time.Hour
+
 type DefaultService struct {
 	orderService orderSvc.Service
 	fileService  fileSvc.Service
-	cfg          *config.FileServiceCfg
+	cfg          *config.ReconcilerCfg
 	wg           *sync.WaitGroup
 }
 
-func NewDefaultService(orderService orderSvc.Service, fileService fileSvc.Service, cfg *config.FileServiceCfg) Service {
+func NewDefaultService(orderService orderSvc.Service, fileService fileSvc.Service, cfg *config.ReconcilerCfg) Service {
 	return &DefaultService{
 		orderService: orderService,
 		fileService:  fileService,
@@ -55,7 +61,11 @@ func (d *DefaultService) Stop(ctx context.Context) error {
 }
 
 func (d *DefaultService) startReconciliationLoop(ctx context.Context) {
-	ticker := time.Tick(time.Hour)
+	interval := d.cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.Tick(interval)
 
 	d.wg.Add(1)
 	go func() {
@@ -90,33 +100,217 @@ func (d *DefaultService) runGlobalReconciliation(ctx context.Context) {
 	}
 	wg.Wait()
 
-	validFolders, err := d.orderService.GetActiveOrdersFolders(ctx)
+	d.archiveExpiredOrders(ctx)
+
+	listedAt := time.Now()
+	folders, err := d.fileService.ListFolders()
 	if err != nil {
 		slog.Error(err.Error())
+	} else if !d.cfg.DryRun {
+		// Failing to prune only keeps a few more folders out of the sweep
+		_ = d.orderService.PruneRestoredFolders(ctx, folders, listedAt, d.cfg.TrashRetention)
 	}
 
+	// Folders of finished orders are archived once the restoration period is
+	// over, so any folder still known to an order is kept rather than trashed.
+	// So are the folders someone brought back from the trash
+	validFolders, err := d.orderService.GetAllOrdersFolders(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	restoredFolders, err := d.orderService.GetRestoredFolders(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	validFolders = append(validFolders, restoredFolders...)
+
 	validFoldersMap := make(map[string]struct{})
 	for _, folder := range validFolders {
 		validFoldersMap[folder] = struct{}{}
 	}
 
-	entries, err := os.ReadDir(d.cfg.DirPath)
+	for _, folder := range folders {
+		if strings.HasPrefix(folder, ".") {
+			continue
+		}
+
+		if _, ok := validFoldersMap[folder]; ok {
+			continue
+		}
+		// A folder is reserved before its order is saved
+		if d.fileService.IsReserved(folder) {
+			continue
+		}
+		d.quarantineFolder(folder)
+	}
+
+	d.purgeTrash()
+}
+
+func (d *DefaultService) archiveExpiredOrders(ctx context.Context) {
+	orderIDs, err := d.orderService.GetArchivableOrdersIDs(ctx)
 	if err != nil {
 		slog.Error(err.Error())
+		return
 	}
 
-	for _, dirEntry := range entries {
-		if !dirEntry.IsDir() {
+	for _, id := range orderIDs {
+		if d.cfg.DryRun {
+			slog.Info("Dry run: would archive order folder", "orderID", id)
 			continue
 		}
+		if err := d.archiveOrder(ctx, id); err != nil {
+			slog.Error("Failed to archive order", "error", err, "orderID", id)
+		}
+	}
+}
 
-		if _, ok := validFoldersMap[dirEntry.Name()]; !ok {
-			path := filepath.Join(d.cfg.DirPath, dirEntry.Name())
-			if err := d.fileService.DeleteFolder(path); err != nil {
-				slog.Error(err.Error())
-			}
+func (d *DefaultService) archiveOrder(ctx context.Context, orderID int) error {
+	order, err := d.orderService.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	// The archive of unpacked files is kept, so only the folder goes
+	if order.ArchivePath != "" {
+		if err := d.orderService.SetOrderUnarchived(ctx, orderID, false); err != nil {
+			return err
+		}
+		if err := d.fileService.DeleteFolder(order.FolderPath); err != nil {
+			return err
 		}
+		slog.Info("Removed unpacked order folder", "orderID", orderID, "archive", order.ArchivePath)
+		return nil
+	}
+
+	manifest, err := json.MarshalIndent(order, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	archivePath, err := d.fileService.ArchiveFolder(order.FolderPath, manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := d.orderService.SetOrderArchive(ctx, orderID, archivePath, orderSvc.SystemUserID); err != nil {
+		return err
+	}
+
+	if err := d.fileService.DeleteFolder(order.FolderPath); err != nil {
+		return err
 	}
+
+	slog.Info("Archived order folder", "orderID", orderID, "archive", archivePath)
+	return nil
+}
+
+// RestoreOrderFiles unpacks the archived files of the order back into its folder,
+// orders that were never archived are left as is
+func (d *DefaultService) RestoreOrderFiles(ctx context.Context, orderID int, userID int64) error {
+	order, err := d.orderService.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.ArchivePath == "" {
+		return nil
+	}
+
+	if order.UnarchivedAt == nil {
+		if err := d.fileService.ExtractArchive(order.ArchivePath, order.FolderPath); err != nil {
+			slog.Error("Failed to extract order archive", "error", err, "orderID", orderID)
+			return err
+		}
+	}
+
+	if err := d.orderService.SetOrderArchive(ctx, orderID, "", userID); err != nil {
+		return err
+	}
+
+	if err := d.fileService.DeleteArchive(order.ArchivePath); err != nil {
+		slog.Error("Failed to delete order archive", "error", err, "orderID", orderID)
+	}
+
+	return nil
+}
+
+// UnpackOrderFiles extracts the archived files of a finished order into its
+// folder for a while, the archive is kept so the sweep only has to remove
+// the folder again once the restoration period is over
+func (d *DefaultService) UnpackOrderFiles(ctx context.Context, orderID int) error {
+	order, err := d.orderService.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.ArchivePath == "" || order.UnarchivedAt != nil {
+		return nil
+	}
+
+	if err := d.fileService.ExtractArchive(order.ArchivePath, order.FolderPath); err != nil {
+		slog.Error("Failed to extract order archive", "error", err, "orderID", orderID)
+		return err
+	}
+
+	return d.orderService.SetOrderUnarchived(ctx, orderID, true)
+}
+
+func (d *DefaultService) quarantineFolder(folder string) {
+	if d.cfg.DryRun {
+		slog.Info("Dry run: would move orphan folder to trash", "folder", folder)
+		return
+	}
+
+	name, err := d.fileService.MoveToTrash(folder)
+	if err != nil {
+		slog.Error("Failed to move orphan folder to trash", "error", err, "folder", folder)
+		return
+	}
+	slog.Info("Moved orphan folder to trash", "folder", folder, "trash", name)
+}
+
+func (d *DefaultService) purgeTrash() {
+	if d.cfg.TrashRetention <= 0 {
+		return
+	}
+
+	entries, err := d.fileService.ListTrash()
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		if time.Since(entry.TrashedAt) < d.cfg.TrashRetention {
+			continue
+		}
+
+		if d.cfg.DryRun {
+			slog.Info("Dry run: would purge trashed folder", "folder", entry.Folder, "trashedAt", entry.TrashedAt)
+			continue
+		}
+
+		if err := d.fileService.DeleteFromTrash(entry.Name); err != nil {
+			slog.Error("Failed to purge trashed folder", "error", err, "folder", entry.Folder)
+			continue
+		}
+		slog.Info("Purged trashed folder", "folder", entry.Folder, "trashedAt", entry.TrashedAt)
+	}
+}
+
+// ReconcileFolder reconciles the order stored in the folder, folders that
+// don't belong to any order are left for the global sweep
+func (d *DefaultService) ReconcileFolder(ctx context.Context, folder string) {
+	orderID, err := d.orderService.GetOrderIDByFolder(ctx, folder)
+	if errors.Is(err, orderSvc.ErrOrderNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	d.ReconcileOrder(ctx, orderID)
 }
 
 func (d *DefaultService) ReconcileOrder(ctx context.Context, orderID int) {
@@ -131,7 +325,7 @@ func (d *DefaultService) ReconcileOrder(ctx context.Context, orderID int) {
 		orderFilesMap[file.Name] = file
 	}
 
-	files, err := d.fileService.ReadFiles(order.FolderPath)
+	checksums, err := d.fileService.GetChecksums(order.FolderPath)
 	if err != nil {
 		slog.Error(err.Error())
 		return
@@ -139,37 +333,54 @@ func (d *DefaultService) ReconcileOrder(ctx context.Context, orderID int) {
 
 	var removedFiles []string
 	var newFiles []orderSvc.File
+	var modifiedFiles []orderSvc.File
 
-	filesMap := make(map[string]fileSvc.ReadResult)
-
-	for file := range files {
-		if file.Err != nil {
-			slog.Error(file.Err.Error())
+	for name, checksum := range checksums {
+		orderFile, ok := orderFilesMap[name]
+		if !ok {
+			file := orderSvc.File{
+				Name:     name,
+				Checksum: checksum,
+			}
+			file.Analysis, file.GCode = d.fileService.InspectFile(order.FolderPath, name)
+			newFiles = append(newFiles, file)
 			continue
 		}
 
-		filesMap[file.Name] = file
-
-		_, ok := orderFilesMap[file.Name]
-		if !ok {
-			newFiles = append(newFiles, orderSvc.File{
-				Name: file.Name,
-			})
+		if orderFile.Checksum == checksum {
 			continue
 		}
+
+		modified := orderSvc.File{
+			Name:     name,
+			Checksum: checksum,
+		}
+		// Files saved before checksums were tracked only need their hash
+		// filled in, their Telegram copy is still the same content
+		if orderFile.Checksum == 0 {
+			modified.TgFileID = orderFile.TgFileID
+		}
+		// The stored analysis and estimate describe the old content, they are
+		// overwritten with those of the new one
+		modified.Analysis, modified.GCode = d.fileService.InspectFile(order.FolderPath, name)
+		modifiedFiles = append(modifiedFiles, modified)
 	}
 
 	for name := range orderFilesMap {
-		if _, ok := filesMap[name]; !ok {
+		if _, ok := checksums[name]; !ok {
 			removedFiles = append(removedFiles, name)
 		}
 	}
 
-	if err := d.orderService.RemoveOrderFiles(ctx, orderID, removedFiles); err != nil {
+	if err := d.orderService.RemoveOrderFiles(ctx, orderID, removedFiles, orderSvc.SystemUserID); err != nil {
+		slog.Error(err.Error())
+	}
+
+	if err := d.orderService.AddFilesToOrder(ctx, orderID, newFiles, orderSvc.SystemUserID); err != nil {
 		slog.Error(err.Error())
 	}
 
-	if err := d.orderService.AddFilesToOrder(ctx, orderID, newFiles); err != nil {
+	if err := d.orderService.UpdateOrderFiles(ctx, orderID, modifiedFiles, orderSvc.SystemUserID); err != nil {
 		slog.Error(err.Error())
 	}
 }
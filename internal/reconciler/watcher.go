@@ -0,0 +1,186 @@
+package reconciler
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultWatchDebounce = 3 * time.Second
+
+// Watcher listens for filesystem changes under the local orders directory and
+// reconciles the affected order once its folder has been quiet for a while
+type Watcher struct {
+	root       string
+	debounce   time.Duration
+	reconciler Service
+	watcher    *fsnotify.Watcher
+	wg         *sync.WaitGroup
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func NewWatcher(root string, debounce time.Duration, reconciler Service) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	return &Watcher{
+		root:       filepath.Clean(root),
+		debounce:   debounce,
+		reconciler: reconciler,
+		watcher:    watcher,
+		wg:         &sync.WaitGroup{},
+		timers:     make(map[string]*time.Timer),
+	}, nil
+}
+
+func (w *Watcher) Start(ctx context.Context) error {
+	if err := os.MkdirAll(w.root, os.ModePerm); err != nil {
+		return err
+	}
+	if err := w.watcher.Add(w.root); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			w.watchFolder(entry.Name())
+		}
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				w.stopTimers()
+				w.watcher.Close()
+				return
+			case event, ok := <-w.watcher.Events:
+				if !ok {
+					return
+				}
+				w.handleEvent(ctx, event)
+			case err, ok := <-w.watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Filesystem watcher error", "error", err)
+			}
+		}
+	}()
+
+	slog.Info("Started filesystem watcher", "dir", w.root)
+	return nil
+}
+
+func (w *Watcher) Stop(ctx context.Context) error {
+	stop := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		stop <- struct{}{}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stop:
+		return nil
+	}
+}
+
+func (w *Watcher) handleEvent(ctx context.Context, event fsnotify.Event) {
+	rel, err := filepath.Rel(w.root, event.Name)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	folder := parts[0]
+	if folder == "." || strings.HasPrefix(folder, ".") {
+		return
+	}
+
+	if len(parts) == 1 {
+		// A new order folder appeared, files dropped into it have to be seen as well
+		if event.Has(fsnotify.Create) {
+			w.watchFolder(folder)
+		}
+		return
+	}
+	if strings.HasPrefix(parts[len(parts)-1], ".") || event.Op == fsnotify.Chmod {
+		return
+	}
+
+	w.schedule(ctx, folder)
+}
+
+func (w *Watcher) watchFolder(folder string) {
+	if strings.HasPrefix(folder, ".") {
+		return
+	}
+	if err := w.watcher.Add(filepath.Join(w.root, folder)); err != nil {
+		slog.Error("Failed to watch order folder", "error", err, "folder", folder)
+	}
+}
+
+// schedule (re)arms the debounce timer of the folder. Every armed timer holds
+// the wait group until it either runs or is stopped, the event loop calling
+// this holds it as well, so Stop can't miss a timer
+func (w *Watcher) schedule(ctx context.Context, folder string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A timer that already fired is left to finish, a new one takes its place
+	if timer, ok := w.timers[folder]; ok && timer.Stop() {
+		timer.Reset(w.debounce)
+		return
+	}
+
+	w.wg.Add(1)
+	var timer *time.Timer
+	timer = time.AfterFunc(w.debounce, func() {
+		defer w.wg.Done()
+
+		w.mu.Lock()
+		if w.timers[folder] == timer {
+			delete(w.timers, folder)
+		}
+		w.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		w.reconciler.ReconcileFolder(ctx, folder)
+	})
+	w.timers[folder] = timer
+}
+
+func (w *Watcher) stopTimers() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for folder, timer := range w.timers {
+		// Timers that already fired release the wait group themselves
+		if timer.Stop() {
+			w.wg.Done()
+		}
+		delete(w.timers, folder)
+	}
+}
@@ -0,0 +1,134 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeReconciler counts the folders it is asked to reconcile, a release
+// channel makes every reconciliation wait for it
+type fakeReconciler struct {
+	Service
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newFakeReconciler() *fakeReconciler {
+	return &fakeReconciler{calls: make(map[string]int)}
+}
+
+func (f *fakeReconciler) ReconcileFolder(ctx context.Context, folder string) {
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[folder]++
+}
+
+func (f *fakeReconciler) count(folder string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[folder]
+}
+
+func stopWithin(w *Watcher, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return w.Stop(ctx)
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	root := t.TempDir()
+	reconciler := newFakeReconciler()
+	w, err := NewWatcher(root, 200*time.Millisecond, reconciler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.watcher.Close() })
+	ctx := context.Background()
+
+	for range 5 {
+		w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, "order", "part.stl"), Op: fsnotify.Write})
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, "other", "part.stl"), Op: fsnotify.Create})
+	// Hidden files, permission changes and the trash never trigger anything
+	w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, "ignored", ".part.stl.part"), Op: fsnotify.Write})
+	w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, "ignored", "part.stl"), Op: fsnotify.Chmod})
+	w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, ".trash", "1718000000_order", "part.stl"), Op: fsnotify.Remove})
+
+	if err := stopWithin(w, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	for folder, want := range map[string]int{"order": 1, "other": 1, "ignored": 0, ".trash": 0} {
+		if got := reconciler.count(folder); got != want {
+			t.Errorf("%s reconciled %d times, want %d", folder, got, want)
+		}
+	}
+
+	// A later burst is reconciled again
+	w.handleEvent(ctx, fsnotify.Event{Name: filepath.Join(root, "order", "part.stl"), Op: fsnotify.Write})
+	if err := stopWithin(w, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := reconciler.count("order"); got != 2 {
+		t.Errorf("order reconciled %d times after the second burst, want 2", got)
+	}
+}
+
+func TestWatcherStopWaitsForTimers(t *testing.T) {
+	reconciler := newFakeReconciler()
+	reconciler.release = make(chan struct{})
+	w, err := NewWatcher(t.TempDir(), 20*time.Millisecond, reconciler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.watcher.Close() })
+
+	w.schedule(context.Background(), "order")
+	// Both while the timer is armed and while its reconciliation runs
+	if err := stopWithin(w, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop() with an armed timer error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := stopWithin(w, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop() with a running reconciliation error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(reconciler.release)
+	if err := stopWithin(w, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := reconciler.count("order"); got != 1 {
+		t.Errorf("order reconciled %d times, want 1", got)
+	}
+}
+
+func TestWatcherStopDropsPendingTimers(t *testing.T) {
+	reconciler := newFakeReconciler()
+	w, err := NewWatcher(t.TempDir(), time.Hour, reconciler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	w.schedule(ctx, "order")
+	cancel()
+	if err := stopWithin(w, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := reconciler.count("order"); got != 0 {
+		t.Errorf("order reconciled %d times after the shutdown, want 0", got)
+	}
+}
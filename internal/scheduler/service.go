@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	orderSvc "print3d-order-bot/internal/order"
+	"print3d-order-bot/pkg/config"
+	"sync"
+	"time"
+)
+
+// Notifier delivers deadline messages, implemented by the Telegram bot
+type Notifier interface {
+	RemindDue(ctx context.Context, order orderSvc.OrderSummary) error
+	SendOverdueDigest(ctx context.Context, orders []orderSvc.OrderSummary) error
+}
+
+type Service interface {
+	Start(ctx context.Context)
+	Stop(ctx context.Context) error
+}
+
+const (
+	defaultInterval     = 10 * time.Minute
+	defaultRemindBefore = 24 * time.Hour
+)
+
+type DefaultService struct {
+	orderService orderSvc.Service
+	notifier     Notifier
+	cfg          *config.SchedulerCfg
+	wg           *sync.WaitGroup
+	// lastDigest is kept in memory only, a restart after the digest hour sends
+	// the day's digest once more
+	lastDigest time.Time
+}
+
+func NewDefaultService(orderService orderSvc.Service, notifier Notifier, cfg *config.SchedulerCfg) Service {
+	return &DefaultService{
+		orderService: orderService,
+		notifier:     notifier,
+		cfg:          cfg,
+		wg:           &sync.WaitGroup{},
+	}
+}
+
+func (d *DefaultService) Start(ctx context.Context) {
+	interval := d.cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.Tick(interval)
+
+	d.wg.Add(1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				d.wg.Done()
+				return
+			case <-ticker:
+				d.sendReminders(ctx)
+				d.sendDigest(ctx, time.Now())
+			}
+		}
+	}()
+	slog.Info("Started scheduler service")
+}
+
+func (d *DefaultService) Stop(ctx context.Context) error {
+	stop := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		stop <- struct{}{}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stop:
+		return nil
+	}
+}
+
+func (d *DefaultService) sendReminders(ctx context.Context) {
+	remindBefore := d.cfg.RemindBefore
+	if remindBefore <= 0 {
+		remindBefore = defaultRemindBefore
+	}
+
+	orders, err := d.orderService.GetOrdersDueWithin(ctx, remindBefore)
+	if err != nil {
+		return
+	}
+	for _, order := range orders {
+		if err := d.notifier.RemindDue(ctx, order); err != nil {
+			slog.Error("Failed to send deadline reminder", "error", err, "orderID", order.ID)
+			continue
+		}
+		// A failed mark means one more reminder on the next run, not a lost one
+		_ = d.orderService.MarkDueReminded(ctx, order.ID)
+	}
+}
+
+func (d *DefaultService) sendDigest(ctx context.Context, now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if now.Hour() < d.cfg.DigestHour || !d.lastDigest.Before(today) {
+		return
+	}
+
+	orders, err := d.orderService.GetOverdueOrders(ctx)
+	if err != nil {
+		return
+	}
+	d.lastDigest = today
+	if len(orders) == 0 {
+		return
+	}
+	if err := d.notifier.SendOverdueDigest(ctx, orders); err != nil {
+		slog.Error("Failed to send overdue digest", "error", err)
+	}
+}
@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"context"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	archivePageSize = 8
+	archiveTTL      = time.Hour
+)
+
+func (b *Bot) handleArchiveCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	key := fsm.ExtractKey(update)
+
+	data := &fsm.ArchiveData{}
+	text, markup, err := archivePage(ctx, b.orderService, data)
+	if err != nil {
+		b.sendText(ctx, key.ChatID, presentation.ArchiveLoadErrorMsg())
+		return
+	}
+	if markup == nil {
+		b.sendText(ctx, key.ChatID, presentation.EmptyArchiveMsg())
+		return
+	}
+
+	b.tryTransition(key, fsm.StepAwaitingArchiveAction, data)
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      key.ChatID,
+		Text:        text,
+		ReplyMarkup: markup,
+		ParseMode:   models.ParseModeHTML,
+	})
+}
+
+type ArchiveFlowDeps struct {
+	Router       *fsm.Router
+	OrderService order.Service
+}
+
+func SetupArchiveFlow(deps *ArchiveFlowDeps) {
+	fsm.Chain[*fsm.ArchiveData](deps.Router, "archive", fsm.StepAwaitingArchiveAction).
+		Timeout(archiveTTL, presentation.SessionExpiredMsg()).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.ArchiveData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if idStr, ok := strings.CutPrefix(data, presentation.OpenOrderCallbackPrefix); ok {
+				return openArchivedOrder(ctx, deps, idStr)
+			}
+			if monthStr, ok := strings.CutPrefix(data, presentation.ArchiveMonthCallbackPrefix); ok {
+				month, err := time.Parse("2006-01", monthStr)
+				if err != nil {
+					return nil
+				}
+				ids, err := deps.OrderService.GetClosedOrdersIDs(ctx.Ctx, month.Year(), month.Month())
+				if err != nil {
+					return ctx.SendMessage(presentation.ArchiveLoadErrorMsg(), nil)
+				}
+				ctx.Data.Year, ctx.Data.Month = month.Year(), month.Month()
+				ctx.Data.OrdersIDs = ids
+				ctx.Data.Page = 0
+				return updateArchivePage(ctx, deps)
+			}
+
+			switch data {
+			case "previous":
+				if ctx.Data.Page > 0 {
+					ctx.Data.Page--
+				}
+			case "next":
+				ctx.Data.Page++
+			case "months":
+				*ctx.Data = fsm.ArchiveData{}
+			default:
+				return nil
+			}
+			return updateArchivePage(ctx, deps)
+		})
+}
+
+func updateArchivePage(ctx *fsm.ConversationContext[*fsm.ArchiveData], deps *ArchiveFlowDeps) error {
+	text, markup, err := archivePage(ctx.Ctx, deps.OrderService, ctx.Data)
+	if err != nil {
+		return ctx.Complete(presentation.ArchiveLoadErrorMsg())
+	}
+	if markup == nil {
+		return ctx.Complete(presentation.EmptyArchiveMsg())
+	}
+
+	ctx.Transition(fsm.StepAwaitingArchiveAction, ctx.Data)
+	_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+		ChatID:      ctx.ChatID,
+		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+		ParseMode:   models.ParseModeHTML,
+	})
+	return err
+}
+
+// openArchivedOrder replaces the month list with the order viewer slider over
+// the orders of the month, starting at the picked one
+func openArchivedOrder(ctx *fsm.ConversationContext[*fsm.ArchiveData], deps *ArchiveFlowDeps, idStr string) error {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil
+	}
+	idx := slices.Index(ctx.Data.OrdersIDs, id)
+	if idx < 0 {
+		return nil
+	}
+
+	sliderData := &fsm.OrderSliderData{
+		OrdersIDs:  ctx.Data.OrdersIDs,
+		CurrentIdx: idx,
+	}
+	text, markup, err := orderView(ctx.Ctx, deps.OrderService, sliderData)
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+
+	disablePreview := true
+	ctx.Transition(fsm.StepAwaitingOrderViewSliderAction, sliderData)
+	_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+		ChatID:      ctx.ChatID,
+		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: &disablePreview,
+		},
+		ParseMode: models.ParseModeHTML,
+	})
+	return err
+}
+
+// archivePage renders the month list until a month is picked, then the orders
+// of that month. A nil keyboard means there is nothing to show
+func archivePage(ctx context.Context, orderService order.Service, data *fsm.ArchiveData) (string, *models.InlineKeyboardMarkup, error) {
+	if data.Month == 0 {
+		months, err := orderService.GetArchiveMonths(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(months) == 0 {
+			return "", nil, nil
+		}
+		pages := (len(months) + archivePageSize - 1) / archivePageSize
+		data.Page = min(data.Page, pages-1)
+		offset := data.Page * archivePageSize
+		end := min(offset+archivePageSize, len(months))
+		return presentation.ArchiveMonthsMsg(), presentation.ArchiveMonthsKbd(months[offset:end], data.Page, pages), nil
+	}
+
+	if len(data.OrdersIDs) == 0 {
+		return "", nil, nil
+	}
+	pages := (len(data.OrdersIDs) + archivePageSize - 1) / archivePageSize
+	data.Page = min(data.Page, pages-1)
+	offset := data.Page * archivePageSize
+	end := min(offset+archivePageSize, len(data.OrdersIDs))
+
+	orders, err := orderService.GetOrderSummaries(ctx, data.OrdersIDs[offset:end])
+	if err != nil {
+		return "", nil, err
+	}
+	return presentation.ArchiveOrdersMsg(data.Year, data.Month, len(data.OrdersIDs), offset, orders),
+		presentation.ArchiveOrdersKbd(orders, offset, data.Page, pages),
+		nil
+}
@@ -6,30 +6,46 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
 	"print3d-order-bot/internal/file"
 	"print3d-order-bot/internal/mtproto"
 	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/quote"
 	"print3d-order-bot/internal/reconciler"
 	"print3d-order-bot/internal/telegram/internal/fsm"
 	"print3d-order-bot/internal/telegram/internal/media"
+	"print3d-order-bot/internal/user"
 	"print3d-order-bot/pkg/config"
+	"strings"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Bot struct {
 	orderService      order.Service
 	fileService       file.Service
 	reconcilerService reconciler.Service
+	userService       user.Service
+	clientService     client.Service
+	catalogService    catalog.Service
+	quoteEngine       quote.Engine
 	api               *bot.Bot
 	mtprotoClient     *mtproto.Client
 	router            *fsm.Router
 	collector         *media.Collector
+	username          string
+	workspaceChatID   int64
 }
 
-func NewBot(orderService order.Service, fileService file.Service, reconcilerService reconciler.Service, mtprotoClient *mtproto.Client, cfg *config.TelegramCfg) (*Bot, error) {
-	state := fsm.NewFSM()
+func NewBot(ctx context.Context, orderService order.Service, fileService file.Service, reconcilerService reconciler.Service, userService user.Service, clientService client.Service, catalogService catalog.Service, quoteEngine quote.Engine, mtprotoClient *mtproto.Client, pool *pgxpool.Pool, cfg *config.TelegramCfg) (*Bot, error) {
+	store, err := fsm.NewPostgresStore(ctx, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore conversation states: %w", err)
+	}
+	state := fsm.NewFSM(store)
 	router := fsm.NewRouter(state)
 	collector := media.NewCollector()
 	botOpts := []bot.Option{bot.WithMiddlewares(router.Middleware)}
@@ -37,27 +53,56 @@ func NewBot(orderService order.Service, fileService file.Service, reconcilerServ
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot instance: %w", err)
 	}
+	me, err := b.GetMe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bot info: %w", err)
+	}
 
-	return &Bot{
+	telegramBot := &Bot{
 		orderService:      orderService,
 		fileService:       fileService,
 		reconcilerService: reconcilerService,
+		userService:       userService,
+		clientService:     clientService,
+		catalogService:    catalogService,
+		quoteEngine:       quoteEngine,
 		api:               b,
 		mtprotoClient:     mtprotoClient,
 		router:            router,
 		collector:         collector,
-	}, nil
+		username:          me.Username,
+		workspaceChatID:   cfg.WorkspaceChatID,
+	}
+	router.SetAuthorizer(telegramBot.authorize)
+	router.SetUsername(me.Username)
+
+	return telegramBot, nil
 }
 
 func (b *Bot) Start(ctx context.Context) {
-	b.api.RegisterHandler(bot.HandlerTypeMessageText, "help", bot.MatchTypeCommandStartOnly, b.handlerHelpCmd)
-	b.api.RegisterHandler(bot.HandlerTypeMessageText, "orders", bot.MatchTypeCommandStartOnly, b.handleOrderViewCmd)
+	b.registerCommand("help", b.handlerHelpCmd)
+	b.registerCommand("orders", b.handleOrderViewCmd)
+	b.registerCommand("search", b.handleSearchCmd)
+	b.registerCommand("archive", b.handleArchiveCmd)
+	b.registerCommand("storage", b.handleStorageCmd)
+	b.registerCommand("trash", b.handleTrashCmd)
+	b.registerCommand("cancel", b.handleCancelCmd)
+	b.registerCommand("invite", b.handleInviteCmd)
+	b.registerCommand("revoke", b.handleRevokeCmd)
+	b.registerCommand("catalog", b.handleCatalogCmd)
+	b.registerCommand("catalog_add", b.handleCatalogAddCmd)
+	b.registerCommand("catalog_off", b.handleCatalogOffCmd)
+	b.registerCommand("catalog_on", b.handleCatalogOnCmd)
 
 	SetupOrderCreationFlow(&OrderCreationDeps{
-		Router:       b.router,
-		Collector:    b.collector,
-		OrderService: b.orderService,
-		FileService:  b.fileService,
+		Router:         b.router,
+		BotApi:         b,
+		Collector:      b.collector,
+		OrderService:   b.orderService,
+		FileService:    b.fileService,
+		ClientService:  b.clientService,
+		CatalogService: b.catalogService,
+		QuoteEngine:    b.quoteEngine,
 	})
 
 	SetupOrderViewerFlow(&OrderViewerDeps{
@@ -65,19 +110,68 @@ func (b *Bot) Start(ctx context.Context) {
 		OrderService:      b.orderService,
 		FileService:       b.fileService,
 		ReconcilerService: b.reconcilerService,
+		ClientService:     b.clientService,
+		CatalogService:    b.catalogService,
 		BotApi:            b,
 		MtprotoClient:     b.mtprotoClient,
 	})
 
 	SetupOrderEditFlow(&OrderEditFlowDeps{
+		Router:         b.router,
+		OrderService:   b.orderService,
+		CatalogService: b.catalogService,
+	})
+
+	SetupOrderItemsFlow(&OrderItemsFlowDeps{
+		Router:         b.router,
+		OrderService:   b.orderService,
+		CatalogService: b.catalogService,
+		QuoteEngine:    b.quoteEngine,
+	})
+
+	SetupPaymentFlow(&PaymentFlowDeps{
+		Router:       b.router,
+		OrderService: b.orderService,
+	})
+
+	SetupSearchFlow(&SearchFlowDeps{
+		Router:       b.router,
+		OrderService: b.orderService,
+	})
+
+	SetupArchiveFlow(&ArchiveFlowDeps{
+		Router:       b.router,
+		OrderService: b.orderService,
+	})
+
+	SetupTrashFlow(&TrashFlowDeps{
 		Router:       b.router,
+		FileService:  b.fileService,
 		OrderService: b.orderService,
 	})
 
+	b.router.StartSweeper(ctx, b.api)
+
 	slog.Info("Started Telegram Bot")
 	go b.api.Start(ctx)
 }
 
+// registerCommand also matches the "/command@bot" form used in groups and
+// ignores commands addressed to other bots
+func (b *Bot) registerCommand(command string, handler bot.HandlerFunc) {
+	b.api.RegisterHandlerMatchFunc(func(update *models.Update) bool {
+		if update.Message == nil || !strings.HasPrefix(update.Message.Text, "/") {
+			return false
+		}
+		name := strings.Fields(update.Message.Text)[0][1:]
+		name, target, addressed := strings.Cut(name, "@")
+		if addressed && !strings.EqualFold(target, b.username) {
+			return false
+		}
+		return name == command
+	}, handler)
+}
+
 func (b *Bot) SendMessage(ctx context.Context, params *bot.SendMessageParams) int {
 	msg, err := b.api.SendMessage(ctx, params)
 	if err != nil {
@@ -87,6 +181,14 @@ func (b *Bot) SendMessage(ctx context.Context, params *bot.SendMessageParams) in
 	return msg.ID
 }
 
+func (b *Bot) sendText(ctx context.Context, chatID int64, text string) {
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    chatID,
+		Text:      text,
+		ParseMode: models.ParseModeHTML,
+	})
+}
+
 func (b *Bot) EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) {
 	if _, err := b.api.EditMessageText(ctx, params); err != nil {
 		slog.Error(err.Error())
@@ -99,8 +201,27 @@ func (b *Bot) AnswerCallbackQuery(ctx context.Context, params *bot.AnswerCallbac
 	}
 }
 
-func (b *Bot) tryTransition(userID int64, newStep fsm.ConversationStep, newData fsm.StateData) {
-	b.router.Transition(userID, newStep, newData)
+func (b *Bot) tryTransition(key fsm.Key, newStep fsm.ConversationStep, newData fsm.StateData) {
+	b.router.Transition(key, newStep, newData)
+}
+
+// announce posts text to the workspace group, if one is configured
+func (b *Bot) announce(ctx context.Context, text string) {
+	if b.workspaceChatID == 0 {
+		return
+	}
+	b.sendText(ctx, b.workspaceChatID, text)
+}
+
+func sender(update *models.Update) *models.User {
+	if update.CallbackQuery != nil {
+		return &update.CallbackQuery.From
+	}
+	return update.Message.From
+}
+
+func (b *Bot) isWorkspace(chatID int64) bool {
+	return b.workspaceChatID != 0 && b.workspaceChatID == chatID
 }
 
 func (b *Bot) DownloadFile(ctx context.Context, fileID string, dst io.Writer) error {
@@ -136,9 +257,9 @@ func (b *Bot) DownloadFile(ctx context.Context, fileID string, dst io.Writer) er
 	return err
 }
 
-func (b *Bot) UploadFile(ctx context.Context, filename string, file io.ReadCloser, userID int64) error {
+func (b *Bot) UploadFile(ctx context.Context, filename string, file io.ReadCloser, chatID int64) error {
 	_, err := b.api.SendDocument(ctx, &bot.SendDocumentParams{
-		ChatID: userID,
+		ChatID: chatID,
 		Document: &models.InputFileUpload{
 			Filename: filename,
 			Data:     file,
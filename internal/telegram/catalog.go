@@ -0,0 +1,124 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func (b *Bot) handleCatalogCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	if !can(ctx, user.PermManageCatalog) {
+		b.sendText(ctx, chatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	c, err := b.catalogService.GetCatalog(ctx, true)
+	if err != nil {
+		b.sendText(ctx, chatID, presentation.CatalogLoadErrorMsg())
+		return
+	}
+	b.sendText(ctx, chatID, presentation.CatalogMsg(c))
+}
+
+// handleCatalogAddCmd adds an entry or updates an existing one, re-adding a
+// disabled entry enables it again
+func (b *Bot) handleCatalogAddCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	if !can(ctx, user.PermManageCatalog) {
+		b.sendText(ctx, chatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) < 2 {
+		b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+		return
+	}
+
+	var err error
+	switch catalog.Kind(strings.ToLower(args[0])) {
+	case catalog.KindTechnology:
+		if len(args) < 3 {
+			b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+			return
+		}
+		err = b.catalogService.AddTechnology(ctx, args[1], strings.Join(args[3:], " "), catalog.Unit(strings.ToLower(args[2])))
+	case catalog.KindMaterial:
+		if len(args) < 4 {
+			b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+			return
+		}
+		price, parseErr := presentation.ParseRUB(args[2])
+		if parseErr != nil {
+			b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+			return
+		}
+		err = b.catalogService.AddMaterial(ctx, args[1], strings.Join(args[3:], " "), price)
+	case catalog.KindColor:
+		err = b.catalogService.AddColor(ctx, strings.Join(args[1:], " "))
+	default:
+		b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+		return
+	}
+	b.sendCatalogResult(ctx, chatID, err)
+}
+
+func (b *Bot) handleCatalogOffCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	b.setCatalogEntryActive(ctx, update, false)
+}
+
+func (b *Bot) handleCatalogOnCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	b.setCatalogEntryActive(ctx, update, true)
+}
+
+func (b *Bot) setCatalogEntryActive(ctx context.Context, update *models.Update, active bool) {
+	chatID := update.Message.Chat.ID
+	if !can(ctx, user.PermManageCatalog) {
+		b.sendText(ctx, chatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) < 2 {
+		b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+		return
+	}
+
+	var err error
+	switch catalog.Kind(strings.ToLower(args[0])) {
+	case catalog.KindTechnology:
+		err = b.catalogService.SetTechnologyActive(ctx, args[1], active)
+	case catalog.KindMaterial:
+		if len(args) < 3 {
+			b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+			return
+		}
+		err = b.catalogService.SetMaterialActive(ctx, args[1], strings.Join(args[2:], " "), active)
+	case catalog.KindColor:
+		err = b.catalogService.SetColorActive(ctx, strings.Join(args[1:], " "), active)
+	default:
+		b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+		return
+	}
+	b.sendCatalogResult(ctx, chatID, err)
+}
+
+func (b *Bot) sendCatalogResult(ctx context.Context, chatID int64, err error) {
+	switch {
+	case errors.Is(err, catalog.ErrInvalidEntry):
+		b.sendText(ctx, chatID, presentation.CatalogUsageMsg())
+	case errors.Is(err, catalog.ErrEntryNotFound):
+		b.sendText(ctx, chatID, presentation.CatalogEntryNotFoundMsg())
+	case err != nil:
+		b.sendText(ctx, chatID, presentation.GenericErrorMsg())
+	default:
+		b.sendText(ctx, chatID, presentation.CatalogUpdatedMsg())
+	}
+}
@@ -10,8 +10,17 @@ import (
 
 func (b *Bot) handlerHelpCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
 	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:    update.Message.From.ID,
+		ChatID:    update.Message.Chat.ID,
 		Text:      presentation.HelpMsg(),
 		ParseMode: models.ParseModeHTML,
 	})
 }
+
+// The conversation itself is reset by the router before any command is handled
+func (b *Bot) handleCancelCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    update.Message.Chat.ID,
+		Text:      presentation.CancelledMsg(),
+		ParseMode: models.ParseModeHTML,
+	})
+}
@@ -3,6 +3,9 @@ package fsm
 import (
 	"errors"
 	"fmt"
+	"time"
+
+	"github.com/go-telegram/bot/models"
 )
 
 var IncompatibleHandler error = errors.New("incompatible handler")
@@ -13,6 +16,10 @@ type TextHandler[T StateData] func(*ConversationContext[T], string) error
 
 type CallbackHandler[T StateData] func(*ConversationContext[T], string) error
 
+// PromptHandler builds the message that asks the user for the step input.
+// It is sent when the conversation advances to the step or returns to it
+type PromptHandler[T StateData] func(*ConversationContext[T]) (string, *models.InlineKeyboardMarkup)
+
 func Chain[T StateData](router *Router, name string, initialStep ConversationStep) *ChainDefinition[T] {
 	return &ChainDefinition[T]{
 		name:    name,
@@ -25,6 +32,7 @@ type ChainDefinition[T StateData] struct {
 	name    string
 	router  *Router
 	current ConversationStep
+	timeout *stepTimeout
 }
 
 func (c *ChainDefinition[T]) OnText(handler TextHandler[T]) *ChainDefinition[T] {
@@ -33,15 +41,7 @@ func (c *ChainDefinition[T]) OnText(handler TextHandler[T]) *ChainDefinition[T]
 		if !ok {
 			return fmt.Errorf("type assertion failed")
 		}
-		typedCtx := &ConversationContext[T]{
-			Ctx:    ctx.Ctx,
-			Bot:    ctx.Bot,
-			Update: ctx.Update,
-			UserID: ctx.UserID,
-			Data:   typedData,
-			router: ctx.router,
-			Step:   ctx.Step,
-		}
+		typedCtx := withData(ctx, typedData)
 
 		if typedCtx.Update.Message == nil {
 			return IncompatibleHandler
@@ -60,15 +60,7 @@ func (c *ChainDefinition[T]) OnCallback(handler CallbackHandler[T]) *ChainDefini
 		if !ok {
 			return fmt.Errorf("type assertion failed")
 		}
-		typedCtx := &ConversationContext[T]{
-			Ctx:    ctx.Ctx,
-			Bot:    ctx.Bot,
-			Update: ctx.Update,
-			UserID: ctx.UserID,
-			Data:   typedData,
-			router: ctx.router,
-			Step:   ctx.Step,
-		}
+		typedCtx := withData(ctx, typedData)
 
 		if typedCtx.Update.CallbackQuery == nil {
 			return IncompatibleHandler
@@ -81,7 +73,59 @@ func (c *ChainDefinition[T]) OnCallback(handler CallbackHandler[T]) *ChainDefini
 	return c
 }
 
+func (c *ChainDefinition[T]) Prompt(prompt PromptHandler[T]) *ChainDefinition[T] {
+	wrapped := func(ctx *ConversationContext[StateData]) (string, *models.InlineKeyboardMarkup, error) {
+		typedData, ok := ctx.Data.(T)
+		if !ok {
+			return "", nil, fmt.Errorf("type assertion failed")
+		}
+		text, markup := prompt(withData(ctx, typedData))
+		return text, markup, nil
+	}
+
+	c.router.RegisterPrompt(c.current, wrapped)
+	return c
+}
+
+// Then moves the definition to the next step, "back" from it returns to the
+// current one unless overridden with BackTo
 func (c *ChainDefinition[T]) Then(nextStep ConversationStep) *ChainDefinition[T] {
+	c.router.SetPreviousStep(nextStep, c.current)
 	c.current = nextStep
+	if c.timeout != nil {
+		c.router.SetStepTTL(nextStep, c.timeout.ttl, c.timeout.msg)
+	}
 	return c
 }
+
+// BackTo overrides the step "back" returns to from the current one
+func (c *ChainDefinition[T]) BackTo(step ConversationStep) *ChainDefinition[T] {
+	c.router.SetPreviousStep(c.current, step)
+	return c
+}
+
+// NoBack disables returning from the current step
+func (c *ChainDefinition[T]) NoBack() *ChainDefinition[T] {
+	c.router.SetPreviousStep(c.current, StepIdle)
+	return c
+}
+
+// Timeout applies the TTL to the current step and every step declared after it
+func (c *ChainDefinition[T]) Timeout(ttl time.Duration, msg string) *ChainDefinition[T] {
+	c.timeout = &stepTimeout{ttl: ttl, msg: msg}
+	c.router.SetStepTTL(c.current, ttl, msg)
+	return c
+}
+
+func withData[T StateData](ctx *ConversationContext[StateData], data T) *ConversationContext[T] {
+	return &ConversationContext[T]{
+		Ctx:    ctx.Ctx,
+		Bot:    ctx.Bot,
+		Update: ctx.Update,
+		ChatID: ctx.ChatID,
+		UserID: ctx.UserID,
+		Data:   data,
+		router: ctx.router,
+		Step:   ctx.Step,
+	}
+}
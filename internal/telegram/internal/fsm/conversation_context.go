@@ -11,15 +11,20 @@ type ConversationContext[T StateData] struct {
 	Ctx    context.Context
 	Bot    *bot.Bot
 	Update *models.Update
+	ChatID int64
 	UserID int64
 	Data   T
 	Step   ConversationStep
 	router *Router
 }
 
+func (c *ConversationContext[T]) Key() Key {
+	return Key{ChatID: c.ChatID, UserID: c.UserID}
+}
+
 func (c *ConversationContext[T]) SendMessage(text string, markup models.ReplyMarkup) error {
 	params := &bot.SendMessageParams{
-		ChatID:      c.UserID,
+		ChatID:      c.ChatID,
 		Text:        text,
 		ParseMode:   models.ParseModeHTML,
 		ReplyMarkup: markup,
@@ -39,7 +44,7 @@ func (c *ConversationContext[T]) AnswerCallbackQuery(text string, showAlert bool
 
 func (c *ConversationContext[T]) EditMessageText(messageID int, text string) error {
 	_, err := c.Bot.EditMessageText(c.Ctx, &bot.EditMessageTextParams{
-		ChatID:    c.UserID,
+		ChatID:    c.ChatID,
 		MessageID: messageID,
 		Text:      text,
 		ParseMode: models.ParseModeHTML,
@@ -49,7 +54,7 @@ func (c *ConversationContext[T]) EditMessageText(messageID int, text string) err
 
 func (c *ConversationContext[T]) EditMessageReplyMarkup(messageID int, markup models.ReplyMarkup) error {
 	_, err := c.Bot.EditMessageReplyMarkup(c.Ctx, &bot.EditMessageReplyMarkupParams{
-		ChatID:      c.UserID,
+		ChatID:      c.ChatID,
 		MessageID:   messageID,
 		ReplyMarkup: markup,
 	})
@@ -57,10 +62,29 @@ func (c *ConversationContext[T]) EditMessageReplyMarkup(messageID int, markup mo
 }
 
 func (c *ConversationContext[T]) Transition(nextStep ConversationStep, data StateData) {
-	c.router.Transition(c.UserID, nextStep, data)
+	c.router.Transition(c.Key(), nextStep, data)
+}
+
+// Advance transitions to the step keeping the current data and sends the step prompt
+func (c *ConversationContext[T]) Advance(nextStep ConversationStep) error {
+	return c.AdvanceWith(nextStep, c.Data)
+}
+
+// AdvanceWith is Advance for steps of another chain that need their own data
+func (c *ConversationContext[T]) AdvanceWith(nextStep ConversationStep, data StateData) error {
+	return c.router.Advance(&ConversationContext[StateData]{
+		Ctx:    c.Ctx,
+		Bot:    c.Bot,
+		Update: c.Update,
+		ChatID: c.ChatID,
+		UserID: c.UserID,
+		Data:   data,
+		router: c.router,
+		Step:   c.Step,
+	}, nextStep)
 }
 
 func (c *ConversationContext[T]) Complete(text string) error {
-	c.router.Transition(c.UserID, StepIdle, &IdleData{})
+	c.router.Transition(c.Key(), StepIdle, &IdleData{})
 	return c.SendMessage(text, nil)
 }
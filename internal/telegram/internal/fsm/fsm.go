@@ -1,67 +1,102 @@
 package fsm
 
 import (
+	"log/slog"
 	"sync"
+	"time"
 )
 
 type FSM struct {
-	states map[int64]State
-	mu     *sync.RWMutex
+	store StateStore
+	mu    *sync.RWMutex
+}
+
+// Key identifies a conversation, the same user has independent
+// conversations in every chat the bot is in
+type Key struct {
+	ChatID int64
+	UserID int64
 }
 
 type State struct {
-	Step ConversationStep
-	Data StateData
+	Step      ConversationStep
+	Data      StateData
+	UpdatedAt time.Time
 }
 
-func NewFSM() *FSM {
+func NewFSM(store StateStore) *FSM {
 	return &FSM{
-		states: make(map[int64]State),
-		mu:     &sync.RWMutex{},
+		store: store,
+		mu:    &sync.RWMutex{},
 	}
 }
 
-func (f *FSM) GetOrCreateState(userID int64) State {
+func (f *FSM) GetOrCreateState(key Key) State {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	state, ok := f.states[userID]
+	state, ok := f.store.Get(key)
 	if !ok {
 		state = State{
 			Step: StepIdle,
 			Data: &IdleData{},
 		}
-		f.states[userID] = state
 	}
 
 	return state
 }
 
-func (f *FSM) SetState(userID int64, state State) {
+func (f *FSM) SetState(key Key, state State) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	f.states[userID] = state
+	state.UpdatedAt = time.Now()
+	var err error
+	if state.Step == StepIdle {
+		err = f.store.Delete(key)
+	} else {
+		err = f.store.Set(key, state)
+	}
+	if err != nil {
+		slog.Error("Failed to persist conversation state", "error", err, "chatID", key.ChatID, "userID", key.UserID, "step", state.Step)
+	}
 }
 
-func (f *FSM) SetStep(userID int64, step ConversationStep) {
-	state := f.GetOrCreateState(userID)
+func (f *FSM) SetStep(key Key, step ConversationStep) {
+	state := f.GetOrCreateState(key)
 
 	state.Step = step
-	f.SetState(userID, state)
+	f.SetState(key, state)
 }
 
-func (f *FSM) UpdateData(userID int64, data StateData) {
-	state := f.GetOrCreateState(userID)
+func (f *FSM) UpdateData(key Key, data StateData) {
+	state := f.GetOrCreateState(key)
 
 	state.Data = data
-	f.SetState(userID, state)
+	f.SetState(key, state)
+}
+
+// Sync writes the current state back to the store, handlers are free to
+// mutate the state data in place without a transition
+func (f *FSM) Sync(key Key) {
+	f.mu.RLock()
+	state, ok := f.store.Get(key)
+	f.mu.RUnlock()
+	if !ok {
+		return
+	}
+	f.SetState(key, state)
+}
+
+// Range calls fn for every user that is in the middle of a conversation
+func (f *FSM) Range(fn func(key Key, state State)) {
+	f.store.Range(fn)
 }
 
-func (f *FSM) ResetState(userID int64) {
-	state := f.GetOrCreateState(userID)
+func (f *FSM) ResetState(key Key) {
+	state := f.GetOrCreateState(key)
 
 	state.Step = StepIdle
 	state.Data = &IdleData{}
-	f.SetState(userID, state)
+	f.SetState(key, state)
 }
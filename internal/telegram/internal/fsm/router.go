@@ -3,26 +3,50 @@ package fsm
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"print3d-order-bot/internal/telegram/internal/media"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
 
+const sweepInterval = time.Minute
+
 type Router struct {
 	fsm               *FSM
 	handlers          map[ConversationStep][]UniversalHandler[StateData]
+	timeouts          map[ConversationStep]stepTimeout
+	prompts           map[ConversationStep]promptFunc
+	previous          map[ConversationStep]ConversationStep
 	pendingUsers      sync.Map
 	attachmentHandler UniversalHandler[StateData]
+	authorizer        Authorizer
+	// username of the bot, commands addressed to other bots are ignored
+	username string
+}
+
+// Authorizer decides whether the update is let through and may enrich the
+// context with the caller's identity for the handlers
+type Authorizer func(ctx context.Context, b *bot.Bot, update *models.Update, key Key) (context.Context, bool)
+
+type promptFunc func(*ConversationContext[StateData]) (string, *models.InlineKeyboardMarkup, error)
+
+type stepTimeout struct {
+	ttl time.Duration
+	msg string
 }
 
 func NewRouter(fsm *FSM) *Router {
 	return &Router{
 		fsm:          fsm,
 		handlers:     make(map[ConversationStep][]UniversalHandler[StateData]),
+		timeouts:     make(map[ConversationStep]stepTimeout),
+		prompts:      make(map[ConversationStep]promptFunc),
+		previous:     make(map[ConversationStep]ConversationStep),
 		pendingUsers: sync.Map{},
 	}
 }
@@ -31,28 +55,66 @@ func (r *Router) SetAttachmentHandler(handler UniversalHandler[StateData]) {
 	r.attachmentHandler = handler
 }
 
+func (r *Router) SetAuthorizer(authorizer Authorizer) {
+	r.authorizer = authorizer
+}
+
+func (r *Router) SetUsername(username string) {
+	r.username = username
+}
+
 func (r *Router) RegisterHandler(step ConversationStep, handler UniversalHandler[StateData]) {
 	r.handlers[step] = append(r.handlers[step], handler)
 }
 
+func (r *Router) RegisterPrompt(step ConversationStep, prompt promptFunc) {
+	r.prompts[step] = prompt
+}
+
+// SetPreviousStep sets the step "back" returns to, StepIdle disables it
+func (r *Router) SetPreviousStep(step, previous ConversationStep) {
+	r.previous[step] = previous
+}
+
+// SetStepTTL resets the conversation when the user stays in the step longer
+// than ttl and sends them msg
+func (r *Router) SetStepTTL(step ConversationStep, ttl time.Duration, msg string) {
+	r.timeouts[step] = stepTimeout{ttl: ttl, msg: msg}
+}
+
 func (r *Router) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
 	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
-		userID := extractUserID(update)
-		if userID == 0 {
+		key := ExtractKey(update)
+		if key.UserID == 0 {
 			return
 		}
 
-		if r.tryBlock(userID, ctx, b, update) {
+		if r.authorizer != nil {
+			authCtx, ok := r.authorizer(ctx, b, update, key)
+			if !ok {
+				return
+			}
+			ctx = authCtx
+		}
+
+		if r.tryBlock(key, ctx, b, update) {
 			return
 		}
 
 		if isCommand(update) {
-			r.fsm.ResetState(userID)
+			if r.isForeignCommand(update) {
+				return
+			}
+			r.fsm.ResetState(key)
 			next(ctx, b, update)
 			return
 		}
 
-		state := r.fsm.GetOrCreateState(userID)
+		state := r.fsm.GetOrCreateState(key)
+		if r.isExpired(state) {
+			r.expire(ctx, b, key, state)
+			state = r.fsm.GetOrCreateState(key)
+		}
 
 		var handlers []UniversalHandler[StateData]
 		if hasMedia(update) {
@@ -60,7 +122,7 @@ func (r *Router) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
 		} else {
 			hndlrs, exist := r.handlers[state.Step]
 			if !exist {
-				r.fsm.ResetState(userID)
+				r.fsm.ResetState(key)
 				next(ctx, b, update)
 				return
 			}
@@ -71,12 +133,21 @@ func (r *Router) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
 			Ctx:    ctx,
 			Bot:    b,
 			Update: update,
-			UserID: userID,
+			ChatID: key.ChatID,
+			UserID: key.UserID,
 			Data:   state.Data,
 			router: r,
 			Step:   state.Step,
 		}
 
+		if isBack(update) {
+			if err := r.back(convCtx); err != nil {
+				slog.Error("Failed to return to the previous step", "error", err, "step", state.Step)
+				r.fsm.ResetState(key)
+			}
+			return
+		}
+
 		for _, handler := range handlers {
 			if err := handler(convCtx); err != nil {
 				if errors.Is(err, IncompatibleHandler) {
@@ -84,53 +155,158 @@ func (r *Router) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
 				}
 				slog.Error("Handler error", "error", err, "step", state.Step)
 				convCtx.SendMessage("<b>❌ Произошла неизвестная ошибка, попробуйте позже</b>", nil)
-				r.fsm.ResetState(userID)
+				r.fsm.ResetState(key)
 			}
 		}
+		r.fsm.Sync(key)
+	}
+}
+
+// Advance moves the conversation to the step and sends its prompt
+func (r *Router) Advance(ctx *ConversationContext[StateData], step ConversationStep) error {
+	r.Transition(ctx.Key(), step, ctx.Data)
+	ctx.Step = step
+	return r.sendPrompt(ctx)
+}
+
+// HasBack reports whether the step has somewhere to return to
+func (r *Router) HasBack(step ConversationStep) bool {
+	previous, ok := r.previous[step]
+	if !ok || previous == StepIdle {
+		return false
+	}
+	_, ok = r.prompts[previous]
+	return ok
+}
+
+func (r *Router) back(ctx *ConversationContext[StateData]) error {
+	if err := ctx.AnswerCallbackQuery("", false); err != nil {
+		return err
 	}
+	if !r.HasBack(ctx.Step) {
+		return nil
+	}
+	return r.Advance(ctx, r.previous[ctx.Step])
 }
 
-func (r *Router) Transition(userID int64, nextStep ConversationStep, data StateData) {
-	r.fsm.SetStep(userID, nextStep)
+func (r *Router) sendPrompt(ctx *ConversationContext[StateData]) error {
+	prompt, ok := r.prompts[ctx.Step]
+	if !ok {
+		return fmt.Errorf("no prompt registered for step %d", ctx.Step)
+	}
+	text, markup, err := prompt(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.HasBack(ctx.Step) {
+		markup = WithBackButton(markup)
+	}
+	if markup == nil {
+		return ctx.SendMessage(text, nil)
+	}
+	return ctx.SendMessage(text, markup)
+}
+
+func (r *Router) Transition(key Key, nextStep ConversationStep, data StateData) {
+	r.fsm.SetStep(key, nextStep)
 	if data == nil {
 		return
 	}
-	r.fsm.UpdateData(userID, data)
+	r.fsm.UpdateData(key, data)
+}
+
+// StartSweeper periodically resets conversations that outlived their step TTL
+func (r *Router) StartSweeper(ctx context.Context, b *bot.Bot) {
+	ticker := time.NewTicker(sweepInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.sweep(ctx, b)
+			}
+		}
+	}()
+}
+
+func (r *Router) sweep(ctx context.Context, b *bot.Bot) {
+	r.fsm.Range(func(key Key, state State) {
+		if _, pending := r.pendingUsers.Load(key); pending {
+			return
+		}
+		if r.isExpired(state) {
+			r.expire(ctx, b, key, state)
+		}
+	})
 }
 
-func (r *Router) tryBlock(userID int64, ctx context.Context, b *bot.Bot, update *models.Update) bool {
-	value, exists := r.pendingUsers.Load(userID)
+func (r *Router) isExpired(state State) bool {
+	timeout, ok := r.timeouts[state.Step]
+	if !ok || state.UpdatedAt.IsZero() {
+		return false
+	}
+	return time.Since(state.UpdatedAt) > timeout.ttl
+}
+
+func (r *Router) expire(ctx context.Context, b *bot.Bot, key Key, state State) {
+	r.fsm.ResetState(key)
+	slog.Info("Conversation expired", "chatID", key.ChatID, "userID", key.UserID, "step", state.Step)
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    key.ChatID,
+		Text:      r.timeouts[state.Step].msg,
+		ParseMode: models.ParseModeHTML,
+	}); err != nil {
+		slog.Error("Failed to send expiration message", "error", err, "chatID", key.ChatID)
+	}
+}
+
+func (r *Router) tryBlock(key Key, ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	value, exists := r.pendingUsers.Load(key)
 	if exists {
 		msg := value.(string)
 		func(ctx context.Context, b *bot.Bot, update *models.Update) {
 			if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID:    userID,
+				ChatID:    key.ChatID,
 				Text:      msg,
 				ParseMode: models.ParseModeHTML,
 			}); err != nil {
-				slog.Error("Failed to send pending message", "error", err, "userID", userID)
+				slog.Error("Failed to send pending message", "error", err, "chatID", key.ChatID)
 			}
 		}(ctx, b, update)
 	}
 	return exists
 }
 
-func (r *Router) Freeze(userID int64, msg string) {
-	r.pendingUsers.Store(userID, msg)
+func (r *Router) Freeze(key Key, msg string) {
+	r.pendingUsers.Store(key, msg)
 }
 
-func (r *Router) Unfreeze(userID int64) {
-	r.pendingUsers.Delete(userID)
+func (r *Router) Unfreeze(key Key) {
+	r.pendingUsers.Delete(key)
 }
 
-func extractUserID(update *models.Update) int64 {
-	var userID int64
-	if update.Message != nil {
-		userID = update.Message.From.ID
+// ExtractKey returns the conversation the update belongs to, callbacks on
+// messages too old to be accessible fall back to the user's private chat
+func ExtractKey(update *models.Update) Key {
+	var key Key
+	if update.Message != nil && update.Message.From != nil {
+		key.UserID = update.Message.From.ID
+		key.ChatID = update.Message.Chat.ID
 	} else if update.CallbackQuery != nil {
-		userID = update.CallbackQuery.From.ID
+		key.UserID = update.CallbackQuery.From.ID
+		key.ChatID = update.CallbackQuery.From.ID
+		switch {
+		case update.CallbackQuery.Message.Message != nil:
+			key.ChatID = update.CallbackQuery.Message.Message.Chat.ID
+		case update.CallbackQuery.Message.InaccessibleMessage != nil:
+			key.ChatID = update.CallbackQuery.Message.InaccessibleMessage.Chat.ID
+		}
 	}
-	return userID
+	return key
 }
 
 func isCommand(update *models.Update) bool {
@@ -143,6 +319,32 @@ func isCommand(update *models.Update) bool {
 	return false
 }
 
+// isForeignCommand matches the "/command@bot" form of commands addressed to
+// another bot in a group, the same way the bot registers its commands
+func (r *Router) isForeignCommand(update *models.Update) bool {
+	name := strings.Fields(update.Message.Text)[0]
+	_, target, addressed := strings.Cut(name, "@")
+	return addressed && !strings.EqualFold(target, r.username)
+}
+
+const BackCallback = "back"
+
+func isBack(update *models.Update) bool {
+	return update.CallbackQuery != nil && update.CallbackQuery.Data == BackCallback
+}
+
+// WithBackButton appends the generic "back" button to the keyboard
+func WithBackButton(markup *models.InlineKeyboardMarkup) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{}
+	if markup != nil {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, markup.InlineKeyboard...)
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "◀️ Назад", CallbackData: BackCallback},
+	})
+	return keyboard
+}
+
 func hasMedia(update *models.Update) bool {
 	if update.Message == nil {
 		return false
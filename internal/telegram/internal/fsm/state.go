@@ -1,7 +1,14 @@
 package fsm
 
-import "print3d-order-bot/internal/telegram/internal/model"
+import (
+	"print3d-order-bot/internal/gcode"
+	modelSvc "print3d-order-bot/internal/model"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/model"
+	"time"
+)
 
+// ConversationStep values are persisted, new steps go to the end of the list
 type ConversationStep int
 
 const (
@@ -19,6 +26,23 @@ const (
 	StepAwaitingEditCost
 	StepAwaitingEditComments
 	StepAwaitingEditOverrideComments
+	StepAwaitingTrashAction
+	StepAwaitingClient
+	StepAwaitingSearchAction
+	StepAwaitingOrderFilterAction
+	StepAwaitingFilterDates
+	StepAwaitingFilterCost
+	StepAwaitingFilterClient
+	StepAwaitingArchiveAction
+	StepAwaitingOrderDueDate
+	StepAwaitingPaymentAmount
+	StepAwaitingPaymentMethod
+	StepAwaitingPaymentDate
+	StepAwaitingPaymentNote
+	StepAwaitingOrderItems
+	StepAwaitingOrderItemDetails
+	StepAwaitingItemsEditAction
+	StepAwaitingItemsEditDetails
 )
 
 type StateData interface {
@@ -30,29 +54,66 @@ type IdleData struct{}
 func (data *IdleData) StateData() {}
 
 type OrderData struct {
-	UserID     int64
-	PrintType  string
+	UserID    int64
+	PrintType string
+	// ClientID is zero until an existing client is picked, a new one is
+	// created together with the order
+	ClientID   int
 	ClientName string
 	Cost       float32
+	DueAt      *time.Time
 	Comments   []string
 	Contacts   []string
 	Links      []string
 	Files      []model.File
 	OrdersIDs  []int
 	CurrentIdx int
+	ItemsDraft
+	// Analyses of the draft models by file name, nil for the ones that
+	// couldn't be analyzed so they aren't downloaded again
+	Analyses map[string]*modelSvc.Analysis
+	// Estimates of the draft sliced files by file name, kept like Analyses
+	Estimates map[string]*gcode.Estimate
+	// QuoteMaterialID is the catalog material the cost is quoted in, zero
+	// until one is picked
+	QuoteMaterialID int
 }
 
 func (data *OrderData) StateData() {}
 
+// ItemsDraft is the bill of materials being put together, shared by order
+// creation and item editing
+type ItemsDraft struct {
+	Items []order.OrderItem
+	// PendingFile and EditIdx describe the item whose details are awaited,
+	// EditIdx is -1 for a new item
+	PendingFile string
+	EditIdx     int
+}
+
+type OrderItemsData struct {
+	OrderID   int
+	PrintType string
+	Files     []string
+	ItemsDraft
+}
+
+func (data *OrderItemsData) StateData() {}
+
 type OrderSliderData struct {
 	OrdersIDs  []int
 	CurrentIdx int
+	Filter     order.OrderFilter
+	// FilterClientName is shown in the filter panel instead of the client ID
+	FilterClientName string
 }
 
 func (data *OrderSliderData) StateData() {}
 
 type OrderEditData struct {
-	OrderID          int
+	OrderID int
+	// HasItems skips the cost step, the items of the order make up its cost
+	HasItems         bool
 	PrintType        *string
 	ClientName       *string
 	Cost             *float32
@@ -61,3 +122,39 @@ type OrderEditData struct {
 }
 
 func (data *OrderEditData) StateData() {}
+
+type TrashData struct {
+	Entries []string
+}
+
+func (data *TrashData) StateData() {}
+
+type PaymentData struct {
+	OrderID int
+	// Outstanding is offered as the amount so a full payment is one tap
+	Outstanding float32
+	Amount      float32
+	Method      order.PaymentMethod
+	PaidAt      time.Time
+}
+
+func (data *PaymentData) StateData() {}
+
+type SearchData struct {
+	Query     string
+	OrdersIDs []int
+	Page      int
+}
+
+func (data *SearchData) StateData() {}
+
+// ArchiveData lists the months with finished orders until one is picked,
+// then the orders of that month
+type ArchiveData struct {
+	Year      int
+	Month     time.Month
+	OrdersIDs []int
+	Page      int
+}
+
+func (data *ArchiveData) StateData() {}
@@ -0,0 +1,126 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+type StateStore interface {
+	Get(key Key) (State, bool)
+	Set(key Key, state State) error
+	Delete(key Key) error
+	Range(fn func(key Key, state State))
+}
+
+type MemoryStore struct {
+	states map[Key]State
+	mu     *sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states: make(map[Key]State),
+		mu:     &sync.RWMutex{},
+	}
+}
+
+func (m *MemoryStore) Get(key Key) (State, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state, ok := m.states[key]
+	return state, ok
+}
+
+func (m *MemoryStore) Set(key Key, state State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.states[key] = state
+	return nil
+}
+
+func (m *MemoryStore) Delete(key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.states, key)
+	return nil
+}
+
+func (m *MemoryStore) Range(fn func(key Key, state State)) {
+	m.mu.RLock()
+	states := make(map[Key]State, len(m.states))
+	for key, state := range m.states {
+		states[key] = state
+	}
+	m.mu.RUnlock()
+
+	for key, state := range states {
+		fn(key, state)
+	}
+}
+
+// stateDataTypes maps the type tag stored next to the serialised data to its
+// constructor. Every StateData that can outlive a restart has to be listed here
+var stateDataTypes = map[string]func() StateData{
+	"idle":         func() StateData { return &IdleData{} },
+	"order":        func() StateData { return &OrderData{} },
+	"order_slider": func() StateData { return &OrderSliderData{} },
+	"order_edit":   func() StateData { return &OrderEditData{} },
+	"payment":      func() StateData { return &PaymentData{} },
+	"order_items":  func() StateData { return &OrderItemsData{} },
+	"trash":        func() StateData { return &TrashData{} },
+	"search":       func() StateData { return &SearchData{} },
+	"archive":      func() StateData { return &ArchiveData{} },
+}
+
+func stateDataTag(data StateData) (string, error) {
+	switch data.(type) {
+	case *IdleData:
+		return "idle", nil
+	case *OrderData:
+		return "order", nil
+	case *OrderSliderData:
+		return "order_slider", nil
+	case *OrderEditData:
+		return "order_edit", nil
+	case *PaymentData:
+		return "payment", nil
+	case *OrderItemsData:
+		return "order_items", nil
+	case *TrashData:
+		return "trash", nil
+	case *SearchData:
+		return "search", nil
+	case *ArchiveData:
+		return "archive", nil
+	default:
+		return "", fmt.Errorf("unknown state data type %T", data)
+	}
+}
+
+func marshalStateData(data StateData) (string, []byte, error) {
+	tag, err := stateDataTag(data)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return tag, raw, nil
+}
+
+func unmarshalStateData(tag string, raw []byte) (StateData, error) {
+	constructor, ok := stateDataTypes[tag]
+	if !ok {
+		return nil, fmt.Errorf("unknown state data tag %q", tag)
+	}
+	data := constructor()
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
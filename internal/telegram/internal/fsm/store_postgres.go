@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"print3d-order-bot/pkg"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps states in memory and writes every change through to the
+// fsm_states table, so conversations survive restarts
+type PostgresStore struct {
+	cache   *MemoryStore
+	pool    *pgxpool.Pool
+	builder squirrel.StatementBuilderType
+}
+
+func NewPostgresStore(ctx context.Context, pool *pgxpool.Pool) (*PostgresStore, error) {
+	store := &PostgresStore{
+		cache:   NewMemoryStore(),
+		pool:    pool,
+		builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+	if err := store.load(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (p *PostgresStore) load(ctx context.Context) error {
+	query, args, err := p.builder.Select("chat_id", "user_id", "step", "data_type", "data", "updated_at").
+		From("fsm_states").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "LoadStates",
+			Err:   err,
+		}
+	}
+
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to select states",
+			Info:  fmt.Sprintf("LoadStates; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key Key
+		var step ConversationStep
+		var tag string
+		var raw []byte
+		var updatedAt time.Time
+		if err := rows.Scan(&key.ChatID, &key.UserID, &step, &tag, &raw, &updatedAt); err != nil {
+			return &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("LoadStates; query: %s", query),
+				Err:   err,
+			}
+		}
+
+		data, err := unmarshalStateData(tag, raw)
+		if err != nil {
+			slog.Warn("Dropping unreadable conversation state", "error", err, "chatID", key.ChatID, "userID", key.UserID)
+			continue
+		}
+		p.cache.Set(key, State{Step: step, Data: data, UpdatedAt: updatedAt})
+	}
+
+	return rows.Err()
+}
+
+func (p *PostgresStore) Get(key Key) (State, bool) {
+	return p.cache.Get(key)
+}
+
+func (p *PostgresStore) Set(key Key, state State) error {
+	p.cache.Set(key, state)
+
+	tag, raw, err := marshalStateData(state.Data)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := p.builder.Insert("fsm_states").
+		Columns("chat_id", "user_id", "step", "data_type", "data", "updated_at").
+		Values(key.ChatID, key.UserID, state.Step, tag, raw, state.UpdatedAt).
+		Suffix("on conflict (chat_id, user_id) do update set step = excluded.step, data_type = excluded.data_type, data = excluded.data, updated_at = excluded.updated_at").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "SaveState",
+			Err:   err,
+		}
+	}
+
+	if _, err := p.pool.Exec(context.Background(), query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to save state",
+			Info:  fmt.Sprintf("SaveState; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (p *PostgresStore) Range(fn func(key Key, state State)) {
+	p.cache.Range(fn)
+}
+
+func (p *PostgresStore) Delete(key Key) error {
+	p.cache.Delete(key)
+
+	query, args, err := p.builder.Delete("fsm_states").
+		Where(squirrel.Eq{"chat_id": key.ChatID, "user_id": key.UserID}).
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "DeleteState",
+			Err:   err,
+		}
+	}
+
+	if _, err := p.pool.Exec(context.Background(), query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to delete state",
+			Info:  fmt.Sprintf("DeleteState; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
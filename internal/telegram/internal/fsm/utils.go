@@ -1,20 +1,13 @@
 package fsm
 
-import "github.com/go-telegram/bot/models"
-
-func HandleCallbackWithMessage[T StateData](
-	callback string,
-	nextStep ConversationStep,
-	askMessage string,
-	markup models.ReplyMarkup,
-) func(*ConversationContext[T], string) error {
+// AdvanceOnCallback moves the conversation to nextStep when the callback matches
+func AdvanceOnCallback[T StateData](callback string, nextStep ConversationStep) func(*ConversationContext[T], string) error {
 	return func(ctx *ConversationContext[T], data string) error {
 		if err := ctx.AnswerCallbackQuery("", false); err != nil {
 			return err
 		}
 		if data == callback {
-			ctx.Transition(nextStep, ctx.Data)
-			return ctx.SendMessage(askMessage, markup)
+			return ctx.Advance(nextStep)
 		}
 		return nil
 	}
@@ -9,10 +9,16 @@ import (
 )
 
 type Collector struct {
-	windows map[int64]*Window
+	windows map[WindowKey]*Window
 	mu      sync.Mutex
 }
 
+// WindowKey separates windows of different members posting to the same group
+type WindowKey struct {
+	ChatID int64
+	UserID int64
+}
+
 type Window struct {
 	Media    []model.File
 	Contacts []string
@@ -23,12 +29,12 @@ type Window struct {
 
 func NewCollector() *Collector {
 	return &Collector{
-		windows: make(map[int64]*Window),
+		windows: make(map[WindowKey]*Window),
 		mu:      sync.Mutex{},
 	}
 }
 
-func (c *Collector) GetOrCreateWindow(id int64) *Window {
+func (c *Collector) GetOrCreateWindow(id WindowKey) *Window {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -48,14 +54,14 @@ func (c *Collector) GetOrCreateWindow(id int64) *Window {
 	return window
 }
 
-func (c *Collector) SetWindow(id int64, window *Window) {
+func (c *Collector) SetWindow(id WindowKey, window *Window) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.windows[id] = window
 }
 
-func (c *Collector) DeleteWindow(id int64) {
+func (c *Collector) DeleteWindow(id WindowKey) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -63,7 +69,8 @@ func (c *Collector) DeleteWindow(id int64) {
 }
 
 func (c *Collector) ProcessMessage(message *models.Message, onSuccess func(window *Window)) {
-	window := c.GetOrCreateWindow(message.From.ID)
+	key := WindowKey{ChatID: message.Chat.ID, UserID: message.From.ID}
+	window := c.GetOrCreateWindow(key)
 
 	media := ExtractMedia(message)
 	contacts, links := ExtractResources(message)
@@ -81,8 +88,8 @@ func (c *Collector) ProcessMessage(message *models.Message, onSuccess func(windo
 
 	window.Timer = time.AfterFunc(time.Second*2, func() {
 		onSuccess(window)
-		c.DeleteWindow(message.From.ID)
+		c.DeleteWindow(key)
 	})
 
-	c.SetWindow(message.From.ID, window)
+	c.SetWindow(key, window)
 }
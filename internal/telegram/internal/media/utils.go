@@ -130,13 +130,20 @@ func ExtractResources(message *models.Message) ([]string, []string) {
 	var contacts []string
 	var links []string
 
+	// The author of a forwarded message is the client themselves
+	if message.ForwardOrigin != nil && message.ForwardOrigin.MessageOriginUser != nil {
+		if username := message.ForwardOrigin.MessageOriginUser.SenderUser.Username; username != "" {
+			contacts = append(contacts, "@"+username)
+		}
+	}
+
 	if message.Entities == nil {
 		return contacts, links
 	}
 
 	for _, entity := range message.Entities {
 		switch entity.Type {
-		case models.MessageEntityTypeEmail, models.MessageEntityTypePhoneNumber:
+		case models.MessageEntityTypeEmail, models.MessageEntityTypePhoneNumber, models.MessageEntityTypeMention:
 			body := extractEntityText(message.Text, entity.Offset, entity.Length)
 			contacts = append(contacts, body)
 		case models.MessageEntityTypeTextLink:
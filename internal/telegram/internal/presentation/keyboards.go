@@ -2,6 +2,12 @@ package presentation
 
 import (
 	"fmt"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
+	"print3d-order-bot/internal/file"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/user"
+	"slices"
 
 	"github.com/go-telegram/bot/models"
 )
@@ -15,23 +21,76 @@ func OrderTypeKbd() *models.InlineKeyboardMarkup {
 	}
 }
 
-func PrintTypeKbd() *models.InlineKeyboardMarkup {
+// PrintTypeCallbackPrefix is followed by the technology code, which orders
+// keep as their print type
+const PrintTypeCallbackPrefix = "type:"
+
+func PrintTypeKbd(technologies []catalog.Technology) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	for _, t := range technologies {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: t.Name, CallbackData: PrintTypeCallbackPrefix + t.Code},
+		})
+	}
+	return keyboard
+}
+
+func SkipKbd() *models.InlineKeyboardMarkup {
 	return &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
-			{{Text: "FDM", CallbackData: "fdm"}},
-			{{Text: "SLA", CallbackData: "sla"}},
+			{{Text: "⏩ Пропустить", CallbackData: "skip"}},
 		},
 	}
 }
 
-func SkipKbd() *models.InlineKeyboardMarkup {
+// DueDateCallbackPrefix is followed by the number of days from today
+const DueDateCallbackPrefix = "due:"
+
+func DueDateKbd() *models.InlineKeyboardMarkup {
 	return &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
-			{{Text: "⏩ Пропустить", CallbackData: "skip"}},
+			{
+				{Text: "Сегодня", CallbackData: DueDateCallbackPrefix + "0"},
+				{Text: "Завтра", CallbackData: DueDateCallbackPrefix + "1"},
+			},
+			{
+				{Text: "+3 дня", CallbackData: DueDateCallbackPrefix + "3"},
+				{Text: "+7 дней", CallbackData: DueDateCallbackPrefix + "7"},
+			},
+			{{Text: "⏩ Без срока", CallbackData: "skip"}},
 		},
 	}
 }
 
+const QuoteMaterialCallbackPrefix = "quote_material:"
+
+// OrderQuoteKbd accepts the quoted total or switches the material it is
+// quoted in, the picked material is marked
+func OrderQuoteKbd(total float32, materials []catalog.Material, pickedID int) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: fmt.Sprintf("✔️ Принять %s₽", FormatRUB(total)), CallbackData: "accept_quote"}},
+		},
+	}
+	var row []models.InlineKeyboardButton
+	for _, m := range materials {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         checkedStr(m.ID == pickedID) + m.Name,
+			CallbackData: fmt.Sprintf("%s%d", QuoteMaterialCallbackPrefix, m.ID),
+		})
+		if len(row) == 3 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	return keyboard
+}
+
 func YesNoKbd() *models.InlineKeyboardMarkup {
 	return &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
@@ -41,14 +100,9 @@ func YesNoKbd() *models.InlineKeyboardMarkup {
 	}
 }
 
-type OrderSliderAction int
+const StatusCallbackPrefix = "status:"
 
-const (
-	OrderSliderClose OrderSliderAction = iota
-	OrderSliderRestore
-)
-
-func OrderSliderMgmtKbd(total, currentIdx int, action OrderSliderAction) *models.InlineKeyboardMarkup {
+func OrderSliderMgmtKbd(total, currentIdx int, data *order.ResponseOrder, nextStatuses []order.Status, viewer *user.User, filtered bool) *models.InlineKeyboardMarkup {
 	keyboard := &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{},
 	}
@@ -67,24 +121,287 @@ func OrderSliderMgmtKbd(total, currentIdx int, action OrderSliderAction) *models
 		})
 	}
 	var buttons [][]models.InlineKeyboardButton
-	switch action {
-	case OrderSliderClose:
-		buttons = [][]models.InlineKeyboardButton{
-			{{Text: "📩 Закрыть", CallbackData: "close"}},
-			{{Text: "📁 Скачать файлы", CallbackData: "files"}},
-			{{Text: "Редактировать", CallbackData: "edit"}},
+	if data.Status.IsTerminal() {
+		if viewer.Can(user.PermChangeStatus) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "🔄 Восстановить", CallbackData: "restore"}})
+		}
+		if data.ArchivePath != "" && data.UnarchivedAt == nil && viewer.Can(user.PermDownloadFiles) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "📦 Распаковать файлы", CallbackData: "unarchive"}})
+		}
+		buttons = append(buttons, []models.InlineKeyboardButton{{Text: "📜 История", CallbackData: "history"}})
+		if viewer.Can(user.PermCreateOrders) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "📑 Создать копию", CallbackData: "clone"}})
+		}
+	} else {
+		if viewer.Can(user.PermChangeStatus) {
+			for _, next := range nextStatuses {
+				buttons = append(buttons, []models.InlineKeyboardButton{
+					{Text: getStatusActionStr(next), CallbackData: StatusCallbackPrefix + string(next)},
+				})
+			}
+		}
+		if viewer.Can(user.PermDownloadFiles) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "📁 Скачать файлы", CallbackData: "files"}})
 		}
-	case OrderSliderRestore:
-		buttons = [][]models.InlineKeyboardButton{
-			{{Text: "🔄 Восстановить", CallbackData: "restore"}},
+		if viewer.Can(user.PermEditOrders) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "Редактировать", CallbackData: "edit"}})
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "🧾 Позиции", CallbackData: "items"}})
 		}
+		if viewer.Can(user.PermRegisterPayments) {
+			buttons = append(buttons, []models.InlineKeyboardButton{{Text: "💳 Оплата", CallbackData: "payment"}})
+		}
+		buttons = append(buttons, []models.InlineKeyboardButton{{Text: "📜 История", CallbackData: "history"}})
+	}
+
+	if data.ClientID != 0 {
+		buttons = append(buttons, []models.InlineKeyboardButton{{Text: "👤 Клиент", CallbackData: "client"}})
 	}
+	filterText := "🔎 Фильтры"
+	if filtered {
+		filterText = "🔎 Фильтры (включены)"
+	}
+	buttons = append(buttons, []models.InlineKeyboardButton{{Text: filterText, CallbackData: "filter"}})
 
 	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, sliderRow)
 	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons...)
 	return keyboard
 }
 
+const (
+	ItemFileCallbackPrefix   = "item_file:"
+	ItemEditCallbackPrefix   = "item_edit:"
+	ItemDeleteCallbackPrefix = "item_del:"
+)
+
+// OrderItemsKbd offers a new item for every file and without one, followed
+// by the controls of the items added so far
+func OrderItemsKbd(files []string, items []order.OrderItem, doneText string) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{}
+	for i, name := range files {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: "➕ " + name, CallbackData: fmt.Sprintf("%s%d", ItemFileCallbackPrefix, i)},
+		})
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "➕ Без файла", CallbackData: "item_new"},
+	})
+	for i := range items {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf("✏️ %d", i+1), CallbackData: fmt.Sprintf("%s%d", ItemEditCallbackPrefix, i)},
+			{Text: fmt.Sprintf("🗑 %d", i+1), CallbackData: fmt.Sprintf("%s%d", ItemDeleteCallbackPrefix, i)},
+		})
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: doneText, CallbackData: "done"},
+	})
+	return keyboard
+}
+
+func DeliverUnpaidKbd() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "⚠️ Всё равно выдать", CallbackData: "force_delivered"}},
+		},
+	}
+}
+
+func PaymentAmountKbd(outstanding float32) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: fmt.Sprintf("Весь остаток %s₽", FormatRUB(outstanding)), CallbackData: "outstanding"}},
+		},
+	}
+}
+
+const PaymentMethodCallbackPrefix = "method:"
+
+func PaymentMethodKbd() *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{}
+	for _, method := range order.PaymentMethods {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: getPaymentMethodStr(method), CallbackData: PaymentMethodCallbackPrefix + string(method)},
+		})
+	}
+	return keyboard
+}
+
+// PaymentDateCallbackPrefix is followed by the number of days before today
+const PaymentDateCallbackPrefix = "paid:"
+
+func PaymentDateKbd() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "Сегодня", CallbackData: PaymentDateCallbackPrefix + "0"},
+				{Text: "Вчера", CallbackData: PaymentDateCallbackPrefix + "1"},
+			},
+		},
+	}
+}
+
+func ForceRestoreKbd() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "⚠️ Всё равно восстановить", CallbackData: "force_restore"}},
+		},
+	}
+}
+
+const (
+	ClientCallbackPrefix       = "client:"
+	ClientOrdersCallbackPrefix = "client_orders:"
+)
+
+func ClientListKbd(clients []client.Client) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	for _, c := range clients {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{{
+			Text:         "👤 " + truncate(c.Name, 40),
+			CallbackData: fmt.Sprintf("%s%d", ClientCallbackPrefix, c.ID),
+		}})
+	}
+	return keyboard
+}
+
+func ClientPickerKbd(clients []client.Client) *models.InlineKeyboardMarkup {
+	keyboard := ClientListKbd(clients)
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "➕ Новый клиент", CallbackData: "new_client"},
+	})
+	return keyboard
+}
+
+func FilterClientKbd(clients []client.Client) *models.InlineKeyboardMarkup {
+	keyboard := ClientListKbd(clients)
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "👥 Любой клиент", CallbackData: "any_client"},
+	})
+	return keyboard
+}
+
+const (
+	FilterTypeCallbackPrefix   = "toggle_type:"
+	FilterStatusCallbackPrefix = "toggle_status:"
+	FilterDatesCallbackPrefix  = "dates:"
+	FilterCostCallbackPrefix   = "cost:"
+)
+
+// OrderFilterKbd is the main filter panel, submenus return to it with "panel".
+// Disabled technologies are still offered since older orders use them
+func OrderFilterKbd(filter *order.OrderFilter, viewer *user.User, technologies []catalog.Technology) *models.InlineKeyboardMarkup {
+	var typeRows [][]models.InlineKeyboardButton
+	var typeRow []models.InlineKeyboardButton
+	for _, t := range technologies {
+		typeRow = append(typeRow, models.InlineKeyboardButton{
+			Text:         checkedStr(slices.Contains(filter.PrintTypes, t.Code)) + t.Code,
+			CallbackData: FilterTypeCallbackPrefix + t.Code,
+		})
+		if len(typeRow) == 3 {
+			typeRows = append(typeRows, typeRow)
+			typeRow = nil
+		}
+	}
+	if len(typeRow) > 0 {
+		typeRows = append(typeRows, typeRow)
+	}
+	rangeRow := []models.InlineKeyboardButton{{Text: "👤 Клиент", CallbackData: "client"}}
+	if viewer.Can(user.PermViewCosts) {
+		rangeRow = append([]models.InlineKeyboardButton{{Text: "💲 Стоимость", CallbackData: "cost"}}, rangeRow...)
+	}
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: append(typeRows, [][]models.InlineKeyboardButton{
+			{
+				{Text: "📋 Статусы", CallbackData: "statuses"},
+				{Text: "📅 Дата создания", CallbackData: "dates"},
+			},
+			rangeRow,
+			{
+				{Text: "📄 " + getHasFilesStr(filter.HasFiles), CallbackData: "files"},
+				{Text: "↕️ " + getSortStr(filter.Sort), CallbackData: "sort"},
+			},
+			{
+				{Text: "♻️ Сбросить", CallbackData: "reset"},
+				{Text: "✔️ Показать", CallbackData: "apply"},
+			},
+		}...),
+	}
+}
+
+func FilterStatusKbd(filter *order.OrderFilter) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	var row []models.InlineKeyboardButton
+	for _, status := range order.Statuses {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         checkedStr(slices.Contains(filter.Statuses, status)) + getStatusStr(status),
+			CallbackData: FilterStatusCallbackPrefix + string(status),
+		})
+		if len(row) == 2 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "◀️ К фильтрам", CallbackData: "panel"},
+	})
+	return keyboard
+}
+
+func FilterDatesKbd() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "Сегодня", CallbackData: FilterDatesCallbackPrefix + "today"},
+				{Text: "7 дней", CallbackData: FilterDatesCallbackPrefix + "week"},
+			},
+			{
+				{Text: "30 дней", CallbackData: FilterDatesCallbackPrefix + "month"},
+				{Text: "С начала года", CallbackData: FilterDatesCallbackPrefix + "year"},
+			},
+			{
+				{Text: "✏️ Указать даты", CallbackData: FilterDatesCallbackPrefix + "custom"},
+				{Text: "За всё время", CallbackData: FilterDatesCallbackPrefix + "any"},
+			},
+			{{Text: "◀️ К фильтрам", CallbackData: "panel"}},
+		},
+	}
+}
+
+// FilterCostKbd presets carry the range in the same format users type it
+func FilterCostKbd() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "до 1 000₽", CallbackData: FilterCostCallbackPrefix + "-1000"},
+				{Text: "1 000–5 000₽", CallbackData: FilterCostCallbackPrefix + "1000-5000"},
+			},
+			{
+				{Text: "5 000–20 000₽", CallbackData: FilterCostCallbackPrefix + "5000-20000"},
+				{Text: "от 20 000₽", CallbackData: FilterCostCallbackPrefix + "20000-"},
+			},
+			{
+				{Text: "✏️ Указать сумму", CallbackData: FilterCostCallbackPrefix + "custom"},
+				{Text: "Любая", CallbackData: FilterCostCallbackPrefix + "any"},
+			},
+			{{Text: "◀️ К фильтрам", CallbackData: "panel"}},
+		},
+	}
+}
+
+func ClientCardKbd(card *client.Card) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "📋 Открыть заказы клиента", CallbackData: fmt.Sprintf("%s%d", ClientOrdersCallbackPrefix, card.ID)}},
+		},
+	}
+}
+
 func OrderSliderSelectorKbd(total, currentIdx int) *models.InlineKeyboardMarkup {
 	keyboard := &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{},
@@ -110,3 +427,77 @@ func OrderSliderSelectorKbd(total, currentIdx int) *models.InlineKeyboardMarkup
 	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, sliderRow, controlRow)
 	return keyboard
 }
+
+const OpenOrderCallbackPrefix = "open:"
+
+// OrderListKbd opens the listed orders, offset is the number of orders on the
+// previous pages
+func OrderListKbd(orders []order.OrderSummary, offset, page, pages int) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	for i, o := range orders {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("%d. №%d %s", offset+i+1, o.ID, truncate(o.ClientName, 30)),
+			CallbackData: fmt.Sprintf("%s%d", OpenOrderCallbackPrefix, o.ID),
+		}})
+	}
+	if pages > 1 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, pageRow(page, pages))
+	}
+	return keyboard
+}
+
+func pageRow(page, pages int) []models.InlineKeyboardButton {
+	var row []models.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, models.InlineKeyboardButton{Text: "◀️", CallbackData: "previous"})
+	}
+	row = append(row, models.InlineKeyboardButton{Text: fmt.Sprintf("%d/%d", page+1, pages), CallbackData: "noop"})
+	if page < pages-1 {
+		row = append(row, models.InlineKeyboardButton{Text: "▶️", CallbackData: "next"})
+	}
+	return row
+}
+
+const ArchiveMonthCallbackPrefix = "month:"
+
+// ArchiveMonthsKbd carries the month as "YYYY-MM" in the callback
+func ArchiveMonthsKbd(months []order.ArchiveMonth, page, pages int) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	for _, m := range months {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("📅 %s (%d)", getMonthStr(m.Year, m.Month), m.Count),
+			CallbackData: fmt.Sprintf("%s%04d-%02d", ArchiveMonthCallbackPrefix, m.Year, m.Month),
+		}})
+	}
+	if pages > 1 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, pageRow(page, pages))
+	}
+	return keyboard
+}
+
+func ArchiveOrdersKbd(orders []order.OrderSummary, offset, page, pages int) *models.InlineKeyboardMarkup {
+	keyboard := OrderListKbd(orders, offset, page, pages)
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "◀️ К месяцам", CallbackData: "months"},
+	})
+	return keyboard
+}
+
+const TrashRestoreCallbackPrefix = "restore:"
+
+func TrashKbd(entries []file.TrashEntry) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{},
+	}
+	for i, entry := range entries {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("♻️ %d. %s", i+1, truncate(entry.Folder, 40)),
+			CallbackData: fmt.Sprintf("%s%d", TrashRestoreCallbackPrefix, i),
+		}})
+	}
+	return keyboard
+}
@@ -2,9 +2,18 @@ package presentation
 
 import (
 	"fmt"
+	"html"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
+	"print3d-order-bot/internal/file"
 	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/quote"
 	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/user"
 	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
 )
 
 func GenericErrorMsg() string {
@@ -31,14 +40,26 @@ func OrderCreationErrorMsg() string {
 	return "<b>❌ Не удалось создать заказ. Попробуйте снова</b>"
 }
 
-func OrderCloseErrorMsg() string {
-	return "<b>❌ Не удалось закрыть заказ. Попробуйте позже</b>"
+func OrderStatusChangeErrorMsg() string {
+	return "<b>❌ Не удалось изменить статус заказа. Попробуйте позже</b>"
+}
+
+func InvalidStatusTransitionMsg() string {
+	return "<b>❌ Заказ уже находится в другом статусе. Обновите список заказов</b>"
 }
 
 func OrderRestoreErrorMsg() string {
 	return "<b>❌ Не удалось восстановить заказ. Попробуйте позже</b>"
 }
 
+func RestorationPeriodExpiredMsg() string {
+	return "<b>❌ Срок восстановления заказа истёк</b>"
+}
+
+func OrderUnpaidMsg(outstanding float32) string {
+	return fmt.Sprintf("<b>❌ Заказ нельзя выдать, пока он не оплачен. Осталось оплатить %s₽</b>", FormatRUB(outstanding))
+}
+
 func OrderEditErrorMsg() string {
 	return "<b>❌ Не удалось отредактировать заказ. Попробуйте позже</b>"
 }
@@ -47,6 +68,10 @@ func OrderEditedMsg() string {
 	return "<b>✔️ Информация о заказе обновлена</b>"
 }
 
+func OrderCostFromItemsMsg() string {
+	return "<b>❌ Стоимость заказа с позициями считается по ним, измените позиции заказа</b>"
+}
+
 func FilesLoadErrorMsg() string {
 	return "<b>❌ Не удалось загрузить файлы заказа. Попробуйте позже</b>"
 }
@@ -60,6 +85,22 @@ func HelpMsg() string {
 	sb.WriteString("<b>⚙️ Доступные команды:</b>")
 	sb.WriteString(breakLine(2))
 	sb.WriteString("<b>/orders — просмотреть активные заказы</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/search — найти заказ по клиенту, контактам, комментариям или файлам</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/archive — закрытые и отменённые заказы по месяцам</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/storage — статистика хранилища файлов</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/trash — папки, убранные из хранилища, и их восстановление</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/cancel — отменить текущее действие</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/invite, /revoke — управление доступом (только для владельца)</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<b>/catalog — технологии, материалы и цвета (только для владельца)</b>")
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<b>◀️ Кнопка «Назад» возвращает к предыдущему шагу</b>")
 	return sb.String()
 }
 
@@ -79,14 +120,112 @@ func AskClientNameMsg() string {
 	return "<b>👤 Введите имя клиента</b>"
 }
 
+func AskClientMsg(matched *client.Client) string {
+	var sb strings.Builder
+	if matched != nil {
+		sb.WriteString(fmt.Sprintf("<b>🔎 Клиент найден по контактам: %s</b>", matched.Name))
+		sb.WriteString(breakLine(2))
+	}
+	sb.WriteString("<b>👤 Выберите клиента или отправьте имя, телефон или почту для поиска</b>")
+	return sb.String()
+}
+
+func ClientSearchResultMsg(query string, found int) string {
+	if found == 0 {
+		return fmt.Sprintf("<b>🔍 По запросу «%s» клиентов не найдено</b>", html.EscapeString(query))
+	}
+	return fmt.Sprintf("<b>🔍 Клиенты по запросу «%s»:</b>", html.EscapeString(query))
+}
+
+func ClientsLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить клиентов. Попробуйте позже</b>"
+}
+
+func ClientCardMsg(card *client.Card, viewer *user.User) string {
+	const maxOrders = 15
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>👤 %s</b>", card.Name))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<i>Клиент с %s</i>", card.CreatedAt.Local().Format("02.01.2006")))
+	if len(card.Contacts) > 0 {
+		sb.WriteString(breakLine(2))
+		sb.WriteString("<b>📞 Контакты:</b>")
+		for _, contact := range card.Contacts {
+			sb.WriteString(breakLine(1))
+			sb.WriteString(fmt.Sprintf("<b>%s</b>", contact))
+		}
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString(fmt.Sprintf("<b>📋 Заказов: %d</b>", len(card.Orders)))
+	if viewer.Can(user.PermViewCosts) {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<b>💲 Всего потрачено: %s₽</b>", FormatRUB(card.TotalSpent)))
+	}
+	for i, o := range card.Orders {
+		if i == maxOrders {
+			sb.WriteString(breakLine(1))
+			sb.WriteString(fmt.Sprintf("<i>…и ещё %d</i>", len(card.Orders)-maxOrders))
+			break
+		}
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("№%d от %s — %s", o.ID, o.CreatedAt.Local().Format("02.01.2006"), getStatusStr(o.Status)))
+		if viewer.Can(user.PermViewCosts) {
+			sb.WriteString(fmt.Sprintf(", %s₽", FormatRUB(o.Cost)))
+		}
+	}
+	return sb.String()
+}
+
 func AskOrderCostMsg() string {
 	return "<b>💰 Введите стоимость заказа в рублях</b>"
 }
 
+func PendingAnalysisMsg() string {
+	return "<b>⏳ Анализирую файлы для расчёта стоимости</b>"
+}
+
+func OrderQuoteMsg(q *quote.Quote, material catalog.Material) string {
+	var sb strings.Builder
+	sb.WriteString("<b>🧮 Расчёт стоимости</b>")
+	sb.WriteString(breakLine(2))
+	sb.WriteString(fmt.Sprintf("🧵 %s: %s %s × %s₽ = %s₽", html.EscapeString(material.Name), formatDecimal(q.MaterialAmount), getUnitStr(q.Unit),
+		FormatRUB(material.Price), FormatRUB(float32(q.MaterialCost))))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("🖨 Печать: %s — %s₽", formatDuration(q.MachineTime), FormatRUB(float32(q.MachineCost))))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("🛠 Работа: %s — %s₽", formatDuration(q.LabourTime), FormatRUB(float32(q.LabourCost))))
+	if q.Approximate {
+		sb.WriteString(breakLine(2))
+		sb.WriteString("⚠️ Некоторые сетки не замкнуты, объём приблизительный")
+	}
+	if len(q.Skipped) > 0 {
+		names := make([]string, len(q.Skipped))
+		for i, name := range q.Skipped {
+			names[i] = html.EscapeString(name)
+		}
+		sb.WriteString(breakLine(2))
+		sb.WriteString("⚠️ Не учтены: " + strings.Join(names, ", "))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString(fmt.Sprintf("<b>💲 Предлагаемая стоимость: %s₽</b>", FormatRUB(q.Total)))
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<i>Примите расчёт, смените материал или введите свою стоимость</i>")
+	return sb.String()
+}
+
 func CostValidationErrorMsg() string {
 	return "❌ Стоимость заказа должна быть числом"
 }
 
+func AskDueDateMsg() string {
+	return "<b>⏰ Укажите срок сдачи заказа</b>\n\n<i>Выберите вариант или введите дату в формате ДД.ММ.ГГГГ</i>"
+}
+
+func DueDateValidationErrorMsg() string {
+	return "❌ Не удалось разобрать дату, она должна быть не раньше сегодняшней, пример: 25.03.2025"
+}
+
 func AskOrderCommentsMsg() string {
 	return "<b>💬 Введите комментарий к заказу</b>"
 }
@@ -132,9 +271,22 @@ func NewOrderPreviewMsg(data *fsm.OrderData) string {
 	sb.WriteString(fmt.Sprintf("<b>📝 Тип печати: %s</b>", data.PrintType))
 	sb.WriteString(breakLine(2))
 	sb.WriteString(fmt.Sprintf("<b>👤 Клиент: %s</b>", data.ClientName))
+	if data.ClientID == 0 {
+		sb.WriteString(" <i>(новый)</i>")
+	}
 	sb.WriteString(breakLine(2))
-	costStr := FormatRUB(data.Cost)
-	sb.WriteString(fmt.Sprintf("<b>💲 Стоимость заказа %s₽</b>", costStr))
+	if len(data.Items) > 0 {
+		sb.WriteString("<b>🧾 Позиции:</b>")
+		sb.WriteString(breakLine(1))
+		writeItems(&sb, data.Items, true)
+	} else {
+		costStr := FormatRUB(data.Cost)
+		sb.WriteString(fmt.Sprintf("<b>💲 Стоимость заказа %s₽</b>", costStr))
+	}
+	if data.DueAt != nil {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>⏰ Срок: %s</b>", data.DueAt.Local().Format("02.01.2006")))
+	}
 	if len(data.Comments) > 0 {
 		sb.WriteString(breakLine(2))
 		sb.WriteString("<b>💬 Комментарии к заказу:</b>")
@@ -170,6 +322,247 @@ func NewOrderPreviewMsg(data *fsm.OrderData) string {
 	return sb.String()
 }
 
+func AccessDeniedMsg(userID int64) string {
+	return fmt.Sprintf("<b>⛔ У вас нет доступа к боту</b>\n\nПередайте владельцу ваш ID: <code>%d</code>", userID)
+}
+
+func PermissionDeniedMsg() string {
+	return "<b>⛔ Недостаточно прав для этого действия</b>"
+}
+
+func InviteUsageMsg() string {
+	return "<b>Использование:</b> /invite &lt;ID пользователя&gt; [operator|viewer]"
+}
+
+func RevokeUsageMsg() string {
+	return "<b>Использование:</b> /revoke &lt;ID пользователя&gt;"
+}
+
+func UsersLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить список пользователей</b>"
+}
+
+func UserNotFoundMsg() string {
+	return "<b>❌ Пользователь не найден</b>"
+}
+
+func CannotChangeOwnerMsg() string {
+	return "<b>❌ Владельцев можно изменить только в конфигурации</b>"
+}
+
+func UserInvitedMsg(userID int64, role user.Role) string {
+	return fmt.Sprintf("<b>✔️ Пользователь <code>%d</code> получил роль «%s»</b>", userID, getRoleStr(role))
+}
+
+func UserRevokedMsg(userID int64) string {
+	return fmt.Sprintf("<b>✔️ Доступ пользователя <code>%d</code> отозван</b>", userID)
+}
+
+func UsersListMsg(users []user.User) string {
+	var sb strings.Builder
+	sb.WriteString("<b>👥 Пользователи бота:</b>")
+	for _, u := range users {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<code>%d</code> — %s", u.ID, getRoleStr(u.Role)))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString(InviteUsageMsg())
+	sb.WriteString(breakLine(1))
+	sb.WriteString(RevokeUsageMsg())
+	return sb.String()
+}
+
+func CatalogMsg(c *catalog.Catalog) string {
+	var sb strings.Builder
+	sb.WriteString("<b>📚 Каталог</b>")
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<b>🖨 Технологии:</b>")
+	for _, t := range c.Technologies {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<code>%s</code> — %s, цена за %s%s", t.Code, html.EscapeString(t.Name), getUnitStr(t.Unit), disabledStr(t.Active)))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<b>🧵 Материалы:</b>")
+	for _, m := range c.Materials {
+		unit := catalog.UnitGram
+		if t, ok := c.Technology(m.Technology); ok {
+			unit = t.Unit
+		}
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("%s: %s — %s₽/%s%s", m.Technology, html.EscapeString(m.Name), FormatRUB(m.Price), getUnitStr(unit), disabledStr(m.Active)))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<b>🎨 Цвета:</b>")
+	for _, color := range c.Colors {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(html.EscapeString(color.Name) + disabledStr(color.Active))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString(CatalogUsageMsg())
+	return sb.String()
+}
+
+func CatalogUsageMsg() string {
+	var sb strings.Builder
+	sb.WriteString("<b>Использование:</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("/catalog_add tech &lt;код&gt; &lt;g|ml&gt; [название]")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("/catalog_add material &lt;технология&gt; &lt;цена&gt; &lt;название&gt;")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("/catalog_add color &lt;название&gt;")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("/catalog_off, /catalog_on — те же аргументы без единиц и цены")
+	return sb.String()
+}
+
+func CatalogLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить каталог</b>"
+}
+
+func CatalogEntryNotFoundMsg() string {
+	return "<b>❌ Такой записи нет в каталоге</b>"
+}
+
+func CatalogUpdatedMsg() string {
+	return "<b>✔️ Каталог обновлён</b>"
+}
+
+func CancelledMsg() string {
+	return "<b>❌ Действие отменено</b>"
+}
+
+func OrderDraftExpiredMsg() string {
+	return "<b>⌛ Черновик заказа устарел и был удалён. Перешлите сообщения с файлами заново, чтобы начать сначала</b>"
+}
+
+func OrderItemsMsg(items []order.OrderItem) string {
+	var sb strings.Builder
+	sb.WriteString("<b>🧾 Позиции заказа</b>")
+	sb.WriteString(breakLine(2))
+	if len(items) == 0 {
+		sb.WriteString("<i>Позиций пока нет. Без них стоимость заказа вводится вручную</i>")
+	} else {
+		writeItems(&sb, items, true)
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<i>Нажмите на файл, чтобы добавить для него позицию</i>")
+	return sb.String()
+}
+
+// AskItemDetailsMsg lists the catalog materials and colors as a hint, typing
+// anything else is still allowed
+func AskItemDetailsMsg(fileName string, materials []catalog.Material, colors []catalog.Color) string {
+	var sb strings.Builder
+	if fileName != "" {
+		sb.WriteString(fmt.Sprintf("<b>✏️ Позиция для файла <code>%s</code></b>", html.EscapeString(fileName)))
+	} else {
+		sb.WriteString("<b>✏️ Позиция без файла</b>")
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString("Введите одной строкой: количество, материал, цвет, высоту слоя, заполнение и цену за штуку. Цвет, слой и заполнение можно не указывать")
+	if fileName != "" {
+		sb.WriteString(". Вместо цены можно поставить «?», тогда она будет рассчитана по файлу")
+	}
+	if len(materials) > 0 {
+		names := make([]string, len(materials))
+		for i, m := range materials {
+			names[i] = html.EscapeString(m.Name)
+		}
+		sb.WriteString(breakLine(2))
+		sb.WriteString("<b>🧵 Материалы:</b> " + strings.Join(names, ", "))
+	}
+	if len(colors) > 0 {
+		names := make([]string, len(colors))
+		for i, c := range colors {
+			names[i] = html.EscapeString(c.Name)
+		}
+		sb.WriteString(breakLine(1))
+		sb.WriteString("<b>🎨 Цвета:</b> " + strings.Join(names, ", "))
+	}
+	sb.WriteString(breakLine(2))
+	sb.WriteString("<i>Например: 4 PETG чёрный 0.2 20% 150</i>")
+	return sb.String()
+}
+
+func ItemValidationErrorMsg() string {
+	return "❌ Не удалось разобрать позицию, пример: 4 PETG чёрный 0.2 20% 150"
+}
+
+func ItemQuoteErrorMsg() string {
+	return "❌ Не удалось рассчитать цену по файлу в этом материале, укажите цену за штуку"
+}
+
+func OrderItemsSavedMsg() string {
+	return "<b>✔️ Позиции заказа сохранены, стоимость пересчитана</b>"
+}
+
+func OrderItemsSaveErrorMsg() string {
+	return "<b>❌ Не удалось сохранить позиции заказа. Попробуйте позже</b>"
+}
+
+func OrderItemsEditExpiredMsg() string {
+	return "<b>⌛ Редактирование позиций прервано из-за неактивности, изменения не сохранены</b>"
+}
+
+// writeItems renders the bill of materials followed by its total
+func writeItems(sb *strings.Builder, items []order.OrderItem, withPrices bool) {
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteString(breakLine(1))
+		}
+		sb.WriteString(fmt.Sprintf("%d. %s", i+1, getItemStr(item, withPrices)))
+	}
+	if withPrices {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<b>💲 Итого %s₽</b>", FormatRUB(order.ItemsTotal(items))))
+	}
+}
+
+func AskPaymentAmountMsg(outstanding float32) string {
+	return fmt.Sprintf("<b>💳 Введите сумму оплаты в рублях</b>\n\n<i>Осталось оплатить %s₽</i>", FormatRUB(outstanding))
+}
+
+func PaymentAmountValidationErrorMsg() string {
+	return "❌ Сумма оплаты должна быть положительным числом"
+}
+
+func AskPaymentMethodMsg() string {
+	return "<b>💳 Выберите способ оплаты</b>"
+}
+
+func AskPaymentDateMsg() string {
+	return "<b>📅 Когда клиент заплатил?</b>\n\n<i>Выберите вариант или введите дату в формате ДД.ММ.ГГГГ</i>"
+}
+
+func PaymentDateValidationErrorMsg() string {
+	return "❌ Не удалось разобрать дату, она должна быть не позже сегодняшней, пример: 25.03.2025"
+}
+
+func AskPaymentNoteMsg() string {
+	return "<b>📝 Введите примечание к оплате</b>"
+}
+
+func PaymentErrorMsg() string {
+	return "<b>❌ Не удалось сохранить оплату. Попробуйте позже</b>"
+}
+
+func PaymentAddedMsg(orderID int, amount float32) string {
+	return fmt.Sprintf("<b>✔️ Оплата %s₽ по заказу №%d сохранена</b>", FormatRUB(amount), orderID)
+}
+
+func PaymentExpiredMsg() string {
+	return "<b>⌛ Оплата не сохранена из-за неактивности</b>"
+}
+
+func OrderEditExpiredMsg() string {
+	return "<b>⌛ Редактирование заказа прервано из-за неактивности, изменения не сохранены</b>"
+}
+
+func SessionExpiredMsg() string {
+	return "<b>⌛ Сессия устарела, вызовите команду заново</b>"
+}
+
 func NewOrderCancelledMsg() string {
 	return "<b>❌ Создание заказа отменено</b>"
 }
@@ -178,18 +571,62 @@ func NewOrderCreatedMsg() string {
 	return "<b>✔️ Заказ успешно создан</b>"
 }
 
-func OrderViewMsg(data *order.ResponseOrder) string {
+func NewOrderAnnouncementMsg(clientName, printType string, author *models.User) string {
+	return fmt.Sprintf("<b>📣 Новый заказ: %s, %s</b>\n\n%s", clientName, printType, getMentionStr(author))
+}
+
+func OrderStatusAnnouncementMsg(data *order.ResponseOrder, author *models.User) string {
+	return fmt.Sprintf("<b>📣 Заказ №%d (%s): %s</b>\n\n%s", data.ID, data.ClientName, getStatusStr(data.Status), getMentionStr(author))
+}
+
+func OrderRestoredAnnouncementMsg(data *order.ResponseOrder, author *models.User) string {
+	return fmt.Sprintf("<b>📣 Заказ №%d (%s) восстановлен</b>\n\n%s", data.ID, data.ClientName, getMentionStr(author))
+}
+
+func OrderViewMsg(data *order.ResponseOrder, viewer *user.User) string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("<b>Заказ №%d от %s</b>", data.ID, data.CreatedAt.Format("2006-01-02")))
 	sb.WriteString(breakLine(2))
 	sb.WriteString(fmt.Sprintf("<b>Статус: %s</b>", getStatusStr(data.Status)))
+	if changedAt, ok := data.StatusChangedAt[data.Status]; ok {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<i>с %s</i>", changedAt.Local().Format("02.01.2006 15:04")))
+	}
 	sb.WriteString(breakLine(2))
 	sb.WriteString(fmt.Sprintf("<b>📝 Тип печати: %s</b>", data.PrintType))
 	sb.WriteString(breakLine(2))
 	sb.WriteString(fmt.Sprintf("<b>👤 Клиент: %s</b>", data.ClientName))
-	sb.WriteString(breakLine(2))
-	costStr := FormatRUB(data.Cost)
-	sb.WriteString(fmt.Sprintf("<b>💲 Стоимость заказа %s₽</b>", costStr))
+	if len(data.Items) > 0 {
+		sb.WriteString(breakLine(2))
+		sb.WriteString("<b>🧾 Позиции:</b>")
+		sb.WriteString(breakLine(1))
+		writeItems(&sb, data.Items, viewer.Can(user.PermViewCosts))
+	}
+	if viewer.Can(user.PermViewCosts) {
+		if len(data.Items) == 0 {
+			costStr := FormatRUB(data.Cost)
+			sb.WriteString(breakLine(2))
+			sb.WriteString(fmt.Sprintf("<b>💲 Стоимость заказа %s₽</b>", costStr))
+		}
+		if len(data.Payments) > 0 {
+			sb.WriteString(breakLine(1))
+			sb.WriteString(fmt.Sprintf("<b>💳 Оплачено %s₽, осталось %s₽</b>", FormatRUB(data.Paid), FormatRUB(data.Outstanding)))
+			for _, payment := range data.Payments {
+				sb.WriteString(breakLine(1))
+				sb.WriteString(fmt.Sprintf("%s — %s₽, %s", payment.PaidAt.Local().Format("02.01.2006"), FormatRUB(payment.Amount), getPaymentMethodStr(payment.Method)))
+				if payment.Note != "" {
+					sb.WriteString(fmt.Sprintf(" <i>(%s)</i>", html.EscapeString(payment.Note)))
+				}
+			}
+		}
+	}
+	if data.DueAt != nil {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>⏰ Срок: %s</b>", data.DueAt.Local().Format("02.01.2006")))
+		if !data.Status.IsDone() && data.DueAt.Before(time.Now()) {
+			sb.WriteString(" <i>(просрочен)</i>")
+		}
+	}
 	if len(data.Comments) > 0 {
 		sb.WriteString(breakLine(2))
 		sb.WriteString("<b>💬 Комментарии к заказу:</b>")
@@ -220,11 +657,129 @@ func OrderViewMsg(data *order.ResponseOrder) string {
 		for _, file := range data.Files {
 			sb.WriteString(breakLine(1))
 			sb.WriteString(fmt.Sprintf("<b>%s</b>", file.Name))
+			if file.Analysis != nil {
+				sb.WriteString(breakLine(1))
+				sb.WriteString(getAnalysisStr(file.Analysis))
+			}
+			if file.GCode != nil {
+				sb.WriteString(breakLine(1))
+				sb.WriteString(getGCodeStr(file.GCode))
+			}
+		}
+		if printTime, length, weight := data.PrintEstimate(); printTime > 0 {
+			sb.WriteString(breakLine(2))
+			sb.WriteString(fmt.Sprintf("<b>⏱ Печать всего: %s, %s</b>", formatDuration(printTime), getFilamentStr(length, weight)))
+		}
+	}
+	if data.ArchivePath != "" {
+		sb.WriteString(breakLine(2))
+		if data.UnarchivedAt != nil {
+			sb.WriteString("<i>📦 Файлы заказа временно распакованы из архива</i>")
+		} else {
+			sb.WriteString("<i>📦 Файлы заказа перемещены в архив</i>")
 		}
 	}
 	return sb.String()
 }
 
+func OrderFilterMsg(filter *order.OrderFilter, clientName string, viewer *user.User) string {
+	var sb strings.Builder
+	sb.WriteString("<b>🔎 Фильтры заказов</b>")
+	sb.WriteString(breakLine(2))
+	if len(filter.Statuses) == 0 {
+		sb.WriteString("<b>📋 Статусы:</b> активные")
+	} else {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = getStatusStr(status)
+		}
+		sb.WriteString(fmt.Sprintf("<b>📋 Статусы:</b> %s", strings.Join(statuses, ", ")))
+	}
+	sb.WriteString(breakLine(1))
+	if len(filter.PrintTypes) == 0 {
+		sb.WriteString("<b>📝 Тип печати:</b> любой")
+	} else {
+		sb.WriteString(fmt.Sprintf("<b>📝 Тип печати:</b> %s", strings.Join(filter.PrintTypes, ", ")))
+	}
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>📅 Создан:</b> %s", getDateRangeStr(filter.CreatedFrom, filter.CreatedTo)))
+	if viewer.Can(user.PermViewCosts) {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<b>💲 Стоимость:</b> %s", getCostRangeStr(filter.CostMin, filter.CostMax)))
+	}
+	sb.WriteString(breakLine(1))
+	if filter.ClientID == nil {
+		sb.WriteString("<b>👤 Клиент:</b> любой")
+	} else {
+		sb.WriteString(fmt.Sprintf("<b>👤 Клиент:</b> %s", html.EscapeString(clientName)))
+	}
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>📄 %s</b>", getHasFilesStr(filter.HasFiles)))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>↕️ %s</b>", getSortStr(filter.Sort)))
+	return sb.String()
+}
+
+func AskFilterDatesMsg() string {
+	return "<b>📅 Введите даты в формате ДД.ММ.ГГГГ-ДД.ММ.ГГГГ</b>\n\n<i>Одну из дат можно не указывать, одна дата без тире — заказы за этот день</i>"
+}
+
+func DateRangeValidationErrorMsg() string {
+	return "❌ Не удалось разобрать даты, пример: 01.03.2025-31.03.2025"
+}
+
+func AskFilterCostMsg() string {
+	return "<b>💲 Введите диапазон стоимости в рублях, например 1000-5000</b>\n\n<i>Одну из границ можно не указывать: 1000- или -5000</i>"
+}
+
+func CostRangeValidationErrorMsg() string {
+	return "❌ Не удалось разобрать диапазон, пример: 1000-5000"
+}
+
+func AskFilterClientMsg() string {
+	return "<b>👤 Выберите клиента или отправьте имя, телефон или почту для поиска</b>"
+}
+
+func NoFilteredOrdersMsg() string {
+	return "<b>🤷 Нет заказов, подходящих под фильтры</b>"
+}
+
+func UnarchiveErrorMsg() string {
+	return "<b>❌ Не удалось распаковать файлы заказа из архива. Попробуйте позже</b>"
+}
+
+func UnarchivedMsg() string {
+	return "<b>✔️ Файлы заказа распакованы из архива</b>"
+}
+
+func OrderHistoryLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить историю заказа. Попробуйте позже</b>"
+}
+
+func OrderHistoryMsg(orderID int, events []order.OrderEvent) string {
+	const maxEvents = 30
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>📜 История заказа №%d</b>", orderID))
+	if len(events) == 0 {
+		sb.WriteString(breakLine(2))
+		sb.WriteString("<i>Изменений пока нет</i>")
+		return sb.String()
+	}
+	if len(events) > maxEvents {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<i>Показаны последние %d изменений из %d</i>", maxEvents, len(events)))
+		events = events[len(events)-maxEvents:]
+	}
+	for _, event := range events {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>%s</b> — %s", event.CreatedAt.Local().Format("02.01.2006 15:04"), getEventAuthorStr(event.UserID)))
+		sb.WriteString(breakLine(1))
+		sb.WriteString(getEventStr(event))
+	}
+	return sb.String()
+}
+
 func EmptyOrderListMsg() string {
 	return "<b>🔍 У вас пока нет активных заказов</b>"
 }
@@ -233,6 +788,10 @@ func PendingDownloadMsg() string {
 	return "<b>Пожалуйста, дождитесь загрузки файлов</b>"
 }
 
+func PendingUnarchiveMsg() string {
+	return "<b>Пожалуйста, дождитесь распаковки архива</b>"
+}
+
 func PendingUploadMsg() string {
 	return "<b>Пожалуйста, дождитесь отправки файлов</b>"
 }
@@ -245,6 +804,163 @@ func FileTooBigMsg(filename string) string {
 	return fmt.Sprintf("<b>❌ Файл %s слишком большой для загрузки. Максимальный размер - 2 ГБ</b>", filename)
 }
 
+func DedupDisabledMsg() string {
+	return "<b>ℹ️ Дедупликация файлов отключена</b>"
+}
+
+func StorageReportErrorMsg() string {
+	return "<b>❌ Не удалось собрать статистику хранилища. Попробуйте позже</b>"
+}
+
+func StorageReportMsg(report *file.DedupReport) string {
+	var sb strings.Builder
+	sb.WriteString("<b>💾 Хранилище файлов</b>")
+	sb.WriteString(breakLine(2))
+	sb.WriteString(fmt.Sprintf("<b>Уникальных файлов: %d</b>", report.Blobs))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>Ссылок из заказов: %d</b>", report.References))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>Занято на диске: %s</b>", FormatBytes(report.StoredBytes)))
+	sb.WriteString(breakLine(1))
+	sb.WriteString(fmt.Sprintf("<b>Сэкономлено: %s</b>", FormatBytes(report.SavedBytes)))
+	return sb.String()
+}
+
+func TrashLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить корзину. Попробуйте позже</b>"
+}
+
+func EmptyTrashMsg() string {
+	return "<b>🗑 Корзина пуста</b>"
+}
+
+func SearchUsageMsg() string {
+	return "<b>Использование:</b> /search &lt;запрос&gt;\n\nПоиск идёт по имени клиента, комментариям, контактам, ссылкам и названиям файлов"
+}
+
+func SearchErrorMsg() string {
+	return "<b>❌ Не удалось выполнить поиск. Попробуйте позже</b>"
+}
+
+func NoSearchResultsMsg(query string) string {
+	return fmt.Sprintf("<b>🔍 По запросу «%s» ничего не найдено</b>", html.EscapeString(query))
+}
+
+func SearchResultsMsg(query string, total, offset int, orders []order.OrderSummary) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>🔍 Найдено заказов по запросу «%s»: %d</b>", html.EscapeString(query), total))
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<i>Нажмите на заказ, чтобы открыть его</i>")
+	for i, o := range orders {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>%d. Заказ №%d от %s</b>", offset+i+1, o.ID, o.CreatedAt.Local().Format("02.01.2006")))
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("%s, %s — %s", o.ClientName, o.PrintType, getStatusStr(o.Status)))
+		if o.PrintTime > 0 {
+			sb.WriteString(fmt.Sprintf(", ⏱ %s", formatDuration(o.PrintTime)))
+		}
+	}
+	return sb.String()
+}
+
+func ArchiveLoadErrorMsg() string {
+	return "<b>❌ Не удалось загрузить архив заказов. Попробуйте позже</b>"
+}
+
+func EmptyArchiveMsg() string {
+	return "<b>🗄 В архиве пока нет закрытых заказов</b>"
+}
+
+func ArchiveMonthsMsg() string {
+	return "<b>🗄 Архив заказов</b>\n\n<i>Выберите месяц, в котором заказ был закрыт или отменён</i>"
+}
+
+func ArchiveOrdersMsg(year int, month time.Month, total, offset int, orders []order.OrderSummary) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>🗄 %s: закрыто заказов %d</b>", getMonthStr(year, month), total))
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<i>Нажмите на заказ, чтобы открыть его</i>")
+	for i, o := range orders {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>%d. Заказ №%d от %s</b>", offset+i+1, o.ID, o.CreatedAt.Local().Format("02.01.2006")))
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("%s, %s — %s", o.ClientName, o.PrintType, getStatusStr(o.Status)))
+		if o.PrintTime > 0 {
+			sb.WriteString(fmt.Sprintf(", ⏱ %s", formatDuration(o.PrintTime)))
+		}
+	}
+	return sb.String()
+}
+
+func DueReminderMsg(o order.OrderSummary) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>⏰ Приближается срок заказа №%d</b>", o.ID))
+	sb.WriteString(breakLine(2))
+	sb.WriteString(fmt.Sprintf("%s, %s — %s", o.ClientName, o.PrintType, getStatusStr(o.Status)))
+	if o.DueAt != nil {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("<b>Срок: %s</b>", o.DueAt.Local().Format("02.01.2006 15:04")))
+	}
+	if o.PrintTime > 0 {
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("⏱ Печать займёт %s", formatDuration(o.PrintTime)))
+	}
+	return sb.String()
+}
+
+func OverdueDigestMsg(orders []order.OrderSummary) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>🔥 Просроченные заказы: %d</b>", len(orders)))
+	for _, o := range orders {
+		sb.WriteString(breakLine(2))
+		dueStr := ""
+		if o.DueAt != nil {
+			dueStr = o.DueAt.Local().Format("02.01.2006")
+		}
+		sb.WriteString(fmt.Sprintf("<b>Заказ №%d, срок %s</b>", o.ID, dueStr))
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("%s, %s — %s", o.ClientName, o.PrintType, getStatusStr(o.Status)))
+		if o.PrintTime > 0 {
+			sb.WriteString(fmt.Sprintf(", ⏱ %s", formatDuration(o.PrintTime)))
+		}
+	}
+	return sb.String()
+}
+
+func PendingCloneMsg() string {
+	return "<b>⏳ Копирую заказ, подождите</b>"
+}
+
+func OrderCloneErrorMsg() string {
+	return "<b>❌ Не удалось скопировать заказ. Попробуйте позже</b>"
+}
+
+func OrderClonedMsg(sourceID, orderID int) string {
+	return fmt.Sprintf("<b>✔️ Заказ №%d создан как копия заказа №%d</b>", orderID, sourceID)
+}
+
+func TrashListMsg(entries []file.TrashEntry) string {
+	var sb strings.Builder
+	sb.WriteString("<b>🗑 Папки в корзине</b>")
+	sb.WriteString(breakLine(1))
+	sb.WriteString("<i>Нажмите на папку, чтобы восстановить её</i>")
+	for i, entry := range entries {
+		sb.WriteString(breakLine(2))
+		sb.WriteString(fmt.Sprintf("<b>%d. %s</b>", i+1, entry.Folder))
+		sb.WriteString(breakLine(1))
+		sb.WriteString(fmt.Sprintf("Перемещена %s", entry.TrashedAt.Local().Format("02.01.2006 15:04")))
+	}
+	return sb.String()
+}
+
+func TrashRestoredMsg(folder string) string {
+	return fmt.Sprintf("<b>✔️ Папка %s восстановлена</b>\n\n<i>Она не будет снова убрана в корзину, даже если у неё нет заказа</i>", folder)
+}
+
+func TrashRestoreErrorMsg() string {
+	return "<b>❌ Не удалось восстановить папку. Возможно, папка с таким именем уже существует</b>"
+}
+
 func breakLine(n int) string {
 	return strings.Repeat("\n", n)
 }
@@ -2,23 +2,147 @@ package presentation
 
 import (
 	"fmt"
+	"html"
 	"math"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
 	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/user"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
 )
 
 func getStatusStr(status order.Status) string {
 	switch status {
-	case order.StatusActive:
-		return "🟡 Активен"
+	case order.StatusNew:
+		return "🆕 Новый"
+	case order.StatusQuoted:
+		return "💬 Расчёт отправлен"
+	case order.StatusAwaitingPrepayment:
+		return "⏳ Ожидает предоплату"
+	case order.StatusQueued:
+		return "📋 В очереди"
+	case order.StatusPrinting:
+		return "🖨 Печатается"
+	case order.StatusPostProcessing:
+		return "🛠 Постобработка"
+	case order.StatusReady:
+		return "📦 Готов к выдаче"
+	case order.StatusDelivered:
+		return "🚚 Выдан"
 	case order.StatusClosed:
 		return "🟢 Закрыт"
+	case order.StatusCancelled:
+		return "⚫️ Отменён"
 	default:
 		return "🔴 Неизвестен"
 	}
 }
 
+func getStatusActionStr(status order.Status) string {
+	switch status {
+	case order.StatusNew:
+		return "🆕 Вернуть в новые"
+	case order.StatusQuoted:
+		return "💬 Расчёт отправлен"
+	case order.StatusAwaitingPrepayment:
+		return "⏳ Ждём предоплату"
+	case order.StatusQueued:
+		return "📋 В очередь"
+	case order.StatusPrinting:
+		return "🖨 На печать"
+	case order.StatusPostProcessing:
+		return "🛠 На постобработку"
+	case order.StatusReady:
+		return "📦 Готов"
+	case order.StatusDelivered:
+		return "🚚 Выдан"
+	case order.StatusClosed:
+		return "📩 Закрыть"
+	case order.StatusCancelled:
+		return "❌ Отменить"
+	default:
+		return string(status)
+	}
+}
+
+func getEventAuthorStr(userID int64) string {
+	if userID == order.SystemUserID {
+		return "🤖 Система"
+	}
+	return fmt.Sprintf("👤 <code>%d</code>", userID)
+}
+
+func getMentionStr(u *models.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		name = u.Username
+	}
+	return fmt.Sprintf("👤 <a href=\"tg://user?id=%d\">%s</a>", u.ID, html.EscapeString(name))
+}
+
+func getEventStr(event order.OrderEvent) string {
+	switch event.Type {
+	case order.EventCreated:
+		return "Заказ создан"
+	case order.EventStatusChanged:
+		return fmt.Sprintf("Статус: %s → %s", getStatusStr(order.Status(event.OldValue)), getStatusStr(order.Status(event.NewValue)))
+	case order.EventEdited:
+		return fmt.Sprintf("%s: %s → %s", getEventFieldStr(event.Field), orEmptyStr(event.OldValue), orEmptyStr(event.NewValue))
+	case order.EventFileAdded:
+		return fmt.Sprintf("Добавлен файл %s", event.NewValue)
+	case order.EventFileRemoved:
+		return fmt.Sprintf("Удалён файл %s", event.OldValue)
+	case order.EventFileUpdated:
+		return fmt.Sprintf("Изменён файл %s", event.NewValue)
+	case order.EventPaymentAdded:
+		return fmt.Sprintf("Оплата %s₽, %s", event.NewValue, getPaymentMethodStr(order.PaymentMethod(event.Field)))
+	default:
+		return string(event.Type)
+	}
+}
+
+func getPaymentMethodStr(method order.PaymentMethod) string {
+	switch method {
+	case order.PaymentCash:
+		return "💵 Наличные"
+	case order.PaymentCard:
+		return "💳 Карта"
+	case order.PaymentTransfer:
+		return "🏦 Перевод"
+	default:
+		return "Другое"
+	}
+}
+
+func getEventFieldStr(field string) string {
+	switch field {
+	case "print_type":
+		return "Тип печати"
+	case "client_name":
+		return "Клиент"
+	case "cost":
+		return "Стоимость"
+	case "comments":
+		return "Комментарии"
+	case "items":
+		return "Позиции"
+	default:
+		return field
+	}
+}
+
+func orEmptyStr(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "—"
+	}
+	return value
+}
+
 func FormatRUB(amount float32) string {
 	rounded := math.Round(float64(amount)*100) / 100
 
@@ -63,6 +187,28 @@ func formatWithThousandsSeparator(n int64) string {
 	return result.String()
 }
 
+func truncate(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+func FormatBytes(n uint64) string {
+	units := []string{"Б", "КБ", "МБ", "ГБ", "ТБ"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
 func ParseRUB(input string) (float32, error) {
 	s := strings.TrimSpace(input)
 	s = strings.ReplaceAll(s, "₽", "")
@@ -75,3 +221,333 @@ func ParseRUB(input string) (float32, error) {
 	}
 	return float32(val), nil
 }
+
+// ParseOrderItem reads "количество материал [цвет] [слой мм] [заполнение%] цена",
+// the layer height and infill are told apart from the color by their form. A
+// question mark in place of the price asks for the item to be quoted
+func ParseOrderItem(input string) (order.OrderItem, bool, error) {
+	fields := strings.Fields(input)
+	if len(fields) < 3 {
+		return order.OrderItem{}, false, fmt.Errorf("too few item fields")
+	}
+
+	quantityStr := strings.TrimRight(strings.ToLower(fields[0]), "xх×шт.")
+	quantity, err := strconv.Atoi(quantityStr)
+	if err != nil || quantity <= 0 {
+		return order.OrderItem{}, false, fmt.Errorf("invalid item quantity: %q", fields[0])
+	}
+	var price float32
+	quoted := fields[len(fields)-1] == "?"
+	if !quoted {
+		price, err = ParseRUB(fields[len(fields)-1])
+		if err != nil || price < 0 {
+			return order.OrderItem{}, false, fmt.Errorf("invalid item price: %q", fields[len(fields)-1])
+		}
+	}
+
+	item := order.OrderItem{Quantity: quantity, UnitPrice: price}
+	var words []string
+	for _, field := range fields[1 : len(fields)-1] {
+		if infillStr, ok := strings.CutSuffix(field, "%"); ok {
+			infill, err := strconv.Atoi(infillStr)
+			if err != nil || infill < 0 || infill > 100 {
+				return order.OrderItem{}, false, fmt.Errorf("invalid item infill: %q", field)
+			}
+			item.Infill = &infill
+			continue
+		}
+		// Whole numbers are more likely part of the color than a layer height
+		lower := strings.ToLower(field)
+		layerStr := strings.ReplaceAll(strings.TrimSuffix(strings.TrimSuffix(lower, "мм"), "mm"), ",", ".")
+		isLayer := strings.Contains(layerStr, ".") || layerStr != lower
+		if layer, err := strconv.ParseFloat(layerStr, 32); isLayer && err == nil && layer > 0 && layer <= 2 {
+			layerHeight := float32(layer)
+			item.LayerHeight = &layerHeight
+			continue
+		}
+		words = append(words, field)
+	}
+	if len(words) == 0 {
+		return order.OrderItem{}, false, fmt.Errorf("item material is missing")
+	}
+	item.Material = words[0]
+	item.Color = strings.Join(words[1:], " ")
+	return item, quoted, nil
+}
+
+func getItemStr(item order.OrderItem, withPrice bool) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%d× %s", item.Quantity, html.EscapeString(item.Material)))
+	if item.Color != "" {
+		sb.WriteString(" " + html.EscapeString(item.Color))
+	}
+	if item.LayerHeight != nil {
+		sb.WriteString(fmt.Sprintf(", %s мм", strconv.FormatFloat(float64(*item.LayerHeight), 'f', -1, 32)))
+	}
+	if item.Infill != nil {
+		sb.WriteString(fmt.Sprintf(", %d%%", *item.Infill))
+	}
+	if item.FileName != "" {
+		sb.WriteString(fmt.Sprintf(" — <code>%s</code>", html.EscapeString(item.FileName)))
+	}
+	if withPrice {
+		sb.WriteString(fmt.Sprintf(" — %s₽", FormatRUB(item.Total())))
+	}
+	return sb.String()
+}
+
+// getAnalysisStr shows the model size in millimetres and the volume in cm³,
+// the volume of an open mesh is only an estimate
+func getAnalysisStr(a *model.Analysis) string {
+	size := a.Size()
+	str := fmt.Sprintf("📐 %s × %s × %s мм, %s см³", formatDecimal(size.X), formatDecimal(size.Y), formatDecimal(size.Z), formatDecimal(a.Volume/1000))
+	if !a.Manifold {
+		str += ", ⚠️ сетка не замкнута"
+	}
+	return str
+}
+
+// getGCodeStr shows the print time and the filament of a sliced file, the
+// extruders are only listed for multi material prints
+func getGCodeStr(e *gcode.Estimate) string {
+	str := fmt.Sprintf("⏱ %s, %s", formatDuration(e.PrintTime), getFilamentStr(e.FilamentLength, e.FilamentWeight))
+	if len(e.Extruders) > 1 {
+		extruders := make([]string, len(e.Extruders))
+		for i, extruder := range e.Extruders {
+			extruders[i] = strconv.Itoa(extruder + 1)
+		}
+		str += ", экструдеры " + strings.Join(extruders, ", ")
+	}
+	if e.Slicer != "" {
+		str += fmt.Sprintf(" (%s)", e.Slicer)
+	}
+	if e.Simulated {
+		str += ", ⚠️ время оценено по траектории"
+	}
+	return str
+}
+
+// getFilamentStr shows the filament length in metres and its weight when known
+func getFilamentStr(length, weight float64) string {
+	str := fmt.Sprintf("🧵 %s м", formatDecimal(length/1000))
+	if weight > 0 {
+		str += fmt.Sprintf(", %s г", formatDecimal(weight))
+	}
+	return str
+}
+
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("%d мин", minutes)
+	}
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%d ч", minutes/60)
+	}
+	return fmt.Sprintf("%d ч %d мин", minutes/60, minutes%60)
+}
+
+func formatDecimal(v float64) string {
+	return strings.Replace(strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64), ".", ",", 1)
+}
+
+// ParseDateRange reads "ДД.ММ.ГГГГ-ДД.ММ.ГГГГ" with either side optional, a
+// single date selects that day. The end of the range is exclusive
+func ParseDateRange(input string) (from, to *time.Time, err error) {
+	const layout = "02.01.2006"
+	start, end, isRange := strings.Cut(strings.ReplaceAll(input, " ", ""), "-")
+	if !isRange {
+		end = start
+	}
+	if start == "" && end == "" {
+		return nil, nil, fmt.Errorf("empty date range")
+	}
+	if start != "" {
+		t, err := time.ParseInLocation(layout, start, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse start date: %w", err)
+		}
+		from = &t
+	}
+	if end != "" {
+		t, err := time.ParseInLocation(layout, end, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse end date: %w", err)
+		}
+		t = t.AddDate(0, 0, 1)
+		to = &t
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("start date is after end date")
+	}
+	return from, to, nil
+}
+
+// ParseDueDate reads "ДД.ММ.ГГГГ" or "ДД.ММ" of the nearest such day, the
+// order is due by the end of that day which must not be in the past
+func ParseDueDate(input string, now time.Time) (time.Time, error) {
+	input = strings.TrimSpace(input)
+	t, err := time.ParseInLocation("02.01.2006", input, time.Local)
+	if err != nil {
+		t, err = time.ParseInLocation("02.01", input, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse due date: %w", err)
+		}
+		t = EndOfDay(t.AddDate(now.Year(), 0, 0))
+		if t.Before(now) {
+			t = t.AddDate(1, 0, 0)
+		}
+	}
+	t = EndOfDay(t)
+	if t.Before(now) {
+		return time.Time{}, fmt.Errorf("due date is in the past")
+	}
+	return t, nil
+}
+
+// ParsePaymentDate reads "ДД.ММ.ГГГГ" or "ДД.ММ" of the latest such day,
+// payments can't be registered in advance
+func ParsePaymentDate(input string, now time.Time) (time.Time, error) {
+	input = strings.TrimSpace(input)
+	t, err := time.ParseInLocation("02.01.2006", input, time.Local)
+	if err != nil {
+		t, err = time.ParseInLocation("02.01", input, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse payment date: %w", err)
+		}
+		t = t.AddDate(now.Year(), 0, 0)
+		if t.After(now) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	if t.After(now) {
+		return time.Time{}, fmt.Errorf("payment date is in the future")
+	}
+	return t, nil
+}
+
+func EndOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, t.Location())
+}
+
+// ParseCostRange reads "от-до" in rubles with either side optional
+func ParseCostRange(input string) (minCost, maxCost *float32, err error) {
+	start, end, isRange := strings.Cut(input, "-")
+	if !isRange || strings.TrimSpace(start) == "" && strings.TrimSpace(end) == "" {
+		return nil, nil, fmt.Errorf("invalid cost range")
+	}
+	if strings.TrimSpace(start) != "" {
+		v, err := ParseRUB(start)
+		if err != nil {
+			return nil, nil, err
+		}
+		minCost = &v
+	}
+	if strings.TrimSpace(end) != "" {
+		v, err := ParseRUB(end)
+		if err != nil {
+			return nil, nil, err
+		}
+		maxCost = &v
+	}
+	if minCost != nil && maxCost != nil && *minCost > *maxCost {
+		return nil, nil, fmt.Errorf("min cost is greater than max cost")
+	}
+	return minCost, maxCost, nil
+}
+
+func checkedStr(checked bool) string {
+	if checked {
+		return "✅ "
+	}
+	return ""
+}
+
+func getHasFilesStr(hasFiles *bool) string {
+	switch {
+	case hasFiles == nil:
+		return "Файлы: неважно"
+	case *hasFiles:
+		return "С файлами"
+	default:
+		return "Без файлов"
+	}
+}
+
+func getSortStr(sort order.SortOrder) string {
+	switch sort {
+	case order.SortCreatedDesc:
+		return "Сначала новые"
+	case order.SortCostDesc:
+		return "Сначала дорогие"
+	case order.SortCostAsc:
+		return "Сначала дешёвые"
+	case order.SortDueAsc:
+		return "Сначала срочные"
+	default:
+		return "Сначала старые"
+	}
+}
+
+func getDateRangeStr(from, to *time.Time) string {
+	const layout = "02.01.2006"
+	switch {
+	case from == nil && to == nil:
+		return "за всё время"
+	case to == nil:
+		return "с " + from.Format(layout)
+	case from == nil:
+		return "по " + to.AddDate(0, 0, -1).Format(layout)
+	default:
+		return fmt.Sprintf("с %s по %s", from.Format(layout), to.AddDate(0, 0, -1).Format(layout))
+	}
+}
+
+func getCostRangeStr(minCost, maxCost *float32) string {
+	switch {
+	case minCost == nil && maxCost == nil:
+		return "любая"
+	case maxCost == nil:
+		return fmt.Sprintf("от %s₽", FormatRUB(*minCost))
+	case minCost == nil:
+		return fmt.Sprintf("до %s₽", FormatRUB(*maxCost))
+	default:
+		return fmt.Sprintf("от %s₽ до %s₽", FormatRUB(*minCost), FormatRUB(*maxCost))
+	}
+}
+
+var monthNames = [...]string{
+	"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь",
+	"Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь",
+}
+
+func getMonthStr(year int, month time.Month) string {
+	return fmt.Sprintf("%s %d", monthNames[month-1], year)
+}
+
+func getUnitStr(unit catalog.Unit) string {
+	if unit == catalog.UnitMilliliter {
+		return "мл"
+	}
+	return "г"
+}
+
+func disabledStr(active bool) string {
+	if active {
+		return ""
+	}
+	return " <i>(отключено)</i>"
+}
+
+func getRoleStr(role user.Role) string {
+	switch role {
+	case user.RoleOwner:
+		return "владелец"
+	case user.RoleOperator:
+		return "оператор"
+	case user.RoleViewer:
+		return "наблюдатель"
+	default:
+		return string(role)
+	}
+}
@@ -1,13 +1,21 @@
 package telegram
 
 import (
+	"context"
 	"errors"
 	"log/slog"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
 	fileSvc "print3d-order-bot/internal/file"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
 	orderSvc "print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/quote"
 	"print3d-order-bot/internal/telegram/internal/fsm"
 	"print3d-order-bot/internal/telegram/internal/media"
 	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,17 +24,31 @@ import (
 )
 
 type OrderCreationDeps struct {
-	Router       *fsm.Router
-	Collector    *media.Collector
-	OrderService orderSvc.Service
-	FileService  fileSvc.Service
+	Router         *fsm.Router
+	BotApi         *Bot
+	Collector      *media.Collector
+	OrderService   orderSvc.Service
+	FileService    fileSvc.Service
+	ClientService  client.Service
+	CatalogService catalog.Service
+	QuoteEngine    quote.Engine
 }
 
+const orderDraftTTL = time.Hour
+
 func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 	deps.Router.SetAttachmentHandler(func(ctx *fsm.ConversationContext[fsm.StateData]) error {
 		if ctx.Update.Message == nil {
 			return nil
 		}
+		// Members share links and photos in groups all the time, only forwarded
+		// messages and files start a new order there
+		if _, drafting := ctx.Data.(*fsm.OrderData); !drafting && ctx.ChatID != ctx.UserID && !isOrderSource(ctx.Update.Message) {
+			return nil
+		}
+		if !can(ctx.Ctx, user.PermCreateOrders) {
+			return ctx.SendMessage(presentation.PermissionDeniedMsg(), nil)
+		}
 
 		deps.Collector.ProcessMessage(ctx.Update.Message, func(window *media.Window) {
 			data, ok := ctx.Data.(*fsm.OrderData)
@@ -50,12 +72,8 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 				Contacts: window.Contacts,
 				Links:    window.Links,
 			}
-			ctx.Transition(fsm.StepAwaitingOrderType, newData)
-			if err := ctx.SendMessage(
-				presentation.AskOrderTypeMsg(),
-				presentation.OrderTypeKbd(),
-			); err != nil {
-				return
+			if err := ctx.AdvanceWith(fsm.StepAwaitingOrderType, newData); err != nil {
+				slog.Error("Failed to start order creation", "error", err, "userID", ctx.UserID)
 			}
 		})
 
@@ -63,13 +81,16 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 	})
 
 	fsm.Chain[*fsm.OrderData](deps.Router, "order_creation", fsm.StepAwaitingOrderType).
+		Timeout(orderDraftTTL, presentation.OrderDraftExpiredMsg()).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskOrderTypeMsg(), presentation.OrderTypeKbd()
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
 			}
 			if data == "new_order" {
-				ctx.Transition(fsm.StepAwaitingPrintType, ctx.Data)
-				return ctx.SendMessage(presentation.AskPrintTypeMsg(), presentation.PrintTypeKbd())
+				return ctx.Advance(fsm.StepAwaitingPrintType)
 			}
 
 			ids, err := deps.OrderService.GetActiveOrdersIDs(ctx.Ctx)
@@ -84,21 +105,19 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 			ctx.Data.OrdersIDs = ids
 			ctx.Data.CurrentIdx = 0
 
-			order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ids[0])
-			if err != nil {
-				return ctx.Complete(presentation.OrderLoadErrorMsg())
-			}
-
-			ctx.Transition(fsm.StepAwaitingOrderSelectSliderAction, ctx.Data)
 			if err := ctx.SendMessage(presentation.AskOrderSelectionMsg(), nil); err != nil {
 				return err
 			}
-			return ctx.SendMessage(
-				presentation.OrderViewMsg(order),
-				presentation.OrderSliderSelectorKbd(len(ids), 0),
-			)
+			return ctx.Advance(fsm.StepAwaitingOrderSelectSliderAction)
 		}).
 		Then(fsm.StepAwaitingOrderSelectSliderAction).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+			if err != nil {
+				return presentation.OrderLoadErrorMsg(), nil
+			}
+			return presentation.OrderViewMsg(order, user.FromContext(ctx.Ctx)), presentation.OrderSliderSelectorKbd(len(ctx.Data.OrdersIDs), ctx.Data.CurrentIdx)
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
@@ -126,25 +145,117 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 
 		// Print type
 		Then(fsm.StepAwaitingPrintType).
+		BackTo(fsm.StepAwaitingOrderType).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return printTypePicker(ctx.Ctx, deps.CatalogService)
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
 			}
-			ctx.Data.PrintType = strings.ToUpper(data)
-			ctx.Transition(fsm.StepAwaitingClientName, ctx.Data)
-			return ctx.SendMessage(presentation.AskClientNameMsg(), nil)
+			code, ok := strings.CutPrefix(data, presentation.PrintTypeCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			ctx.Data.PrintType = code
+			return ctx.Advance(fsm.StepAwaitingClient)
+		}).
+
+		// Client picker
+		Then(fsm.StepAwaitingClient).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return clientPicker(ctx, deps)
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
+			clients, err := deps.ClientService.SearchClients(ctx.Ctx, text)
+			if err != nil {
+				return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+			}
+			return ctx.SendMessage(presentation.ClientSearchResultMsg(text, len(clients)), fsm.WithBackButton(presentation.ClientPickerKbd(clients)))
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "new_client" {
+				return ctx.Advance(fsm.StepAwaitingClientName)
+			}
+
+			idStr, ok := strings.CutPrefix(data, presentation.ClientCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return nil
+			}
+			picked, err := deps.ClientService.GetClient(ctx.Ctx, id)
+			if err != nil {
+				return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+			}
+			ctx.Data.ClientID = picked.ID
+			ctx.Data.ClientName = picked.Name
+			return ctx.Advance(fsm.StepAwaitingOrderItems)
 		}).
 
 		// Client name
 		Then(fsm.StepAwaitingClientName).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskClientNameMsg(), nil
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
+			ctx.Data.ClientID = 0
 			ctx.Data.ClientName = strings.TrimSpace(text)
-			ctx.Transition(fsm.StepAwaitingOrderCost, ctx.Data)
-			return ctx.SendMessage(presentation.AskOrderCostMsg(), nil)
+			return ctx.Advance(fsm.StepAwaitingOrderItems)
 		}).
 
-		// Order cost
+		// Order items
+		Then(fsm.StepAwaitingOrderItems).
+		BackTo(fsm.StepAwaitingClient).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return draftItemsList(ctx.Data)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "done" {
+				if len(ctx.Data.Items) == 0 {
+					analyzeDraftFiles(ctx, deps)
+					return ctx.Advance(fsm.StepAwaitingOrderCost)
+				}
+				ctx.Data.Cost = orderSvc.ItemsTotal(ctx.Data.Items)
+				return ctx.Advance(fsm.StepAwaitingOrderDueDate)
+			}
+			return handleItemsAction(ctx, &ctx.Data.ItemsDraft, draftFileNames(ctx.Data), data, fsm.StepAwaitingOrderItemDetails, func() (string, *models.InlineKeyboardMarkup) {
+				text, markup := draftItemsList(ctx.Data)
+				return text, fsm.WithBackButton(markup)
+			})
+		}).
+
+		// Item details
+		Then(fsm.StepAwaitingOrderItemDetails).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return itemDetailsPrompt(ctx.Ctx, deps.CatalogService, ctx.Data.PrintType, ctx.Data.PendingFile), nil
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
+			return handleItemDetails(ctx, deps.CatalogService, &ctx.Data.ItemsDraft, text, fsm.StepAwaitingOrderItems, func(item orderSvc.OrderItem) (float32, bool) {
+				if item.FileName == "" {
+					return 0, false
+				}
+				analyzeDraftFiles(ctx, deps)
+				return quoteItem(ctx.Ctx, deps.CatalogService, deps.QuoteEngine, ctx.Data.PrintType, item,
+					ctx.Data.Analyses[item.FileName], ctx.Data.Estimates[item.FileName])
+			})
+		}).
+
+		// Order cost, only asked for orders without items. A quote is proposed
+		// when the draft has models or sliced files, typing a cost overrides it
 		Then(fsm.StepAwaitingOrderCost).
+		BackTo(fsm.StepAwaitingOrderItems).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return costPrompt(ctx, deps)
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
 			cost, err := presentation.ParseRUB(text)
 			if err != nil {
@@ -152,24 +263,89 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 			}
 
 			ctx.Data.Cost = cost
-			ctx.Transition(fsm.StepAwaitingOrderComments, ctx.Data)
-			return ctx.SendMessage(
-				presentation.AskOrderCommentsMsg(),
-				presentation.SkipKbd(),
-			)
+			return ctx.Advance(fsm.StepAwaitingOrderDueDate)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "accept_quote" {
+				q, _, _, err := draftQuote(ctx, deps)
+				if err != nil {
+					return ctx.SendMessage(presentation.AskOrderCostMsg(), nil)
+				}
+				ctx.Data.Cost = q.Total
+				return ctx.Advance(fsm.StepAwaitingOrderDueDate)
+			}
+
+			idStr, ok := strings.CutPrefix(data, presentation.QuoteMaterialCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return nil
+			}
+			ctx.Data.QuoteMaterialID = id
+			ctx.Transition(ctx.Step, ctx.Data)
+
+			text, markup := costPrompt(ctx, deps)
+			_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+				ChatID:      ctx.ChatID,
+				MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+				Text:        text,
+				ReplyMarkup: fsm.WithBackButton(markup),
+				ParseMode:   models.ParseModeHTML,
+			})
+			return err
+		}).
+
+		// Order due date
+		Then(fsm.StepAwaitingOrderDueDate).
+		BackTo(fsm.StepAwaitingOrderItems).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskDueDateMsg(), presentation.DueDateKbd()
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
+			dueAt, err := presentation.ParseDueDate(text, time.Now())
+			if err != nil {
+				return ctx.SendMessage(presentation.DueDateValidationErrorMsg(), nil)
+			}
+
+			ctx.Data.DueAt = &dueAt
+			return ctx.Advance(fsm.StepAwaitingOrderComments)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "skip" {
+				ctx.Data.DueAt = nil
+				return ctx.Advance(fsm.StepAwaitingOrderComments)
+			}
+			daysStr, ok := strings.CutPrefix(data, presentation.DueDateCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			days, err := strconv.Atoi(daysStr)
+			if err != nil {
+				return nil
+			}
+
+			dueAt := presentation.EndOfDay(time.Now().AddDate(0, 0, days))
+			ctx.Data.DueAt = &dueAt
+			return ctx.Advance(fsm.StepAwaitingOrderComments)
 		}).
 
 		// Order comments
 		Then(fsm.StepAwaitingOrderComments).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskOrderCommentsMsg(), presentation.SkipKbd()
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderData], text string) error {
 			comments := strings.TrimSpace(text)
 			ctx.Data.Comments = []string{comments}
-
-			ctx.Transition(fsm.StepAwaitingNewOrderConfirmation, ctx.Data)
-			return ctx.SendMessage(
-				presentation.NewOrderPreviewMsg(ctx.Data),
-				presentation.YesNoKbd(),
-			)
+			return ctx.Advance(fsm.StepAwaitingNewOrderConfirmation)
 		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
@@ -177,17 +353,16 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 			}
 			if data == "skip" {
 				ctx.Data.Comments = make([]string, 0)
-				ctx.Transition(fsm.StepAwaitingNewOrderConfirmation, ctx.Data)
-				return ctx.SendMessage(
-					presentation.NewOrderPreviewMsg(ctx.Data),
-					presentation.YesNoKbd(),
-				)
+				return ctx.Advance(fsm.StepAwaitingNewOrderConfirmation)
 			}
 			return nil
 		}).
 
 		// New order confirmation
 		Then(fsm.StepAwaitingNewOrderConfirmation).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.NewOrderPreviewMsg(ctx.Data), presentation.YesNoKbd()
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
@@ -200,6 +375,137 @@ func SetupOrderCreationFlow(deps *OrderCreationDeps) {
 		})
 }
 
+func draftItemsList(data *fsm.OrderData) (string, *models.InlineKeyboardMarkup) {
+	return presentation.OrderItemsMsg(data.Items), presentation.OrderItemsKbd(draftFileNames(data), data.Items, "➡️ Далее")
+}
+
+func draftFileNames(data *fsm.OrderData) []string {
+	names := make([]string, len(data.Files))
+	for i, f := range data.Files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// analyzeDraftFiles downloads the draft models and sliced files that weren't
+// analyzed yet for the quote. The files are saved only once the order is
+// created, from the downloads the file service keeps until then
+func analyzeDraftFiles(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) {
+	var meshes, sliced []fileSvc.RequestFile
+	for _, f := range ctx.Data.Files {
+		file := fileSvc.RequestFile{
+			Name:     f.Name,
+			Size:     f.Size,
+			TGFileID: f.TGFileID,
+		}
+		if _, done := ctx.Data.Analyses[f.Name]; !done && model.Supported(f.Name) {
+			meshes = append(meshes, file)
+		}
+		if _, done := ctx.Data.Estimates[f.Name]; !done && gcode.Supported(f.Name) {
+			sliced = append(sliced, file)
+		}
+	}
+	if len(meshes) == 0 && len(sliced) == 0 {
+		return
+	}
+
+	deps.Router.Freeze(ctx.Key(), presentation.PendingAnalysisMsg())
+	defer deps.Router.Unfreeze(ctx.Key())
+	_ = ctx.SendMessage(presentation.PendingAnalysisMsg(), nil)
+
+	if len(meshes) > 0 {
+		analyses := deps.FileService.AnalyzeFiles(ctx.Ctx, meshes)
+		if ctx.Data.Analyses == nil {
+			ctx.Data.Analyses = make(map[string]*model.Analysis)
+		}
+		for _, f := range meshes {
+			ctx.Data.Analyses[f.Name] = analyses[f.Name]
+		}
+	}
+	if len(sliced) > 0 {
+		estimates := deps.FileService.EstimateFiles(ctx.Ctx, sliced)
+		if ctx.Data.Estimates == nil {
+			ctx.Data.Estimates = make(map[string]*gcode.Estimate)
+		}
+		for _, f := range sliced {
+			ctx.Data.Estimates[f.Name] = estimates[f.Name]
+		}
+	}
+}
+
+// draftQuote prices the draft models and sliced files in the picked material,
+// the first material of the print type is used until another one is picked
+func draftQuote(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) (*quote.Quote, []catalog.Material, catalog.Material, error) {
+	c, err := deps.CatalogService.GetCatalog(ctx.Ctx, false)
+	if err != nil {
+		return nil, nil, catalog.Material{}, err
+	}
+	materials := c.MaterialsFor(ctx.Data.PrintType)
+	if len(materials) == 0 {
+		return nil, nil, catalog.Material{}, quote.ErrNothingToQuote
+	}
+	material := materials[0]
+	for _, m := range materials {
+		if m.ID == ctx.Data.QuoteMaterialID {
+			material = m
+		}
+	}
+	unit := catalog.UnitGram
+	if t, ok := c.Technology(material.Technology); ok {
+		unit = t.Unit
+	}
+
+	var parts []quote.Part
+	for _, f := range ctx.Data.Files {
+		analysis, analyzed := ctx.Data.Analyses[f.Name]
+		estimate, estimated := ctx.Data.Estimates[f.Name]
+		if analyzed || estimated {
+			parts = append(parts, quote.Part{Name: f.Name, Analysis: analysis, GCode: estimate, Quantity: 1})
+		}
+	}
+	q, err := deps.QuoteEngine.Quote(quote.Request{Unit: unit, Material: material, Parts: parts})
+	return q, materials, material, err
+}
+
+func costPrompt(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) (string, *models.InlineKeyboardMarkup) {
+	q, materials, material, err := draftQuote(ctx, deps)
+	if err != nil {
+		return presentation.AskOrderCostMsg(), nil
+	}
+	return presentation.OrderQuoteMsg(q, material), presentation.OrderQuoteKbd(q.Total, materials, material.ID)
+}
+
+// printTypePicker offers the active technologies of the catalog
+func printTypePicker(ctx context.Context, catalogService catalog.Service) (string, *models.InlineKeyboardMarkup) {
+	c, err := catalogService.GetCatalog(ctx, false)
+	if err != nil {
+		return presentation.CatalogLoadErrorMsg(), presentation.PrintTypeKbd(nil)
+	}
+	return presentation.AskPrintTypeMsg(), presentation.PrintTypeKbd(c.Technologies)
+}
+
+// clientPicker offers the client matched by the draft contacts first, followed
+// by the ones who ordered most recently
+func clientPicker(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) (string, *models.InlineKeyboardMarkup) {
+	// Matching is only a hint, the picker works without it
+	matched, _ := deps.ClientService.MatchClient(ctx.Ctx, ctx.Data.Contacts)
+	recent, err := deps.ClientService.SearchClients(ctx.Ctx, "")
+	if err != nil {
+		return presentation.ClientsLoadErrorMsg(), presentation.ClientPickerKbd(nil)
+	}
+
+	clients := make([]client.Client, 0, len(recent)+1)
+	if matched != nil {
+		clients = append(clients, *matched)
+	}
+	for _, c := range recent {
+		if matched == nil || c.ID != matched.ID {
+			clients = append(clients, c)
+		}
+	}
+	return presentation.AskClientMsg(matched), presentation.ClientPickerKbd(clients)
+}
+
 func updateOrderSelector(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) error {
 	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
 	if err != nil {
@@ -209,10 +515,10 @@ func updateOrderSelector(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Ord
 	disablePreview := true
 	ctx.Transition(fsm.StepAwaitingOrderSelectSliderAction, ctx.Data)
 	_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
-		ChatID:      ctx.UserID,
+		ChatID:      ctx.ChatID,
 		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
-		Text:        presentation.OrderViewMsg(order),
-		ReplyMarkup: presentation.OrderSliderSelectorKbd(len(ctx.Data.OrdersIDs), ctx.Data.CurrentIdx),
+		Text:        presentation.OrderViewMsg(order, user.FromContext(ctx.Ctx)),
+		ReplyMarkup: fsm.WithBackButton(presentation.OrderSliderSelectorKbd(len(ctx.Data.OrdersIDs), ctx.Data.CurrentIdx)),
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: &disablePreview,
 		},
@@ -222,8 +528,8 @@ func updateOrderSelector(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Ord
 }
 
 func finalizeAddToOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) error {
-	deps.Router.Freeze(ctx.UserID, presentation.PendingDownloadMsg())
-	defer deps.Router.Unfreeze(ctx.UserID)
+	deps.Router.Freeze(ctx.Key(), presentation.PendingDownloadMsg())
+	defer deps.Router.Unfreeze(ctx.Key())
 
 	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
 	if err != nil {
@@ -239,7 +545,7 @@ func finalizeAddToOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Orde
 	}
 
 	msgID, _ := ctx.Bot.SendMessage(ctx.Ctx, &bot.SendMessageParams{
-		ChatID:    ctx.UserID,
+		ChatID:    ctx.ChatID,
 		Text:      presentation.StartingDownloadMsg(len(filesToDownload)),
 		ParseMode: models.ParseModeHTML,
 	})
@@ -256,7 +562,10 @@ func finalizeAddToOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Orde
 
 		orderFiles = append(orderFiles, orderSvc.File{
 			Name:     result.Result.Name,
+			Checksum: result.Result.Checksum,
 			TgFileID: &result.Result.TGFileID,
+			Analysis: result.Result.Analysis,
+			GCode:    result.Result.GCode,
 		})
 
 		if err := ctx.EditMessageText(msgID.ID, presentation.DownloadProgressMsg(result.Result.Name, result.Index, result.Total)); err != nil {
@@ -268,7 +577,7 @@ func finalizeAddToOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Orde
 		return err
 	}
 
-	if err := deps.OrderService.AddFilesToOrder(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx], orderFiles); err != nil {
+	if err := deps.OrderService.AddFilesToOrder(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx], orderFiles, ctx.UserID); err != nil {
 		return ctx.Complete(presentation.AddFilesToOrderWarningMsg())
 	}
 
@@ -276,11 +585,15 @@ func finalizeAddToOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *Orde
 }
 
 func finalizeNewOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) error {
-	deps.Router.Freeze(ctx.UserID, presentation.PendingDownloadMsg())
-	defer deps.Router.Unfreeze(ctx.UserID)
+	deps.Router.Freeze(ctx.Key(), presentation.PendingDownloadMsg())
+	defer deps.Router.Unfreeze(ctx.Key())
 
 	createdAt := time.Now()
-	folderPath := CreateFolderPath(createdAt, ctx.Data.ClientName, strings.Join(ctx.Data.Comments, " "), ctx.Data.PrintType)
+	folderPath, err := deps.FileService.ReserveFolder(CreateFolderPath(createdAt, ctx.Data.ClientName, strings.Join(ctx.Data.Comments, " "), ctx.Data.PrintType))
+	if err != nil {
+		slog.Error("Failed to reserve order folder", "error", err)
+		return ctx.Complete(presentation.OrderCreationErrorMsg())
+	}
 
 	filesToDownload := make([]fileSvc.RequestFile, len(ctx.Data.Files))
 	for i, f := range ctx.Data.Files {
@@ -292,7 +605,7 @@ func finalizeNewOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderC
 	}
 
 	msgID, _ := ctx.Bot.SendMessage(ctx.Ctx, &bot.SendMessageParams{
-		ChatID:    ctx.UserID,
+		ChatID:    ctx.ChatID,
 		Text:      presentation.StartingDownloadMsg(len(filesToDownload)),
 		ParseMode: models.ParseModeHTML,
 	})
@@ -310,7 +623,10 @@ func finalizeNewOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderC
 
 		orderFiles = append(orderFiles, orderSvc.File{
 			Name:     result.Result.Name,
+			Checksum: result.Result.Checksum,
 			TgFileID: &result.Result.TGFileID,
+			Analysis: result.Result.Analysis,
+			GCode:    result.Result.GCode,
 		})
 
 		if err := ctx.EditMessageText(msgID.ID, presentation.DownloadProgressMsg(result.Result.Name, result.Index, result.Total)); err != nil {
@@ -322,25 +638,57 @@ func finalizeNewOrder(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderC
 		return err
 	}
 
+	clientID, err := resolveOrderClient(ctx, deps)
+	if err != nil {
+		_ = deps.FileService.DeleteFolder(folderPath)
+		return ctx.Complete(presentation.OrderCreationErrorMsg())
+	}
+
 	data := orderSvc.RequestNewOrder{
 		PrintType:  ctx.Data.PrintType,
+		ClientID:   &clientID,
 		ClientName: ctx.Data.ClientName,
 		Cost:       ctx.Data.Cost,
+		DueAt:      ctx.Data.DueAt,
 		Comments:   ctx.Data.Comments,
 		Contacts:   ctx.Data.Contacts,
 		Links:      ctx.Data.Links,
 		CreatedAt:  createdAt,
 		FolderPath: folderPath,
+		Items:      ctx.Data.Items,
 	}
 
-	if err := deps.OrderService.NewOrder(ctx.Ctx, data, orderFiles); err != nil {
+	if _, err := deps.OrderService.NewOrder(ctx.Ctx, data, orderFiles, ctx.UserID); err != nil {
 		_ = deps.FileService.DeleteFolder(folderPath)
 		return ctx.Complete(presentation.OrderCreationErrorMsg())
 	}
+	if !deps.BotApi.isWorkspace(ctx.ChatID) {
+		deps.BotApi.announce(ctx.Ctx, presentation.NewOrderAnnouncementMsg(ctx.Data.ClientName, ctx.Data.PrintType, sender(ctx.Update)))
+	}
 
 	return ctx.Complete(presentation.NewOrderCreatedMsg())
 }
 
+// resolveOrderClient creates the client picked as new, an existing one gets
+// the contacts of the draft so it's matched automatically next time
+func resolveOrderClient(ctx *fsm.ConversationContext[*fsm.OrderData], deps *OrderCreationDeps) (int, error) {
+	if ctx.Data.ClientID == 0 {
+		created, err := deps.ClientService.NewClient(ctx.Ctx, ctx.Data.ClientName, ctx.Data.Contacts)
+		if err != nil {
+			return 0, err
+		}
+		return created.ID, nil
+	}
+
+	// Losing contacts isn't worth failing the order over
+	_ = deps.ClientService.AddContacts(ctx.Ctx, ctx.Data.ClientID, ctx.Data.Contacts)
+	return ctx.Data.ClientID, nil
+}
+
+func isOrderSource(message *models.Message) bool {
+	return message.ForwardOrigin != nil || message.Document != nil
+}
+
 func formatDownloadError(err error) string {
 	var pathPrepErr *fileSvc.ErrPrepareFilepath
 	var downloadErr *fileSvc.ErrDownloadFailed
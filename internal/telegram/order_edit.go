@@ -1,48 +1,65 @@
 package telegram
 
 import (
+	"errors"
+	"print3d-order-bot/internal/catalog"
 	"print3d-order-bot/internal/order"
 	"print3d-order-bot/internal/telegram/internal/fsm"
 	"print3d-order-bot/internal/telegram/internal/presentation"
 	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
 )
 
 type OrderEditFlowDeps struct {
-	Router       *fsm.Router
-	OrderService order.Service
+	Router         *fsm.Router
+	OrderService   order.Service
+	CatalogService catalog.Service
 }
 
+const orderEditTTL = 30 * time.Minute
+
 func SetupOrderEditFlow(deps *OrderEditFlowDeps) {
 	fsm.Chain[*fsm.OrderEditData](deps.Router, "order_edit", fsm.StepAwaitingEditPrintType).
+		Timeout(orderEditTTL, presentation.OrderEditExpiredMsg()).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderEditData]) (string, *models.InlineKeyboardMarkup) {
+			text, kbd := printTypePicker(ctx.Ctx, deps.CatalogService)
+			kbd.InlineKeyboard = append(kbd.InlineKeyboard, presentation.SkipKbd().InlineKeyboard...)
+			return text, kbd
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
 			}
-			if data == "skip" {
-				ctx.Transition(fsm.StepAwaitingClientName, ctx.Data)
-				return ctx.SendMessage(presentation.AskClientNameMsg(), presentation.SkipKbd())
+			if code, ok := strings.CutPrefix(data, presentation.PrintTypeCallbackPrefix); ok {
+				ctx.Data.PrintType = &code
+			} else if data != "skip" {
+				return nil
 			}
-			pType := strings.ToUpper(data)
-			ctx.Data.PrintType = &pType
-			ctx.Transition(fsm.StepAwaitingEditName, ctx.Data)
-			return ctx.SendMessage(presentation.AskClientNameMsg(), presentation.SkipKbd())
+			return ctx.Advance(fsm.StepAwaitingEditName)
 		}).
 		Then(fsm.StepAwaitingEditName).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderEditData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskClientNameMsg(), presentation.SkipKbd()
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], text string) error {
 			ctx.Data.ClientName = &text
-			ctx.Transition(fsm.StepAwaitingEditCost, ctx.Data)
-			return ctx.SendMessage(
-				presentation.AskOrderCostMsg(),
-				presentation.SkipKbd(),
-			)
+			return ctx.Advance(stepAfterEditName(ctx.Data))
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data != "skip" {
+				return nil
+			}
+			return ctx.Advance(stepAfterEditName(ctx.Data))
 		}).
-		OnCallback(fsm.HandleCallbackWithMessage[*fsm.OrderEditData](
-			"skip",
-			fsm.StepAwaitingEditCost,
-			presentation.AskOrderCostMsg(),
-			presentation.SkipKbd(),
-		)).
 		Then(fsm.StepAwaitingEditCost).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderEditData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskOrderCostMsg(), presentation.SkipKbd()
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], text string) error {
 			cost, err := presentation.ParseRUB(text)
 			if err != nil {
@@ -50,26 +67,16 @@ func SetupOrderEditFlow(deps *OrderEditFlowDeps) {
 			}
 
 			ctx.Data.Cost = &cost
-			ctx.Transition(fsm.StepAwaitingEditComments, ctx.Data)
-			return ctx.SendMessage(
-				presentation.AskOrderCommentsMsg(),
-				presentation.SkipKbd(),
-			)
+			return ctx.Advance(fsm.StepAwaitingEditComments)
 		}).
-		OnCallback(fsm.HandleCallbackWithMessage[*fsm.OrderEditData](
-			"skip",
-			fsm.StepAwaitingEditComments,
-			presentation.AskOrderCommentsMsg(),
-			presentation.SkipKbd(),
-		)).
+		OnCallback(fsm.AdvanceOnCallback[*fsm.OrderEditData]("skip", fsm.StepAwaitingEditComments)).
 		Then(fsm.StepAwaitingEditComments).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderEditData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskOrderCommentsMsg(), presentation.SkipKbd()
+		}).
 		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], text string) error {
 			ctx.Data.Comments = strings.Split(text, ".")
-			ctx.Transition(fsm.StepAwaitingEditOverrideComments, ctx.Data)
-			return ctx.SendMessage(
-				presentation.AskOrderCommentsOverrideMsg(),
-				presentation.YesNoKbd(),
-			)
+			return ctx.Advance(fsm.StepAwaitingEditOverrideComments)
 		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
@@ -81,6 +88,9 @@ func SetupOrderEditFlow(deps *OrderEditFlowDeps) {
 			return nil
 		}).
 		Then(fsm.StepAwaitingEditOverrideComments).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderEditData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskOrderCommentsOverrideMsg(), presentation.YesNoKbd()
+		}).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderEditData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
@@ -91,6 +101,15 @@ func SetupOrderEditFlow(deps *OrderEditFlowDeps) {
 		})
 }
 
+// stepAfterEditName leaves the cost out for orders with items, their cost is
+// recalculated from the items instead
+func stepAfterEditName(data *fsm.OrderEditData) fsm.ConversationStep {
+	if data.HasItems {
+		return fsm.StepAwaitingEditComments
+	}
+	return fsm.StepAwaitingEditCost
+}
+
 func finalizeOrderEdit(ctx *fsm.ConversationContext[*fsm.OrderEditData], orderService order.Service) error {
 	edit := order.RequestEditOrder{
 		PrintType:        ctx.Data.PrintType,
@@ -100,7 +119,11 @@ func finalizeOrderEdit(ctx *fsm.ConversationContext[*fsm.OrderEditData], orderSe
 		OverrideComments: ctx.Data.OverrideComments,
 	}
 
-	if err := orderService.EditOrder(ctx.Ctx, ctx.Data.OrderID, edit); err != nil {
+	err := orderService.EditOrder(ctx.Ctx, ctx.Data.OrderID, edit, ctx.UserID)
+	if errors.Is(err, order.ErrCostFromItems) {
+		return ctx.Complete(presentation.OrderCostFromItemsMsg())
+	}
+	if err != nil {
 		return ctx.Complete(presentation.OrderEditErrorMsg())
 	}
 
@@ -0,0 +1,225 @@
+package telegram
+
+import (
+	"context"
+	"math"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/quote"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+type OrderItemsFlowDeps struct {
+	Router         *fsm.Router
+	OrderService   order.Service
+	CatalogService catalog.Service
+	QuoteEngine    quote.Engine
+}
+
+const orderItemsTTL = 30 * time.Minute
+
+func SetupOrderItemsFlow(deps *OrderItemsFlowDeps) {
+	fsm.Chain[*fsm.OrderItemsData](deps.Router, "order_items", fsm.StepAwaitingItemsEditAction).
+		Timeout(orderItemsTTL, presentation.OrderItemsEditExpiredMsg()).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderItemsData]) (string, *models.InlineKeyboardMarkup) {
+			return orderItemsEditor(ctx.Data)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderItemsData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "done" {
+				if err := deps.OrderService.ReplaceOrderItems(ctx.Ctx, ctx.Data.OrderID, ctx.Data.Items, ctx.UserID); err != nil {
+					return ctx.Complete(presentation.OrderItemsSaveErrorMsg())
+				}
+				return ctx.Complete(presentation.OrderItemsSavedMsg())
+			}
+			return handleItemsAction(ctx, &ctx.Data.ItemsDraft, ctx.Data.Files, data, fsm.StepAwaitingItemsEditDetails, func() (string, *models.InlineKeyboardMarkup) {
+				return orderItemsEditor(ctx.Data)
+			})
+		}).
+
+		// Item details
+		Then(fsm.StepAwaitingItemsEditDetails).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderItemsData]) (string, *models.InlineKeyboardMarkup) {
+			return itemDetailsPrompt(ctx.Ctx, deps.CatalogService, ctx.Data.PrintType, ctx.Data.PendingFile), nil
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderItemsData], text string) error {
+			return handleItemDetails(ctx, deps.CatalogService, &ctx.Data.ItemsDraft, text, fsm.StepAwaitingItemsEditAction, func(item order.OrderItem) (float32, bool) {
+				return quoteOrderItem(ctx, deps, item)
+			})
+		})
+}
+
+func orderItemsEditor(data *fsm.OrderItemsData) (string, *models.InlineKeyboardMarkup) {
+	return presentation.OrderItemsMsg(data.Items), presentation.OrderItemsKbd(data.Files, data.Items, "💾 Сохранить")
+}
+
+// startItemsEdit opens the bill of materials of the current order for editing
+func startItemsEdit(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+
+	files := make([]string, len(order.Files))
+	for i, f := range order.Files {
+		files[i] = f.Name
+	}
+	slices.Sort(files)
+
+	itemsData := &fsm.OrderItemsData{
+		OrderID:    order.ID,
+		PrintType:  order.PrintType,
+		Files:      files,
+		ItemsDraft: fsm.ItemsDraft{Items: order.Items, EditIdx: -1},
+	}
+	return ctx.AdvanceWith(fsm.StepAwaitingItemsEditAction, itemsData)
+}
+
+// handleItemsAction handles the item list buttons shared by order creation and
+// item editing. The list is redrawn in place after a removal, so the buttons of
+// the message never point at shifted items
+func handleItemsAction[T fsm.StateData](ctx *fsm.ConversationContext[T], draft *fsm.ItemsDraft, files []string, data string,
+	detailsStep fsm.ConversationStep, render func() (string, *models.InlineKeyboardMarkup)) error {
+	if data == "item_new" {
+		draft.PendingFile, draft.EditIdx = "", -1
+		return ctx.Advance(detailsStep)
+	}
+	if idxStr, ok := strings.CutPrefix(data, presentation.ItemFileCallbackPrefix); ok {
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil || idx < 0 || idx >= len(files) {
+			return nil
+		}
+		draft.PendingFile, draft.EditIdx = files[idx], -1
+		return ctx.Advance(detailsStep)
+	}
+	if idxStr, ok := strings.CutPrefix(data, presentation.ItemEditCallbackPrefix); ok {
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil || idx < 0 || idx >= len(draft.Items) {
+			return nil
+		}
+		draft.PendingFile, draft.EditIdx = draft.Items[idx].FileName, idx
+		return ctx.Advance(detailsStep)
+	}
+	if idxStr, ok := strings.CutPrefix(data, presentation.ItemDeleteCallbackPrefix); ok {
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil || idx < 0 || idx >= len(draft.Items) {
+			return nil
+		}
+		draft.Items = slices.Delete(draft.Items, idx, idx+1)
+		ctx.Transition(ctx.Step, ctx.Data)
+
+		text, markup := render()
+		_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+			ChatID:      ctx.ChatID,
+			MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+			Text:        text,
+			ReplyMarkup: markup,
+			ParseMode:   models.ParseModeHTML,
+		})
+		return err
+	}
+	return nil
+}
+
+// itemDetailsPrompt hints the catalog entries of the order's technology, the
+// prompt works without them when the catalog can't be loaded
+func itemDetailsPrompt(ctx context.Context, catalogService catalog.Service, printType, fileName string) string {
+	c, err := catalogService.GetCatalog(ctx, false)
+	if err != nil {
+		return presentation.AskItemDetailsMsg(fileName, nil, nil)
+	}
+	return presentation.AskItemDetailsMsg(fileName, c.MaterialsFor(printType), c.Colors)
+}
+
+// handleItemDetails adds the typed item to the draft or replaces the one being
+// edited, then returns to the item list. Materials and colors known to the
+// catalog are stored with the catalog spelling, items typed without a price
+// are priced by quoteItem
+func handleItemDetails[T fsm.StateData](ctx *fsm.ConversationContext[T], catalogService catalog.Service, draft *fsm.ItemsDraft, text string, listStep fsm.ConversationStep,
+	quoteItem func(order.OrderItem) (float32, bool)) error {
+	item, quoted, err := presentation.ParseOrderItem(text)
+	if err != nil {
+		return ctx.SendMessage(presentation.ItemValidationErrorMsg(), nil)
+	}
+	item.FileName = draft.PendingFile
+	if c, err := catalogService.GetCatalog(ctx.Ctx, false); err == nil {
+		item.Material, item.Color = c.MaterialName(item.Material), c.ColorName(item.Color)
+	}
+	if quoted {
+		price, ok := quoteItem(item)
+		if !ok {
+			return ctx.SendMessage(presentation.ItemQuoteErrorMsg(), nil)
+		}
+		item.UnitPrice = price
+	}
+
+	if draft.EditIdx >= 0 && draft.EditIdx < len(draft.Items) {
+		draft.Items[draft.EditIdx] = item
+	} else {
+		draft.Items = append(draft.Items, item)
+	}
+	draft.PendingFile, draft.EditIdx = "", -1
+	return ctx.Advance(listStep)
+}
+
+// quoteOrderItem prices an item of a saved order from the analysis stored for
+// its file
+func quoteOrderItem(ctx *fsm.ConversationContext[*fsm.OrderItemsData], deps *OrderItemsFlowDeps, item order.OrderItem) (float32, bool) {
+	if item.FileName == "" {
+		return 0, false
+	}
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrderID)
+	if err != nil {
+		return 0, false
+	}
+	for _, f := range order.Files {
+		if f.Name == item.FileName {
+			return quoteItem(ctx.Ctx, deps.CatalogService, deps.QuoteEngine, ctx.Data.PrintType, item, f.Analysis, f.GCode)
+		}
+	}
+	return 0, false
+}
+
+// quoteItem prices one piece of the item in its material, with the labour
+// and the minimum price spread over its quantity. Items whose material isn't
+// in the catalog or whose file can't be quoted get no price
+func quoteItem(ctx context.Context, catalogService catalog.Service, engine quote.Engine, printType string, item order.OrderItem,
+	analysis *model.Analysis, estimate *gcode.Estimate) (float32, bool) {
+	c, err := catalogService.GetCatalog(ctx, false)
+	if err != nil {
+		return 0, false
+	}
+	idx := slices.IndexFunc(c.MaterialsFor(printType), func(m catalog.Material) bool {
+		return strings.EqualFold(m.Name, item.Material)
+	})
+	if idx < 0 {
+		return 0, false
+	}
+	material := c.MaterialsFor(printType)[idx]
+	unit := catalog.UnitGram
+	if t, ok := c.Technology(material.Technology); ok {
+		unit = t.Unit
+	}
+
+	q, err := engine.Quote(quote.Request{
+		Unit:     unit,
+		Material: material,
+		Parts:    []quote.Part{{Name: item.FileName, Analysis: analysis, GCode: estimate, Quantity: item.Quantity}},
+	})
+	if err != nil {
+		return 0, false
+	}
+	return float32(math.Ceil(float64(q.Total) / float64(item.Quantity))), true
+}
@@ -2,24 +2,35 @@ package telegram
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
 	fileSvc "print3d-order-bot/internal/file"
+	"print3d-order-bot/internal/gcode"
+	"print3d-order-bot/internal/model"
 	"print3d-order-bot/internal/mtproto"
 	orderSvc "print3d-order-bot/internal/order"
 	"print3d-order-bot/internal/reconciler"
 	"print3d-order-bot/internal/telegram/internal/fsm"
 	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
 
 func (b *Bot) handleOrderViewCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
-	userID := update.Message.From.ID
+	key := fsm.ExtractKey(update)
 
-	ids, err := b.orderService.GetActiveOrdersIDs(ctx)
+	ids, err := b.orderService.ListOrders(ctx, orderSvc.OrderFilter{})
 	if err != nil {
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:    userID,
+			ChatID:    key.ChatID,
 			Text:      presentation.OrderIDsLoadErrorMsg(),
 			ParseMode: models.ParseModeHTML,
 		})
@@ -28,7 +39,7 @@ func (b *Bot) handleOrderViewCmd(ctx context.Context, api *bot.Bot, update *mode
 
 	if len(ids) == 0 {
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:    userID,
+			ChatID:    key.ChatID,
 			Text:      presentation.EmptyOrderListMsg(),
 			ParseMode: models.ParseModeHTML,
 		})
@@ -38,25 +49,24 @@ func (b *Bot) handleOrderViewCmd(ctx context.Context, api *bot.Bot, update *mode
 	order, err := b.orderService.GetOrderByID(ctx, ids[0])
 	if err != nil {
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:    userID,
+			ChatID:    key.ChatID,
 			Text:      presentation.OrderLoadErrorMsg(),
 			ParseMode: models.ParseModeHTML,
 		})
 		return
 	}
 
-	action := extractOrderAction(order.Status)
 	newData := &fsm.OrderSliderData{
 		OrdersIDs:  ids,
 		CurrentIdx: 0,
 	}
 
 	disablePreview := true
-	b.tryTransition(userID, fsm.StepAwaitingOrderViewSliderAction, newData)
+	b.tryTransition(key, fsm.StepAwaitingOrderViewSliderAction, newData)
 	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:      userID,
-		Text:        presentation.OrderViewMsg(order),
-		ReplyMarkup: presentation.OrderSliderMgmtKbd(len(ids), 0, action),
+		ChatID:      key.ChatID,
+		Text:        presentation.OrderViewMsg(order, user.FromContext(ctx)),
+		ReplyMarkup: presentation.OrderSliderMgmtKbd(len(ids), 0, order, b.orderService.GetNextStatuses(order.Status), user.FromContext(ctx), false),
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: &disablePreview,
 		},
@@ -69,16 +79,31 @@ type OrderViewerDeps struct {
 	OrderService      orderSvc.Service
 	FileService       fileSvc.Service
 	ReconcilerService reconciler.Service
+	ClientService     client.Service
+	CatalogService    catalog.Service
 	BotApi            *Bot
 	MtprotoClient     *mtproto.Client
 }
 
+const sliderTTL = 24 * time.Hour
+
 func SetupOrderViewerFlow(deps *OrderViewerDeps) {
 	fsm.Chain[*fsm.OrderSliderData](deps.Router, "order_viewer", fsm.StepAwaitingOrderViewSliderAction).
+		Timeout(sliderTTL, presentation.SessionExpiredMsg()).
 		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], data string) error {
 			if err := ctx.AnswerCallbackQuery("", false); err != nil {
 				return err
 			}
+			if !canHandleViewerAction(ctx.Ctx, data) {
+				return ctx.SendMessage(presentation.PermissionDeniedMsg(), nil)
+			}
+			if status, ok := strings.CutPrefix(data, presentation.StatusCallbackPrefix); ok {
+				return changeOrderStatus(ctx, deps, orderSvc.Status(status))
+			}
+			if clientID, ok := strings.CutPrefix(data, presentation.ClientOrdersCallbackPrefix); ok {
+				return openClientOrders(ctx, deps, clientID)
+			}
+
 			switch data {
 			case "previous":
 				if ctx.Data.CurrentIdx > 0 {
@@ -92,54 +117,334 @@ func SetupOrderViewerFlow(deps *OrderViewerDeps) {
 				}
 				return updateOrderView(ctx, deps.OrderService)
 
-			case "close":
-				if err := deps.OrderService.CloseOrder(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx]); err != nil {
-					return ctx.SendMessage(presentation.OrderCloseErrorMsg(), nil)
-				}
-				return updateOrderView(ctx, deps.OrderService)
-
 			case "restore":
-				if err := deps.OrderService.RestoreOrder(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx]); err != nil {
-					return ctx.SendMessage(presentation.OrderRestoreErrorMsg(), nil)
-				}
-				return updateOrderView(ctx, deps.OrderService)
+				return handleOrderRestore(ctx, deps, false)
+
+			case "force_restore":
+				return handleOrderRestore(ctx, deps, true)
+
+			case "force_delivered":
+				return forceOrderDelivery(ctx, deps)
+
+			case "payment":
+				return startPayment(ctx, deps)
+
+			case "items":
+				return startItemsEdit(ctx, deps)
+
+			case "unarchive":
+				return handleOrderUnarchive(ctx, deps)
 
 			case "files":
 				return handleOrderFiles(ctx, deps)
 
+			case "history":
+				return handleOrderHistory(ctx, deps)
+
+			case "client":
+				return handleClientCard(ctx, deps)
+
+			case "filter":
+				return ctx.Advance(fsm.StepAwaitingOrderFilterAction)
+
+			case "clone":
+				return handleOrderClone(ctx, deps)
+
 			case "edit":
-				editData := &fsm.OrderEditData{
-					OrderID: ctx.Data.OrdersIDs[ctx.Data.CurrentIdx],
-				}
-				ctx.Transition(fsm.StepAwaitingEditPrintType, editData)
-				kbd := presentation.PrintTypeKbd()
-				kbd.InlineKeyboard = append(kbd.InlineKeyboard, presentation.SkipKbd().InlineKeyboard...)
-				return ctx.SendMessage(
-					presentation.AskPrintTypeMsg(),
-					kbd,
-				)
+				return startOrderEdit(ctx, deps)
 
 			default:
 				return nil
 			}
 		})
+
+	fsm.Chain[*fsm.OrderSliderData](deps.Router, "order_filter", fsm.StepAwaitingOrderFilterAction).
+		Timeout(sliderTTL, presentation.SessionExpiredMsg()).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData]) (string, *models.InlineKeyboardMarkup) {
+			viewer := user.FromContext(ctx.Ctx)
+			return presentation.OrderFilterMsg(&ctx.Data.Filter, ctx.Data.FilterClientName, viewer),
+				filterPanelKbd(ctx, deps)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			return handleFilterAction(ctx, deps, data)
+		}).
+
+		// Custom creation dates
+		Then(fsm.StepAwaitingFilterDates).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskFilterDatesMsg(), nil
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], text string) error {
+			from, to, err := presentation.ParseDateRange(text)
+			if err != nil {
+				return ctx.SendMessage(presentation.DateRangeValidationErrorMsg(), nil)
+			}
+			ctx.Data.Filter.CreatedFrom, ctx.Data.Filter.CreatedTo = from, to
+			return ctx.Advance(fsm.StepAwaitingOrderFilterAction)
+		}).
+
+		// Custom cost range
+		Then(fsm.StepAwaitingFilterCost).
+		BackTo(fsm.StepAwaitingOrderFilterAction).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskFilterCostMsg(), nil
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], text string) error {
+			minCost, maxCost, err := presentation.ParseCostRange(text)
+			if err != nil {
+				return ctx.SendMessage(presentation.CostRangeValidationErrorMsg(), nil)
+			}
+			ctx.Data.Filter.CostMin, ctx.Data.Filter.CostMax = minCost, maxCost
+			return ctx.Advance(fsm.StepAwaitingOrderFilterAction)
+		}).
+
+		// Client
+		Then(fsm.StepAwaitingFilterClient).
+		BackTo(fsm.StepAwaitingOrderFilterAction).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData]) (string, *models.InlineKeyboardMarkup) {
+			recent, err := deps.ClientService.SearchClients(ctx.Ctx, "")
+			if err != nil {
+				return presentation.ClientsLoadErrorMsg(), presentation.FilterClientKbd(nil)
+			}
+			return presentation.AskFilterClientMsg(), presentation.FilterClientKbd(recent)
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], text string) error {
+			clients, err := deps.ClientService.SearchClients(ctx.Ctx, text)
+			if err != nil {
+				return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+			}
+			return ctx.SendMessage(presentation.ClientSearchResultMsg(text, len(clients)), fsm.WithBackButton(presentation.FilterClientKbd(clients)))
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.OrderSliderData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "any_client" {
+				ctx.Data.Filter.ClientID = nil
+				ctx.Data.FilterClientName = ""
+				return ctx.Advance(fsm.StepAwaitingOrderFilterAction)
+			}
+
+			idStr, ok := strings.CutPrefix(data, presentation.ClientCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return nil
+			}
+			picked, err := deps.ClientService.GetClient(ctx.Ctx, id)
+			if err != nil {
+				return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+			}
+			ctx.Data.Filter.ClientID = &picked.ID
+			ctx.Data.FilterClientName = picked.Name
+			return ctx.Advance(fsm.StepAwaitingOrderFilterAction)
+		})
+}
+
+func handleFilterAction(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps, data string) error {
+	filter := &ctx.Data.Filter
+	viewer := user.FromContext(ctx.Ctx)
+
+	if printType, ok := strings.CutPrefix(data, presentation.FilterTypeCallbackPrefix); ok {
+		filter.PrintTypes = toggleValue(filter.PrintTypes, printType)
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+	}
+	if status, ok := strings.CutPrefix(data, presentation.FilterStatusCallbackPrefix); ok {
+		filter.Statuses = toggleValue(filter.Statuses, orderSvc.Status(status))
+		return updateFilterPanel(ctx, presentation.FilterStatusKbd(filter))
+	}
+	if preset, ok := strings.CutPrefix(data, presentation.FilterDatesCallbackPrefix); ok {
+		if preset == "custom" {
+			return ctx.Advance(fsm.StepAwaitingFilterDates)
+		}
+		filter.CreatedFrom, filter.CreatedTo = datePresetStart(preset, time.Now()), nil
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+	}
+	if costRange, ok := strings.CutPrefix(data, presentation.FilterCostCallbackPrefix); ok {
+		if !viewer.Can(user.PermViewCosts) {
+			return ctx.SendMessage(presentation.PermissionDeniedMsg(), nil)
+		}
+		switch costRange {
+		case "custom":
+			return ctx.Advance(fsm.StepAwaitingFilterCost)
+		case "any":
+			filter.CostMin, filter.CostMax = nil, nil
+		default:
+			minCost, maxCost, err := presentation.ParseCostRange(costRange)
+			if err != nil {
+				return nil
+			}
+			filter.CostMin, filter.CostMax = minCost, maxCost
+		}
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+	}
+
+	switch data {
+	case "panel":
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+
+	case "statuses":
+		return updateFilterPanel(ctx, presentation.FilterStatusKbd(filter))
+
+	case "dates":
+		return updateFilterPanel(ctx, presentation.FilterDatesKbd())
+
+	case "cost":
+		if !viewer.Can(user.PermViewCosts) {
+			return ctx.SendMessage(presentation.PermissionDeniedMsg(), nil)
+		}
+		return updateFilterPanel(ctx, presentation.FilterCostKbd())
+
+	case "client":
+		return ctx.Advance(fsm.StepAwaitingFilterClient)
+
+	case "files":
+		switch {
+		case filter.HasFiles == nil:
+			hasFiles := true
+			filter.HasFiles = &hasFiles
+		case *filter.HasFiles:
+			hasFiles := false
+			filter.HasFiles = &hasFiles
+		default:
+			filter.HasFiles = nil
+		}
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+
+	case "sort":
+		filter.Sort = nextSortOrder(filter.Sort, viewer.Can(user.PermViewCosts))
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+
+	case "reset":
+		ctx.Data.Filter = orderSvc.OrderFilter{}
+		ctx.Data.FilterClientName = ""
+		return updateFilterPanel(ctx, filterPanelKbd(ctx, deps))
+
+	case "apply":
+		ids, err := deps.OrderService.ListOrders(ctx.Ctx, *filter)
+		if err != nil {
+			return ctx.SendMessage(presentation.OrderIDsLoadErrorMsg(), nil)
+		}
+		if len(ids) == 0 {
+			return ctx.SendMessage(presentation.NoFilteredOrdersMsg(), nil)
+		}
+		ctx.Data.OrdersIDs = ids
+		ctx.Data.CurrentIdx = 0
+		return updateOrderView(ctx, deps.OrderService)
+
+	default:
+		return nil
+	}
+}
+
+// filterPanelKbd offers every technology of the catalog, the panel still works
+// without the print type toggles when the catalog can't be loaded
+func filterPanelKbd(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) *models.InlineKeyboardMarkup {
+	var technologies []catalog.Technology
+	if c, err := deps.CatalogService.GetCatalog(ctx.Ctx, true); err == nil {
+		technologies = c.Technologies
+	}
+	return presentation.OrderFilterKbd(&ctx.Data.Filter, user.FromContext(ctx.Ctx), technologies)
+}
+
+// updateFilterPanel redraws the panel summary with the given menu under it
+func updateFilterPanel(ctx *fsm.ConversationContext[*fsm.OrderSliderData], markup *models.InlineKeyboardMarkup) error {
+	ctx.Transition(fsm.StepAwaitingOrderFilterAction, ctx.Data)
+	_, err := ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+		ChatID:      ctx.ChatID,
+		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+		Text:        presentation.OrderFilterMsg(&ctx.Data.Filter, ctx.Data.FilterClientName, user.FromContext(ctx.Ctx)),
+		ReplyMarkup: markup,
+		ParseMode:   models.ParseModeHTML,
+	})
+	return err
+}
+
+func toggleValue[T comparable](values []T, value T) []T {
+	if i := slices.Index(values, value); i >= 0 {
+		return slices.Delete(values, i, i+1)
+	}
+	return append(values, value)
+}
+
+// datePresetStart returns the beginning of the preset period, nil for "any"
+func datePresetStart(preset string, now time.Time) *time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var start time.Time
+	switch preset {
+	case "today":
+		start = today
+	case "week":
+		start = today.AddDate(0, 0, -6)
+	case "month":
+		start = today.AddDate(0, 0, -29)
+	case "year":
+		start = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+	default:
+		return nil
+	}
+	return &start
+}
+
+// nextSortOrder cycles the sort options, sorting by cost is skipped for
+// those who can't see costs
+func nextSortOrder(current orderSvc.SortOrder, withCost bool) orderSvc.SortOrder {
+	orders := []orderSvc.SortOrder{orderSvc.SortCreatedAsc, orderSvc.SortCreatedDesc, orderSvc.SortDueAsc}
+	if withCost {
+		orders = append(orders, orderSvc.SortCostDesc, orderSvc.SortCostAsc)
+	}
+	i := slices.Index(orders, current)
+	if i < 0 {
+		i = 0
+	}
+	return orders[(i+1)%len(orders)]
+}
+
+// canHandleViewerAction rechecks permissions on callbacks, since keyboards
+// sent before a role change still carry the old buttons
+func canHandleViewerAction(ctx context.Context, data string) bool {
+	if strings.HasPrefix(data, presentation.StatusCallbackPrefix) {
+		return can(ctx, user.PermChangeStatus)
+	}
+	switch data {
+	case "restore":
+		return can(ctx, user.PermChangeStatus)
+	case "force_restore":
+		return can(ctx, user.PermRestoreAnyOrder)
+	case "force_delivered":
+		return can(ctx, user.PermDeliverUnpaid)
+	case "payment":
+		return can(ctx, user.PermRegisterPayments)
+	case "files", "unarchive":
+		return can(ctx, user.PermDownloadFiles)
+	case "edit", "items":
+		return can(ctx, user.PermEditOrders)
+	case "clone":
+		return can(ctx, user.PermCreateOrders)
+	default:
+		return true
+	}
 }
 
 func updateOrderView(ctx *fsm.ConversationContext[*fsm.OrderSliderData], orderService orderSvc.Service) error {
-	order, err := orderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	text, markup, err := orderView(ctx.Ctx, orderService, ctx.Data)
 	if err != nil {
 		return ctx.Complete(presentation.OrderLoadErrorMsg())
 	}
 
-	action := extractOrderAction(order.Status)
 	disablePreview := true
 
 	ctx.Transition(fsm.StepAwaitingOrderViewSliderAction, ctx.Data)
 	_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
-		ChatID:      ctx.UserID,
+		ChatID:      ctx.ChatID,
 		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
-		Text:        presentation.OrderViewMsg(order),
-		ReplyMarkup: presentation.OrderSliderMgmtKbd(len(ctx.Data.OrdersIDs), ctx.Data.CurrentIdx, action),
+		Text:        text,
+		ReplyMarkup: markup,
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: &disablePreview,
 		},
@@ -148,10 +453,57 @@ func updateOrderView(ctx *fsm.ConversationContext[*fsm.OrderSliderData], orderSe
 	return err
 }
 
+// orderView renders the current slider position for the caller's role
+func orderView(ctx context.Context, orderService orderSvc.Service, data *fsm.OrderSliderData) (string, *models.InlineKeyboardMarkup, error) {
+	order, err := orderService.GetOrderByID(ctx, data.OrdersIDs[data.CurrentIdx])
+	if err != nil {
+		return "", nil, err
+	}
+	viewer := user.FromContext(ctx)
+	return presentation.OrderViewMsg(order, viewer),
+		presentation.OrderSliderMgmtKbd(len(data.OrdersIDs), data.CurrentIdx, order, orderService.GetNextStatuses(order.Status), viewer, !data.Filter.IsEmpty()),
+		nil
+}
+
+// handleOrderRestore brings the order back into production along with its archived
+// files. Past the restoration period only those allowed to force it are offered to
+func handleOrderRestore(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps, force bool) error {
+	orderID := ctx.Data.OrdersIDs[ctx.Data.CurrentIdx]
+	var err error
+	if force {
+		err = deps.OrderService.ForceRestoreOrder(ctx.Ctx, orderID, ctx.UserID)
+	} else {
+		err = deps.OrderService.RestoreOrder(ctx.Ctx, orderID, ctx.UserID)
+	}
+	if errors.Is(err, orderSvc.ErrRestorationPeriodExpired) {
+		if can(ctx.Ctx, user.PermRestoreAnyOrder) {
+			return ctx.SendMessage(presentation.RestorationPeriodExpiredMsg(), presentation.ForceRestoreKbd())
+		}
+		return ctx.SendMessage(presentation.RestorationPeriodExpiredMsg(), nil)
+	}
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderRestoreErrorMsg(), nil)
+	}
+
+	deps.Router.Freeze(ctx.Key(), presentation.PendingUnarchiveMsg())
+	err = deps.ReconcilerService.RestoreOrderFiles(ctx.Ctx, orderID, ctx.UserID)
+	deps.Router.Unfreeze(ctx.Key())
+	if err != nil {
+		if err := ctx.SendMessage(presentation.UnarchiveErrorMsg(), nil); err != nil {
+			return err
+		}
+	}
+	announceOrder(ctx, deps, presentation.OrderRestoredAnnouncementMsg)
+	return updateOrderView(ctx, deps.OrderService)
+}
+
 func handleOrderFiles(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
-	deps.Router.Freeze(ctx.UserID, presentation.PendingUploadMsg())
-	defer deps.Router.Unfreeze(ctx.UserID)
+	deps.Router.Freeze(ctx.Key(), presentation.PendingUploadMsg())
+	defer deps.Router.Unfreeze(ctx.Key())
 
+	if err := deps.ReconcilerService.UnpackOrderFiles(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx]); err != nil {
+		return ctx.SendMessage(presentation.UnarchiveErrorMsg(), nil)
+	}
 	deps.ReconcilerService.ReconcileOrder(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
 
 	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
@@ -174,9 +526,9 @@ func handleOrderFiles(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *
 
 		var uploadErr error
 		if file.Size <= 49*1024*1024 {
-			uploadErr = deps.BotApi.UploadFile(ctx.Ctx, file.Name, file.Body, ctx.UserID)
+			uploadErr = deps.BotApi.UploadFile(ctx.Ctx, file.Name, file.Body, ctx.ChatID)
 		} else {
-			uploadErr = deps.MtprotoClient.UploadFile(ctx.Ctx, file.Name, file.Body, ctx.UserID)
+			uploadErr = deps.MtprotoClient.UploadFile(ctx.Ctx, file.Name, file.Body, ctx.ChatID)
 		}
 
 		if uploadErr != nil {
@@ -189,13 +541,245 @@ func handleOrderFiles(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *
 	return nil
 }
 
-func extractOrderAction(status orderSvc.Status) presentation.OrderSliderAction {
-	switch status {
-	case orderSvc.StatusActive:
-		return presentation.OrderSliderClose
-	case orderSvc.StatusClosed:
-		return presentation.OrderSliderRestore
+func handleOrderUnarchive(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	deps.Router.Freeze(ctx.Key(), presentation.PendingUnarchiveMsg())
+	err := deps.ReconcilerService.UnpackOrderFiles(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	deps.Router.Unfreeze(ctx.Key())
+	if err != nil {
+		return ctx.SendMessage(presentation.UnarchiveErrorMsg(), nil)
+	}
+	if err := ctx.SendMessage(presentation.UnarchivedMsg(), nil); err != nil {
+		return err
+	}
+	return updateOrderView(ctx, deps.OrderService)
+}
+
+func handleOrderHistory(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	orderID := ctx.Data.OrdersIDs[ctx.Data.CurrentIdx]
+	events, err := deps.OrderService.GetOrderHistory(ctx.Ctx, orderID)
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderHistoryLoadErrorMsg(), nil)
+	}
+	if !can(ctx.Ctx, user.PermViewCosts) {
+		events = withoutCostEvents(events)
+	}
+	return ctx.SendMessage(presentation.OrderHistoryMsg(orderID, events), nil)
+}
+
+// handleOrderClone creates a new order with the data and files of the current
+// one. Archived files are extracted straight into the copy so the source stays
+// untouched
+func handleOrderClone(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	deps.Router.Freeze(ctx.Key(), presentation.PendingCloneMsg())
+	defer deps.Router.Unfreeze(ctx.Key())
+
+	source, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+
+	// The reserved folder is new and empty, so it is safe to delete on failure
+	createdAt := time.Now()
+	folderPath, err := deps.FileService.ReserveFolder(CreateFolderPath(createdAt, source.ClientName, strings.Join(source.Comments, " "), source.PrintType))
+	if err != nil {
+		slog.Error("Failed to reserve order folder", "error", err, "orderID", source.ID)
+		return ctx.SendMessage(presentation.OrderCloneErrorMsg(), nil)
+	}
+	checksums, err := copyOrderFiles(deps.FileService, source, folderPath)
+	if err != nil {
+		slog.Error("Failed to copy order files", "error", err, "orderID", source.ID)
+		_ = deps.FileService.DeleteFolder(folderPath)
+		return ctx.SendMessage(presentation.OrderCloneErrorMsg(), nil)
+	}
+
+	sourceFiles := make(map[string]orderSvc.File, len(source.Files))
+	for _, f := range source.Files {
+		sourceFiles[f.Name] = f
+	}
+	files := make([]orderSvc.File, 0, len(checksums))
+	for name, checksum := range checksums {
+		sourceFile := sourceFiles[name]
+		// The analysis is only carried over when the copy has the same content
+		var analysis *model.Analysis
+		var estimate *gcode.Estimate
+		if sourceFile.Checksum == checksum {
+			analysis = sourceFile.Analysis
+			estimate = sourceFile.GCode
+		}
+		files = append(files, orderSvc.File{
+			Name:     name,
+			Checksum: checksum,
+			TgFileID: sourceFile.TgFileID,
+			Analysis: analysis,
+			GCode:    estimate,
+		})
+	}
+
+	var clientID *int
+	if source.ClientID != 0 {
+		clientID = &source.ClientID
+	}
+	orderID, err := deps.OrderService.NewOrder(ctx.Ctx, orderSvc.RequestNewOrder{
+		PrintType:  source.PrintType,
+		ClientID:   clientID,
+		ClientName: source.ClientName,
+		Cost:       source.Cost,
+		Comments:   source.Comments,
+		Contacts:   source.Contacts,
+		Links:      source.Links,
+		CreatedAt:  createdAt,
+		FolderPath: folderPath,
+		Items:      source.Items,
+	}, files, ctx.UserID)
+	if err != nil {
+		_ = deps.FileService.DeleteFolder(folderPath)
+		return ctx.SendMessage(presentation.OrderCloneErrorMsg(), nil)
+	}
+	if !deps.BotApi.isWorkspace(ctx.ChatID) {
+		deps.BotApi.announce(ctx.Ctx, presentation.NewOrderAnnouncementMsg(source.ClientName, source.PrintType, sender(ctx.Update)))
+	}
+	return ctx.SendMessage(presentation.OrderClonedMsg(source.ID, orderID), nil)
+}
+
+func copyOrderFiles(fileService fileSvc.Service, source *orderSvc.ResponseOrder, folderPath string) (map[string]uint64, error) {
+	switch {
+	case source.ArchivePath != "":
+		if err := fileService.ExtractArchive(source.ArchivePath, folderPath); err != nil {
+			return nil, err
+		}
+		return fileService.GetChecksums(folderPath)
+	case source.FolderPath != "":
+		return fileService.CopyFolder(source.FolderPath, folderPath)
 	default:
-		return presentation.OrderSliderClose
+		return nil, nil
+	}
+}
+
+func handleClientCard(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+	card, err := deps.ClientService.GetClientCard(ctx.Ctx, order.ClientID)
+	if err != nil {
+		return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+	}
+	return ctx.SendMessage(presentation.ClientCardMsg(card, user.FromContext(ctx.Ctx)), presentation.ClientCardKbd(card))
+}
+
+// openClientOrders turns the client card into a slider over all of their orders
+func openClientOrders(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps, clientIDStr string) error {
+	clientID, err := strconv.Atoi(clientIDStr)
+	if err != nil {
+		return nil
+	}
+	card, err := deps.ClientService.GetClientCard(ctx.Ctx, clientID)
+	if err != nil {
+		return ctx.SendMessage(presentation.ClientsLoadErrorMsg(), nil)
+	}
+	if len(card.Orders) == 0 {
+		return ctx.SendMessage(presentation.EmptyOrderListMsg(), nil)
+	}
+
+	ids := make([]int, len(card.Orders))
+	for i, o := range card.Orders {
+		ids[i] = o.ID
+	}
+	ctx.Data.OrdersIDs = ids
+	ctx.Data.CurrentIdx = 0
+	ctx.Data.Filter = orderSvc.OrderFilter{}
+	ctx.Data.FilterClientName = ""
+	return updateOrderView(ctx, deps.OrderService)
+}
+
+func changeOrderStatus(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps, status orderSvc.Status) error {
+	err := deps.OrderService.ChangeOrderStatus(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx], status, ctx.UserID)
+	if errors.Is(err, orderSvc.ErrInvalidStatusTransition) {
+		if err := ctx.SendMessage(presentation.InvalidStatusTransitionMsg(), nil); err != nil {
+			return err
+		}
+		return updateOrderView(ctx, deps.OrderService)
+	}
+	if errors.Is(err, orderSvc.ErrOrderUnpaid) {
+		return sendOrderUnpaid(ctx, deps)
+	}
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderStatusChangeErrorMsg(), nil)
+	}
+	announceOrder(ctx, deps, presentation.OrderStatusAnnouncementMsg)
+	return updateOrderView(ctx, deps.OrderService)
+}
+
+// sendOrderUnpaid explains why the order can't be delivered, those allowed to
+// deliver unpaid orders are offered to do it anyway
+func sendOrderUnpaid(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+	if can(ctx.Ctx, user.PermDeliverUnpaid) {
+		return ctx.SendMessage(presentation.OrderUnpaidMsg(order.Outstanding), presentation.DeliverUnpaidKbd())
+	}
+	return ctx.SendMessage(presentation.OrderUnpaidMsg(order.Outstanding), nil)
+}
+
+func forceOrderDelivery(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	err := deps.OrderService.ForceChangeOrderStatus(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx], orderSvc.StatusDelivered, ctx.UserID)
+	if errors.Is(err, orderSvc.ErrInvalidStatusTransition) {
+		if err := ctx.SendMessage(presentation.InvalidStatusTransitionMsg(), nil); err != nil {
+			return err
+		}
+		return updateOrderView(ctx, deps.OrderService)
+	}
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderStatusChangeErrorMsg(), nil)
+	}
+	announceOrder(ctx, deps, presentation.OrderStatusAnnouncementMsg)
+	return updateOrderView(ctx, deps.OrderService)
+}
+
+func startPayment(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+	paymentData := &fsm.PaymentData{
+		OrderID:     order.ID,
+		Outstanding: order.Outstanding,
+	}
+	return ctx.AdvanceWith(fsm.StepAwaitingPaymentAmount, paymentData)
+}
+
+func startOrderEdit(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps) error {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+	editData := &fsm.OrderEditData{
+		OrderID:  order.ID,
+		HasItems: len(order.Items) > 0,
+	}
+	return ctx.AdvanceWith(fsm.StepAwaitingEditPrintType, editData)
+}
+
+// announceOrder tells the workspace group about a change made to the current order,
+// the slider in the group is edited silently so it is announced there as well
+func announceOrder(ctx *fsm.ConversationContext[*fsm.OrderSliderData], deps *OrderViewerDeps, msg func(*orderSvc.ResponseOrder, *models.User) string) {
+	order, err := deps.OrderService.GetOrderByID(ctx.Ctx, ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+	if err != nil {
+		slog.Error("Failed to load order for announcement", "error", err, "orderID", ctx.Data.OrdersIDs[ctx.Data.CurrentIdx])
+		return
+	}
+	deps.BotApi.announce(ctx.Ctx, msg(order, sender(ctx.Update)))
+}
+
+func withoutCostEvents(events []orderSvc.OrderEvent) []orderSvc.OrderEvent {
+	filtered := make([]orderSvc.OrderEvent, 0, len(events))
+	for _, event := range events {
+		if event.Field == "cost" || event.Type == orderSvc.EventPaymentAdded {
+			continue
+		}
+		filtered = append(filtered, event)
 	}
+	return filtered
 }
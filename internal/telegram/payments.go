@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+type PaymentFlowDeps struct {
+	Router       *fsm.Router
+	OrderService order.Service
+}
+
+const paymentTTL = 30 * time.Minute
+
+func SetupPaymentFlow(deps *PaymentFlowDeps) {
+	fsm.Chain[*fsm.PaymentData](deps.Router, "payment", fsm.StepAwaitingPaymentAmount).
+		Timeout(paymentTTL, presentation.PaymentExpiredMsg()).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.PaymentData]) (string, *models.InlineKeyboardMarkup) {
+			if ctx.Data.Outstanding <= 0 {
+				return presentation.AskPaymentAmountMsg(ctx.Data.Outstanding), nil
+			}
+			return presentation.AskPaymentAmountMsg(ctx.Data.Outstanding), presentation.PaymentAmountKbd(ctx.Data.Outstanding)
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.PaymentData], text string) error {
+			amount, err := presentation.ParseRUB(text)
+			if err != nil || amount <= 0 {
+				return ctx.SendMessage(presentation.PaymentAmountValidationErrorMsg(), nil)
+			}
+
+			ctx.Data.Amount = amount
+			return ctx.Advance(fsm.StepAwaitingPaymentMethod)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.PaymentData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "outstanding" && ctx.Data.Outstanding > 0 {
+				ctx.Data.Amount = ctx.Data.Outstanding
+				return ctx.Advance(fsm.StepAwaitingPaymentMethod)
+			}
+			return nil
+		}).
+
+		// Payment method
+		Then(fsm.StepAwaitingPaymentMethod).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.PaymentData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskPaymentMethodMsg(), presentation.PaymentMethodKbd()
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.PaymentData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			method, ok := strings.CutPrefix(data, presentation.PaymentMethodCallbackPrefix)
+			if !ok || !order.PaymentMethod(method).IsValid() {
+				return nil
+			}
+
+			ctx.Data.Method = order.PaymentMethod(method)
+			return ctx.Advance(fsm.StepAwaitingPaymentDate)
+		}).
+
+		// Payment date
+		Then(fsm.StepAwaitingPaymentDate).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.PaymentData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskPaymentDateMsg(), presentation.PaymentDateKbd()
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.PaymentData], text string) error {
+			paidAt, err := presentation.ParsePaymentDate(text, time.Now())
+			if err != nil {
+				return ctx.SendMessage(presentation.PaymentDateValidationErrorMsg(), nil)
+			}
+
+			ctx.Data.PaidAt = paidAt
+			return ctx.Advance(fsm.StepAwaitingPaymentNote)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.PaymentData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			daysStr, ok := strings.CutPrefix(data, presentation.PaymentDateCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			days, err := strconv.Atoi(daysStr)
+			if err != nil {
+				return nil
+			}
+
+			ctx.Data.PaidAt = time.Now().AddDate(0, 0, -days)
+			return ctx.Advance(fsm.StepAwaitingPaymentNote)
+		}).
+
+		// Payment note
+		Then(fsm.StepAwaitingPaymentNote).
+		Prompt(func(ctx *fsm.ConversationContext[*fsm.PaymentData]) (string, *models.InlineKeyboardMarkup) {
+			return presentation.AskPaymentNoteMsg(), presentation.SkipKbd()
+		}).
+		OnText(func(ctx *fsm.ConversationContext[*fsm.PaymentData], text string) error {
+			return finalizePayment(ctx, deps, text)
+		}).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.PaymentData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if data == "skip" {
+				return finalizePayment(ctx, deps, "")
+			}
+			return nil
+		})
+}
+
+func finalizePayment(ctx *fsm.ConversationContext[*fsm.PaymentData], deps *PaymentFlowDeps, note string) error {
+	payment := order.RequestNewPayment{
+		Amount: ctx.Data.Amount,
+		Method: ctx.Data.Method,
+		PaidAt: ctx.Data.PaidAt,
+		Note:   note,
+	}
+	if err := deps.OrderService.AddPayment(ctx.Ctx, ctx.Data.OrderID, payment, ctx.UserID); err != nil {
+		return ctx.Complete(presentation.PaymentErrorMsg())
+	}
+
+	return ctx.Complete(presentation.PaymentAddedMsg(ctx.Data.OrderID, ctx.Data.Amount))
+}
@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	orderSvc "print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// RemindDue messages the operator responsible for the order, orders without
+// one are reminded about in the workspace group
+func (b *Bot) RemindDue(ctx context.Context, order orderSvc.OrderSummary) error {
+	chatID := order.ResponsibleID
+	if chatID == 0 {
+		chatID = b.workspaceChatID
+	}
+	if chatID == 0 {
+		return errors.New("order has no responsible user and no workspace chat is set")
+	}
+	return b.notify(ctx, chatID, presentation.DueReminderMsg(order))
+}
+
+// SendOverdueDigest posts the digest to the workspace group, without one every
+// user who can change statuses gets it directly
+func (b *Bot) SendOverdueDigest(ctx context.Context, orders []orderSvc.OrderSummary) error {
+	text := presentation.OverdueDigestMsg(orders)
+	if b.workspaceChatID != 0 {
+		return b.notify(ctx, b.workspaceChatID, text)
+	}
+
+	users, err := b.userService.GetUsers(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range users {
+		if u.Can(user.PermChangeStatus) {
+			errs = append(errs, b.notify(ctx, u.ID, text))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Bot) notify(ctx context.Context, chatID int64, text string) error {
+	_, err := b.api.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    chatID,
+		Text:      text,
+		ParseMode: models.ParseModeHTML,
+	})
+	return err
+}
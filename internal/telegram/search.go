@@ -0,0 +1,152 @@
+package telegram
+
+import (
+	"context"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	searchPageSize = 8
+	searchTTL      = time.Hour
+)
+
+func (b *Bot) handleSearchCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	key := fsm.ExtractKey(update)
+	query := strings.Join(strings.Fields(update.Message.Text)[1:], " ")
+	if query == "" {
+		b.sendText(ctx, key.ChatID, presentation.SearchUsageMsg())
+		return
+	}
+
+	ids, err := b.orderService.SearchOrders(ctx, query)
+	if err != nil {
+		b.sendText(ctx, key.ChatID, presentation.SearchErrorMsg())
+		return
+	}
+	if len(ids) == 0 {
+		b.sendText(ctx, key.ChatID, presentation.NoSearchResultsMsg(query))
+		return
+	}
+
+	data := &fsm.SearchData{
+		Query:     query,
+		OrdersIDs: ids,
+	}
+	text, markup, err := searchPage(ctx, b.orderService, data)
+	if err != nil {
+		b.sendText(ctx, key.ChatID, presentation.SearchErrorMsg())
+		return
+	}
+
+	b.tryTransition(key, fsm.StepAwaitingSearchAction, data)
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      key.ChatID,
+		Text:        text,
+		ReplyMarkup: markup,
+		ParseMode:   models.ParseModeHTML,
+	})
+}
+
+type SearchFlowDeps struct {
+	Router       *fsm.Router
+	OrderService order.Service
+}
+
+func SetupSearchFlow(deps *SearchFlowDeps) {
+	fsm.Chain[*fsm.SearchData](deps.Router, "search", fsm.StepAwaitingSearchAction).
+		Timeout(searchTTL, presentation.SessionExpiredMsg()).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.SearchData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			if idStr, ok := strings.CutPrefix(data, presentation.OpenOrderCallbackPrefix); ok {
+				return openSearchResult(ctx, deps, idStr)
+			}
+
+			switch data {
+			case "previous":
+				if ctx.Data.Page > 0 {
+					ctx.Data.Page--
+				}
+			case "next":
+				if (ctx.Data.Page+1)*searchPageSize < len(ctx.Data.OrdersIDs) {
+					ctx.Data.Page++
+				}
+			default:
+				return nil
+			}
+
+			text, markup, err := searchPage(ctx.Ctx, deps.OrderService, ctx.Data)
+			if err != nil {
+				return ctx.Complete(presentation.SearchErrorMsg())
+			}
+			ctx.Transition(fsm.StepAwaitingSearchAction, ctx.Data)
+			_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+				ChatID:      ctx.ChatID,
+				MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+				Text:        text,
+				ReplyMarkup: markup,
+				ParseMode:   models.ParseModeHTML,
+			})
+			return err
+		})
+}
+
+// openSearchResult replaces the result list with the order viewer slider over
+// all matched orders, starting at the picked one
+func openSearchResult(ctx *fsm.ConversationContext[*fsm.SearchData], deps *SearchFlowDeps, idStr string) error {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil
+	}
+	idx := slices.Index(ctx.Data.OrdersIDs, id)
+	if idx < 0 {
+		return nil
+	}
+
+	sliderData := &fsm.OrderSliderData{
+		OrdersIDs:  ctx.Data.OrdersIDs,
+		CurrentIdx: idx,
+	}
+	text, markup, err := orderView(ctx.Ctx, deps.OrderService, sliderData)
+	if err != nil {
+		return ctx.SendMessage(presentation.OrderLoadErrorMsg(), nil)
+	}
+
+	disablePreview := true
+	ctx.Transition(fsm.StepAwaitingOrderViewSliderAction, sliderData)
+	_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+		ChatID:      ctx.ChatID,
+		MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: &disablePreview,
+		},
+		ParseMode: models.ParseModeHTML,
+	})
+	return err
+}
+
+func searchPage(ctx context.Context, orderService order.Service, data *fsm.SearchData) (string, *models.InlineKeyboardMarkup, error) {
+	pages := (len(data.OrdersIDs) + searchPageSize - 1) / searchPageSize
+	offset := data.Page * searchPageSize
+	end := min(offset+searchPageSize, len(data.OrdersIDs))
+
+	orders, err := orderService.GetOrderSummaries(ctx, data.OrdersIDs[offset:end])
+	if err != nil {
+		return "", nil, err
+	}
+	return presentation.SearchResultsMsg(data.Query, len(data.OrdersIDs), offset, orders),
+		presentation.OrderListKbd(orders, offset, data.Page, pages),
+		nil
+}
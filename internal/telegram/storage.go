@@ -0,0 +1,33 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"print3d-order-bot/internal/file"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func (b *Bot) handleStorageCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	report, err := b.fileService.GetDedupReport()
+
+	var text string
+	switch {
+	case errors.Is(err, file.ErrDedupDisabled):
+		text = presentation.DedupDisabledMsg()
+	case err != nil:
+		slog.Error("Failed to build storage report", "error", err)
+		text = presentation.StorageReportErrorMsg()
+	default:
+		text = presentation.StorageReportMsg(report)
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    update.Message.Chat.ID,
+		Text:      text,
+		ParseMode: models.ParseModeHTML,
+	})
+}
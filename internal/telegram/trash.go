@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"context"
+	"print3d-order-bot/internal/file"
+	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func (b *Bot) handleTrashCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	key := fsm.ExtractKey(update)
+	if !can(ctx, user.PermManageStorage) {
+		b.sendText(ctx, key.ChatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	entries, err := listTrash(b.fileService)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    key.ChatID,
+			Text:      presentation.TrashLoadErrorMsg(),
+			ParseMode: models.ParseModeHTML,
+		})
+		return
+	}
+
+	if len(entries) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    key.ChatID,
+			Text:      presentation.EmptyTrashMsg(),
+			ParseMode: models.ParseModeHTML,
+		})
+		return
+	}
+
+	b.tryTransition(key, fsm.StepAwaitingTrashAction, newTrashData(entries))
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      key.ChatID,
+		Text:        presentation.TrashListMsg(entries),
+		ReplyMarkup: presentation.TrashKbd(entries),
+		ParseMode:   models.ParseModeHTML,
+	})
+}
+
+type TrashFlowDeps struct {
+	Router       *fsm.Router
+	FileService  file.Service
+	OrderService order.Service
+}
+
+const trashTTL = time.Hour
+
+func SetupTrashFlow(deps *TrashFlowDeps) {
+	fsm.Chain[*fsm.TrashData](deps.Router, "trash", fsm.StepAwaitingTrashAction).
+		Timeout(trashTTL, presentation.SessionExpiredMsg()).
+		OnCallback(func(ctx *fsm.ConversationContext[*fsm.TrashData], data string) error {
+			if err := ctx.AnswerCallbackQuery("", false); err != nil {
+				return err
+			}
+			idxStr, ok := strings.CutPrefix(data, presentation.TrashRestoreCallbackPrefix)
+			if !ok {
+				return nil
+			}
+			idx, err := strconv.Atoi(idxStr)
+			if err != nil || idx < 0 || idx >= len(ctx.Data.Entries) {
+				return nil
+			}
+
+			// The folder is kept before it is back, so the orphan sweep can't
+			// catch it in between
+			entry := ctx.Data.Entries[idx]
+			trashEntry, ok := file.ParseTrashName(entry)
+			if !ok {
+				return nil
+			}
+			if err := deps.OrderService.KeepRestoredFolder(ctx.Ctx, trashEntry.Folder); err != nil {
+				return ctx.SendMessage(presentation.TrashRestoreErrorMsg(), nil)
+			}
+			folder, err := deps.FileService.RestoreFromTrash(entry)
+			if err != nil {
+				return ctx.SendMessage(presentation.TrashRestoreErrorMsg(), nil)
+			}
+			if err := ctx.SendMessage(presentation.TrashRestoredMsg(folder), nil); err != nil {
+				return err
+			}
+
+			entries, err := listTrash(deps.FileService)
+			if err != nil {
+				return ctx.Complete(presentation.TrashLoadErrorMsg())
+			}
+			if len(entries) == 0 {
+				return ctx.Complete(presentation.EmptyTrashMsg())
+			}
+
+			ctx.Transition(fsm.StepAwaitingTrashAction, newTrashData(entries))
+			_, err = ctx.Bot.EditMessageText(ctx.Ctx, &bot.EditMessageTextParams{
+				ChatID:      ctx.ChatID,
+				MessageID:   ctx.Update.CallbackQuery.Message.Message.ID,
+				Text:        presentation.TrashListMsg(entries),
+				ReplyMarkup: presentation.TrashKbd(entries),
+				ParseMode:   models.ParseModeHTML,
+			})
+			return err
+		})
+}
+
+// listTrash returns the most recently trashed folders, keeping the list
+// within Telegram message and keyboard limits
+func listTrash(fileService file.Service) ([]file.TrashEntry, error) {
+	const maxEntries = 20
+
+	entries, err := fileService.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+	return entries, nil
+}
+
+func newTrashData(entries []file.TrashEntry) *fsm.TrashData {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	return &fsm.TrashData{Entries: names}
+}
@@ -0,0 +1,119 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"print3d-order-bot/internal/telegram/internal/fsm"
+	"print3d-order-bot/internal/telegram/internal/presentation"
+	"print3d-order-bot/internal/user"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func (b *Bot) authorize(ctx context.Context, api *bot.Bot, update *models.Update, key fsm.Key) (context.Context, bool) {
+	u, err := b.userService.Authorize(ctx, key.UserID)
+	if err == nil {
+		return user.ContextWithUser(ctx, u), true
+	}
+
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+		})
+	}
+	// Groups are shared with people who never talk to the bot, so they only
+	// hear back when they address it directly
+	if key.ChatID != key.UserID && update.CallbackQuery == nil && !strings.HasPrefix(update.Message.Text, "/") {
+		return ctx, false
+	}
+
+	text := presentation.GenericErrorMsg()
+	if errors.Is(err, user.ErrUserNotFound) {
+		text = presentation.AccessDeniedMsg(key.UserID)
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    key.ChatID,
+		Text:      text,
+		ParseMode: models.ParseModeHTML,
+	})
+	return ctx, false
+}
+
+func can(ctx context.Context, perm user.Permission) bool {
+	return user.FromContext(ctx).Can(perm)
+}
+
+func (b *Bot) handleInviteCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	chatID, userID := update.Message.Chat.ID, update.Message.From.ID
+	if !can(ctx, user.PermManageUsers) {
+		b.sendText(ctx, chatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		users, err := b.userService.GetUsers(ctx)
+		if err != nil {
+			b.sendText(ctx, chatID, presentation.UsersLoadErrorMsg())
+			return
+		}
+		b.sendText(ctx, chatID, presentation.UsersListMsg(users))
+		return
+	}
+
+	targetID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.sendText(ctx, chatID, presentation.InviteUsageMsg())
+		return
+	}
+	role := user.RoleOperator
+	if len(args) > 1 {
+		role = user.Role(strings.ToLower(args[1]))
+	}
+
+	err = b.userService.Invite(ctx, targetID, role, userID)
+	switch {
+	case errors.Is(err, user.ErrInvalidRole):
+		b.sendText(ctx, chatID, presentation.InviteUsageMsg())
+	case errors.Is(err, user.ErrCannotDemote):
+		b.sendText(ctx, chatID, presentation.CannotChangeOwnerMsg())
+	case err != nil:
+		b.sendText(ctx, chatID, presentation.GenericErrorMsg())
+	default:
+		b.sendText(ctx, chatID, presentation.UserInvitedMsg(targetID, role))
+	}
+}
+
+func (b *Bot) handleRevokeCmd(ctx context.Context, api *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	if !can(ctx, user.PermManageUsers) {
+		b.sendText(ctx, chatID, presentation.PermissionDeniedMsg())
+		return
+	}
+
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		b.sendText(ctx, chatID, presentation.RevokeUsageMsg())
+		return
+	}
+	targetID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.sendText(ctx, chatID, presentation.RevokeUsageMsg())
+		return
+	}
+
+	err = b.userService.Revoke(ctx, targetID)
+	switch {
+	case errors.Is(err, user.ErrUserNotFound):
+		b.sendText(ctx, chatID, presentation.UserNotFoundMsg())
+	case errors.Is(err, user.ErrCannotRevoke):
+		b.sendText(ctx, chatID, presentation.CannotChangeOwnerMsg())
+	case err != nil:
+		b.sendText(ctx, chatID, presentation.GenericErrorMsg())
+	default:
+		b.sendText(ctx, chatID, presentation.UserRevokedMsg(targetID))
+	}
+}
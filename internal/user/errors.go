@@ -0,0 +1,10 @@
+package user
+
+import "errors"
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrInvalidRole  = errors.New("invalid role")
+	ErrCannotRevoke = errors.New("owners can't be revoked")
+	ErrCannotDemote = errors.New("owner role can't be changed")
+)
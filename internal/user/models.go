@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleOwner    Role = "owner"
+	RoleOperator Role = "operator"
+	RoleViewer   Role = "viewer"
+)
+
+func (r Role) IsValid() bool {
+	return r == RoleOwner || r == RoleOperator || r == RoleViewer
+}
+
+type Permission int
+
+const (
+	PermCreateOrders Permission = iota
+	PermEditOrders
+	PermChangeStatus
+	PermViewCosts
+	PermDownloadFiles
+	PermManageStorage
+	PermManageUsers
+	// PermRestoreAnyOrder bypasses the restoration period when restoring orders
+	PermRestoreAnyOrder
+	PermRegisterPayments
+	// PermDeliverUnpaid lets an order be delivered before it is fully paid
+	PermDeliverUnpaid
+	PermManageCatalog
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermCreateOrders, PermEditOrders, PermChangeStatus, PermViewCosts,
+		PermDownloadFiles, PermManageStorage, PermManageUsers, PermRestoreAnyOrder,
+		PermRegisterPayments, PermDeliverUnpaid, PermManageCatalog,
+	},
+	RoleOperator: {
+		PermCreateOrders, PermEditOrders, PermChangeStatus, PermViewCosts,
+		PermDownloadFiles, PermManageStorage, PermRegisterPayments,
+	},
+	RoleViewer: {},
+}
+
+type User struct {
+	ID        int64
+	Role      Role
+	InvitedBy *int64
+	CreatedAt time.Time
+}
+
+// Can reports whether the user's role grants the permission, nil users can't do anything
+func (u *User) Can(perm Permission) bool {
+	if u == nil {
+		return false
+	}
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+type DBUser struct {
+	ID        int64     `db:"id"`
+	Role      Role      `db:"role"`
+	InvitedBy *int64    `db:"invited_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type ctxKey struct{}
+
+func ContextWithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, ctxKey{}, u)
+}
+
+// FromContext returns the user authorized for the update, or nil
+func FromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(ctxKey{}).(*User)
+	return u
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+type Service interface {
+	Authorize(ctx context.Context, id int64) (*User, error)
+	GetUsers(ctx context.Context) ([]User, error)
+	Invite(ctx context.Context, id int64, role Role, invitedBy int64) error
+	Revoke(ctx context.Context, id int64) error
+	SeedOwners(ctx context.Context, ids []int64) error
+}
+
+type DefaultService struct {
+	repo  Repo
+	cache sync.Map
+}
+
+func NewDefaultService(repo Repo) Service {
+	return &DefaultService{
+		repo: repo,
+	}
+}
+
+// Authorize returns the user allowed to use the bot, ErrUserNotFound means
+// the Telegram account was never invited
+func (d *DefaultService) Authorize(ctx context.Context, id int64) (*User, error) {
+	if cached, ok := d.cache.Load(id); ok {
+		return cached.(*User), nil
+	}
+
+	dbUser, err := d.repo.GetUser(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			slog.Error("Error retrieving user", "error", err, "userID", id)
+		}
+		return nil, err
+	}
+
+	user := toUser(*dbUser)
+	d.cache.Store(id, user)
+	return user, nil
+}
+
+func (d *DefaultService) GetUsers(ctx context.Context) ([]User, error) {
+	dbUsers, err := d.repo.GetUsers(ctx)
+	if err != nil {
+		slog.Error("Error retrieving users", "error", err)
+		return nil, err
+	}
+
+	users := make([]User, len(dbUsers))
+	for i, dbUser := range dbUsers {
+		users[i] = *toUser(dbUser)
+	}
+	return users, nil
+}
+
+func (d *DefaultService) Invite(ctx context.Context, id int64, role Role, invitedBy int64) error {
+	if !role.IsValid() || role == RoleOwner {
+		return ErrInvalidRole
+	}
+
+	existing, err := d.repo.GetUser(ctx, id)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		slog.Error("Error retrieving user", "error", err, "userID", id)
+		return err
+	}
+	if existing != nil && existing.Role == RoleOwner {
+		return ErrCannotDemote
+	}
+
+	if err := d.repo.UpsertUser(ctx, DBUser{ID: id, Role: role, InvitedBy: &invitedBy}); err != nil {
+		slog.Error("Error inviting user", "error", err, "userID", id)
+		return err
+	}
+	d.cache.Delete(id)
+	return nil
+}
+
+func (d *DefaultService) Revoke(ctx context.Context, id int64) error {
+	existing, err := d.repo.GetUser(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			slog.Error("Error retrieving user", "error", err, "userID", id)
+		}
+		return err
+	}
+	if existing.Role == RoleOwner {
+		return ErrCannotRevoke
+	}
+
+	if err := d.repo.DeleteUser(ctx, id); err != nil {
+		slog.Error("Error revoking user", "error", err, "userID", id)
+		return err
+	}
+	d.cache.Delete(id)
+	return nil
+}
+
+// SeedOwners makes sure every configured owner exists, so the bot is usable
+// right after the first deploy
+func (d *DefaultService) SeedOwners(ctx context.Context, ids []int64) error {
+	for _, id := range ids {
+		if err := d.repo.UpsertUser(ctx, DBUser{ID: id, Role: RoleOwner}); err != nil {
+			slog.Error("Error seeding owner", "error", err, "userID", id)
+			return err
+		}
+		d.cache.Delete(id)
+	}
+	return nil
+}
+
+func toUser(dbUser DBUser) *User {
+	return &User{
+		ID:        dbUser.ID,
+		Role:      dbUser.Role,
+		InvitedBy: dbUser.InvitedBy,
+		CreatedAt: dbUser.CreatedAt,
+	}
+}
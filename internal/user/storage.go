@@ -0,0 +1,148 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"print3d-order-bot/pkg"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repo interface {
+	GetUser(ctx context.Context, id int64) (*DBUser, error)
+	GetUsers(ctx context.Context) ([]DBUser, error)
+	UpsertUser(ctx context.Context, user DBUser) error
+	DeleteUser(ctx context.Context, id int64) error
+}
+
+type DefaultRepo struct {
+	pool    *pgxpool.Pool
+	builder squirrel.StatementBuilderType
+}
+
+func NewDefaultRepo(pool *pgxpool.Pool) Repo {
+	return &DefaultRepo{
+		pool:    pool,
+		builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (d *DefaultRepo) GetUser(ctx context.Context, id int64) (*DBUser, error) {
+	query, args, err := d.builder.Select("id", "role", "invited_by", "created_at").
+		From("users").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetUser",
+			Err:   err,
+		}
+	}
+
+	var user DBUser
+	if err := d.pool.QueryRow(ctx, query, args...).Scan(&user.ID, &user.Role, &user.InvitedBy, &user.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select user",
+			Info:  fmt.Sprintf("GetUser; query: %s", query),
+			Err:   err,
+		}
+	}
+
+	return &user, nil
+}
+
+func (d *DefaultRepo) GetUsers(ctx context.Context) ([]DBUser, error) {
+	query, args, err := d.builder.Select("id", "role", "invited_by", "created_at").
+		From("users").
+		OrderBy("created_at").
+		ToSql()
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "GetUsers",
+			Err:   err,
+		}
+	}
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, &pkg.ErrDBProcedure{
+			Cause: "failed to select users",
+			Info:  fmt.Sprintf("GetUsers; query: %s", query),
+			Err:   err,
+		}
+	}
+	defer rows.Close()
+
+	var users []DBUser
+	for rows.Next() {
+		var user DBUser
+		if err := rows.Scan(&user.ID, &user.Role, &user.InvitedBy, &user.CreatedAt); err != nil {
+			return nil, &pkg.ErrDBProcedure{
+				Cause: "failed to scan row",
+				Info:  fmt.Sprintf("GetUsers; query: %s", query),
+				Err:   err,
+			}
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (d *DefaultRepo) UpsertUser(ctx context.Context, user DBUser) error {
+	query, args, err := d.builder.Insert("users").
+		Columns("id", "role", "invited_by").
+		Values(user.ID, user.Role, user.InvitedBy).
+		Suffix("on conflict (id) do update set role = excluded.role").
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "UpsertUser",
+			Err:   err,
+		}
+	}
+
+	if _, err := d.pool.Exec(ctx, query, args...); err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to upsert user",
+			Info:  fmt.Sprintf("UpsertUser; query: %s", query),
+			Err:   err,
+		}
+	}
+	return nil
+}
+
+func (d *DefaultRepo) DeleteUser(ctx context.Context, id int64) error {
+	query, args, err := d.builder.Delete("users").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to build query",
+			Info:  "DeleteUser",
+			Err:   err,
+		}
+	}
+
+	tag, err := d.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return &pkg.ErrDBProcedure{
+			Cause: "failed to delete user",
+			Info:  fmt.Sprintf("DeleteUser; query: %s", query),
+			Err:   err,
+		}
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -6,11 +6,16 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"print3d-order-bot/internal/catalog"
+	"print3d-order-bot/internal/client"
 	"print3d-order-bot/internal/file"
 	"print3d-order-bot/internal/mtproto"
 	"print3d-order-bot/internal/order"
+	"print3d-order-bot/internal/quote"
 	"print3d-order-bot/internal/reconciler"
+	"print3d-order-bot/internal/scheduler"
 	"print3d-order-bot/internal/telegram"
+	"print3d-order-bot/internal/user"
 	"print3d-order-bot/pkg/config"
 	"syscall"
 	"time"
@@ -38,15 +43,49 @@ func main() {
 		log.Fatal(err)
 	}
 
-	fileService := file.NewDefaultService(&cfg.FileService)
+	storage, err := file.NewStorage(&cfg.FileService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fileService := file.NewDefaultService(storage)
+
+	pipeline, err := order.NewPipeline(cfg.OrderService.Pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	orderRepo := order.NewDefaultRepo(pool)
-	orderService := order.NewDefaultService(orderRepo)
+	orderRepo := order.NewDefaultRepo(pool, cfg.OrderService.RestorationPeriod)
+	orderService := order.NewDefaultService(orderRepo, pipeline, cfg.OrderService.RestorationPeriod)
 
-	reconcilerService := reconciler.NewDefaultService(orderService, fileService, &cfg.FileService)
+	reconcilerService := reconciler.NewDefaultService(orderService, fileService, &cfg.Reconciler)
 	reconcilerService.Start(ctx)
 
-	bot, err := telegram.NewBot(orderService, fileService, reconcilerService, mtprotoClient, &cfg.TelegramCfg)
+	var watcher *reconciler.Watcher
+	if cfg.Reconciler.Watch && cfg.FileService.Backend != file.BackendS3 {
+		watcher, err = reconciler.NewWatcher(cfg.FileService.DirPath, cfg.Reconciler.WatchDebounce, reconcilerService)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := watcher.Start(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	userRepo := user.NewDefaultRepo(pool)
+	userService := user.NewDefaultService(userRepo)
+	if err := userService.SeedOwners(ctx, cfg.Auth.OwnerIDs); err != nil {
+		log.Fatal(err)
+	}
+
+	clientRepo := client.NewDefaultRepo(pool)
+	clientService := client.NewDefaultService(clientRepo)
+
+	catalogRepo := catalog.NewDefaultRepo(pool)
+	catalogService := catalog.NewDefaultService(catalogRepo)
+
+	quoteEngine := quote.NewDefaultEngine(&cfg.Quote)
+
+	bot, err := telegram.NewBot(ctx, orderService, fileService, reconcilerService, userService, clientService, catalogService, quoteEngine, mtprotoClient, pool, &cfg.TelegramCfg)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -55,6 +94,9 @@ func main() {
 
 	bot.Start(ctx)
 
+	schedulerService := scheduler.NewDefaultService(orderService, bot, &cfg.Scheduler)
+	schedulerService.Start(ctx)
+
 	<-ctx.Done()
 	slog.Info("Shutting down...")
 	ctx, shutdown := context.WithTimeout(context.Background(), time.Second*15)
@@ -63,5 +105,13 @@ func main() {
 	if err := reconcilerService.Stop(ctx); err != nil {
 		log.Fatal(err)
 	}
+	if err := schedulerService.Stop(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if watcher != nil {
+		if err := watcher.Stop(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
 	pool.Close()
 }
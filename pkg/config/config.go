@@ -3,16 +3,22 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	DB          DBConfig
-	FileService FileServiceCfg `yaml:"file_service"`
-	TelegramCfg TelegramCfg    `yaml:"telegram"`
-	MTProtoCfg  MTProtoCfg
+	DB           DBConfig
+	FileService  FileServiceCfg  `yaml:"file_service"`
+	OrderService OrderServiceCfg `yaml:"order_service"`
+	Reconciler   ReconcilerCfg   `yaml:"reconciler"`
+	Scheduler    SchedulerCfg    `yaml:"scheduler"`
+	Quote        QuoteCfg        `yaml:"quote"`
+	TelegramCfg  TelegramCfg     `yaml:"telegram"`
+	Auth         AuthCfg
+	MTProtoCfg   MTProtoCfg
 }
 
 type DBConfig struct {
@@ -21,10 +27,105 @@ type DBConfig struct {
 
 type FileServiceCfg struct {
 	DirPath string `yaml:"dir_path"`
+	// Dedup stores every unique file once and hardlinks it into order folders.
+	// The files are read-only since all linked orders share the content,
+	// editors have to save them as new files
+	Dedup bool `yaml:"dedup"`
+	// Backend is either "local" (default) or "s3", DirPath is used as the key prefix for S3
+	Backend string `yaml:"backend"`
+	S3      S3Cfg  `yaml:"s3"`
+}
+
+type S3Cfg struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	AccessKey string `env:"S3_ACCESS_KEY"`
+	SecretKey string `env:"S3_SECRET_KEY"`
+}
+
+type OrderServiceCfg struct {
+	Pipeline map[string][]string `yaml:"pipeline"`
+	// RestorationPeriod is how long finished orders stay in the active list and
+	// can be restored before their folders are archived, defaults to a day
+	RestorationPeriod time.Duration `yaml:"restoration_period"`
+}
+
+type ReconcilerCfg struct {
+	// Interval of the full sweep over all orders, defaults to an hour
+	Interval time.Duration `yaml:"interval"`
+	// Watch reconciles an order as soon as its folder changes on disk, local backend only
+	Watch          bool          `yaml:"watch"`
+	WatchDebounce  time.Duration `yaml:"watch_debounce"`
+	TrashRetention time.Duration `yaml:"trash_retention"`
+	// DryRun only logs which folders would be quarantined or purged
+	DryRun bool `yaml:"dry_run"`
+}
+
+type SchedulerCfg struct {
+	// Interval between deadline checks, defaults to 10 minutes
+	Interval time.Duration `yaml:"interval"`
+	// RemindBefore is how long before the deadline the responsible operator
+	// is reminded, defaults to a day
+	RemindBefore time.Duration `yaml:"remind_before"`
+	// DigestHour is the local hour the daily overdue digest is sent at
+	DigestHour int `yaml:"digest_hour"`
+}
+
+// QuoteCfg prices the estimate offered while creating an order, zero values
+// fall back to defaults
+type QuoteCfg struct {
+	// MachineRate and LabourRate are hourly, in roubles
+	MachineRate float64 `yaml:"machine_rate"`
+	LabourRate  float64 `yaml:"labour_rate"`
+	// SetupTime is spent once per order on slicing, plate preparation and packing
+	SetupTime time.Duration `yaml:"setup_time"`
+	// PostProcessTime is spent on every printed part
+	PostProcessTime time.Duration `yaml:"post_process_time"`
+	// Markup is added on top of the costs, 0.3 means 30%
+	Markup float64 `yaml:"markup"`
+	// MinPrice is the smallest total ever proposed
+	MinPrice float64     `yaml:"min_price"`
+	FDM      FDMQuoteCfg `yaml:"fdm"`
+	SLA      SLAQuoteCfg `yaml:"sla"`
+}
+
+// FDMQuoteCfg applies to technologies whose materials are priced per gram
+type FDMQuoteCfg struct {
+	// ShellThickness is the depth of the perimeters and top/bottom layers in mm
+	ShellThickness float64 `yaml:"shell_thickness"`
+	// Infill is the percentage of the interior that gets filled
+	Infill float64 `yaml:"infill"`
+	// Density is in g/cm³, Densities override it per material name
+	Density   float64            `yaml:"density"`
+	Densities map[string]float64 `yaml:"densities"`
+	// FlowRate is the average volume extruded per hour in cm³
+	FlowRate float64 `yaml:"flow_rate"`
+	// FilamentDiameter in mm turns the filament length of sliced files into
+	// weight when the slicer didn't report it
+	FilamentDiameter float64 `yaml:"filament_diameter"`
+}
+
+// SLAQuoteCfg applies to technologies whose materials are priced per millilitre
+type SLAQuoteCfg struct {
+	// SupportFactor adds resin for supports and the raft, 0.2 means 20%
+	SupportFactor float64 `yaml:"support_factor"`
+	// LayerHeight in mm and LayerTime give the print time from the tallest part
+	LayerHeight float64       `yaml:"layer_height"`
+	LayerTime   time.Duration `yaml:"layer_time"`
+}
+
+type AuthCfg struct {
+	// OwnerIDs are Telegram user IDs granted the owner role on every start
+	OwnerIDs []int64 `env:"OWNER_IDS" envSeparator:","`
 }
 
 type TelegramCfg struct {
 	Token string `env:"TOKEN,required"`
+	// WorkspaceChatID is the team group where new orders and status changes
+	// are announced, announcements are off when unset
+	WorkspaceChatID int64 `env:"WORKSPACE_CHAT_ID"`
 }
 
 type MTProtoCfg struct {
@@ -1,25 +1,231 @@
-create type order_status as enum ('active', 'closed');
+create extension if not exists pg_trgm;
+
+-- array_to_string is only stable, generated columns need an immutable function
+create function immutable_array_to_string(arr text[]) returns text
+    language sql
+    immutable
+    parallel safe
+as
+$$
+select coalesce(array_to_string(arr, ' '), '')
+$$;
+
+create type order_status as enum (
+    'new',
+    'quoted',
+    'awaiting_prepayment',
+    'queued',
+    'printing',
+    'post_processing',
+    'ready',
+    'delivered',
+    'closed',
+    'cancelled'
+    );
+
+create table clients
+(
+    id         int primary key generated always as identity,
+    name       text        not null,
+    contacts   text[]      not null default '{}',
+    created_at timestamptz not null default now()
+);
+
+create index clients_contacts_idx on clients using gin (contacts);
 
 create table orders
 (
-    id     int primary key generated always as identity,
-    status order_status not null,
-    print_type text not null default 'Неизвестный',
-    client_name  text         not null,
-    cost         real         not null,
-    comments     text[] default '{}',
-    contacts     text[] default '{}',
-    links        text[] default '{}',
-    created_at   timestamptz  not null,
-    closed_at    timestamptz,
-    folder_path  text
+    id                     int primary key generated always as identity,
+    status                 order_status not null,
+    print_type             text         not null default 'Неизвестный',
+    client_id              int          references clients (id) on delete set null,
+    client_name            text         not null,
+    cost                   real         not null,
+    comments               text[]                default '{}',
+    contacts               text[]                default '{}',
+    links                  text[]                default '{}',
+    created_at             timestamptz  not null,
+    due_at                 timestamptz,
+    due_reminded_at        timestamptz,
+    responsible_id         bigint,
+    quoted_at              timestamptz,
+    awaiting_prepayment_at timestamptz,
+    queued_at              timestamptz,
+    printing_at            timestamptz,
+    post_processing_at     timestamptz,
+    ready_at               timestamptz,
+    delivered_at           timestamptz,
+    closed_at              timestamptz,
+    cancelled_at           timestamptz,
+    folder_path            text,
+    archive_path           text,
+    unarchived_at          timestamptz,
+    search_text            text generated always as (
+        client_name || ' ' ||
+        immutable_array_to_string(comments) || ' ' ||
+        immutable_array_to_string(contacts) || ' ' ||
+        immutable_array_to_string(links)
+        ) stored
 );
 
+create index orders_search_text_idx on orders using gin (search_text gin_trgm_ops);
+
+create index orders_client_id_idx on orders (client_id);
+
+create index orders_due_at_idx on orders (due_at) where due_at is not null;
+
 create table order_files
 (
+    id    int primary key generated always as identity,
     name  text not null,
     checksum numeric not null,
     tg_file_id text,
+    -- analysis holds the geometry of STL, OBJ and 3MF models
+    analysis   jsonb,
+    -- gcode holds the print time and filament estimates of sliced files
+    gcode      jsonb,
     order_id   int  not null,
+    foreign key (order_id) references orders (id) on delete cascade,
+    unique (order_id, name)
+);
+
+create table order_items
+(
+    id           int primary key generated always as identity,
+    order_id     int  not null,
+    file_id      int  references order_files (id) on delete set null,
+    position     int  not null,
+    quantity     int  not null check (quantity > 0),
+    material     text not null,
+    color        text not null default '',
+    layer_height real,
+    infill       int check (infill between 0 and 100),
+    unit_price   real not null check (unit_price >= 0),
     foreign key (order_id) references orders (id) on delete cascade
-);
\ No newline at end of file
+);
+
+create index order_items_order_id_idx on order_items (order_id, position);
+
+create type order_event_type as enum (
+    'created',
+    'edited',
+    'status_changed',
+    'file_added',
+    'file_removed',
+    'file_updated',
+    'archived',
+    'unarchived',
+    'payment_added'
+    );
+
+create table order_events
+(
+    id         int primary key generated always as identity,
+    order_id   int              not null,
+    user_id    bigint           not null,
+    type       order_event_type not null,
+    field      text,
+    old_value  text,
+    new_value  text,
+    created_at timestamptz      not null default now(),
+    foreign key (order_id) references orders (id) on delete cascade
+);
+
+create type payment_method as enum ('cash', 'card', 'transfer', 'other');
+
+create table payments
+(
+    id         int primary key generated always as identity,
+    order_id   int            not null,
+    amount     real           not null check (amount > 0),
+    method     payment_method not null,
+    paid_at    timestamptz    not null,
+    note       text,
+    user_id    bigint         not null,
+    created_at timestamptz    not null default now(),
+    foreign key (order_id) references orders (id) on delete cascade
+);
+
+create index payments_order_id_idx on payments (order_id, paid_at);
+
+create index order_files_name_idx on order_files using gin (name gin_trgm_ops);
+
+create index order_events_order_id_idx on order_events (order_id, created_at);
+
+create type price_unit as enum ('g', 'ml');
+
+create table technologies
+(
+    code   text primary key,
+    name   text       not null,
+    unit   price_unit not null,
+    active bool       not null default true
+);
+
+create table materials
+(
+    id         int primary key generated always as identity,
+    technology text not null references technologies (code),
+    name       text not null,
+    price      real not null check (price >= 0),
+    active     bool not null default true
+);
+
+create unique index materials_name_idx on materials (technology, lower(name));
+
+create table colors
+(
+    id     int primary key generated always as identity,
+    name   text not null,
+    active bool not null default true
+);
+
+create unique index colors_name_idx on colors (lower(name));
+
+insert into technologies (code, name, unit)
+values ('FDM', 'FDM', 'g'),
+       ('SLA', 'SLA', 'ml');
+
+insert into materials (technology, name, price)
+values ('FDM', 'PLA', 3),
+       ('FDM', 'PETG', 3.5),
+       ('FDM', 'ABS', 3.5),
+       ('SLA', 'Standard Resin', 8),
+       ('SLA', 'Tough Resin', 12);
+
+insert into colors (name)
+values ('Белый'),
+       ('Чёрный'),
+       ('Серый'),
+       ('Красный'),
+       ('Синий'),
+       ('Прозрачный');
+
+-- restored_folders were brought back from the trash without an order, the
+-- reconciler leaves them alone until someone deals with them
+create table restored_folders
+(
+    folder      text primary key,
+    restored_at timestamptz not null default now()
+);
+
+create table fsm_states
+(
+    chat_id    bigint      not null,
+    user_id    bigint      not null,
+    step       int         not null,
+    data_type  text        not null,
+    data       jsonb       not null,
+    updated_at timestamptz not null default now(),
+    primary key (chat_id, user_id)
+);
+
+create type user_role as enum ('owner', 'operator', 'viewer');
+
+create table users
+(
+    id         bigint primary key,
+    role       user_role   not null,
+    invited_by bigint,
+    created_at timestamptz not null default now()
+);